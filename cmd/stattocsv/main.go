@@ -4,19 +4,52 @@ package main
 // contents are sent to standard output.  Date variables are returned
 // as numeric values with interpretation depending on the date format
 // (e.g. it may be the number of days since January 1, 1960).
+//
+// Numeric values in Stata files are written with the number of
+// decimals given by the variable's fixed display format (e.g. %9.3f),
+// other numeric values are written with six decimals.  The -decimals
+// flag overrides this for all numeric columns.
 
 import (
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/kshedden/datareader"
 )
 
-func doConversion(rdr datareader.StatfileReader) {
+// formatFloat formats x with the given number of decimals, or with
+// six decimals if prec is negative.
+func formatFloat(x float64, prec int) string {
+	if prec < 0 {
+		return fmt.Sprintf("%f", x)
+	}
+	return strconv.FormatFloat(x, 'f', prec, 64)
+}
+
+// stataPrecision returns the number of decimals specified by the
+// display format of each column, or -1 for columns that do not have
+// a fixed format.
+func stataPrecision(stata *datareader.StataReader) []int {
+
+	prec := make([]int, len(stata.Formats))
+	for j, f := range stata.Formats {
+		prec[j] = -1
+		pf, err := datareader.ParseFormat(f)
+		if err == nil && pf.Type == "f" {
+			prec[j] = pf.Precision
+		}
+	}
+
+	return prec
+}
+
+func doConversion(rdr datareader.StatfileReader, prec []int) {
 
 	w := csv.NewWriter(os.Stdout)
 
@@ -66,7 +99,7 @@ func doConversion(rdr datareader.StatfileReader) {
 			for j := 0; j < ncol; j++ {
 				if numbercols[j] != nil {
 					if missing[j] == nil || !missing[j][i] {
-						row[j] = fmt.Sprintf("%f", numbercols[j][i])
+						row[j] = formatFloat(numbercols[j][i], prec[j])
 					} else {
 						row[j] = ""
 					}
@@ -95,12 +128,16 @@ func doConversion(rdr datareader.StatfileReader) {
 
 func main() {
 
-	if len(os.Args) == 1 {
-		fmt.Printf("usage: %s filename\n", os.Args[0])
+	decimals := flag.Int("decimals", -1, "number of decimals for numeric values, by default the display formats are used")
+	longNames := flag.Bool("longnames", false, "use the variable labels of a Stata file as column names")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Printf("usage: %s [-decimals=n] [-longnames] filename\n", os.Args[0])
 		return
 	}
 
-	fname := os.Args[1]
+	fname := flag.Arg(0)
 	f, err := os.Open(fname)
 	if err != nil {
 		os.Stderr.WriteString(fmt.Sprintf("%v\n", err))
@@ -122,6 +159,7 @@ func main() {
 
 	// Get a reader for either a Stata or SAS file
 	var rdr datareader.StatfileReader
+	var prec []int
 	if filetype == "sas" {
 		sas, err := datareader.NewSAS7BDATReader(f)
 		if err != nil {
@@ -138,8 +176,17 @@ func main() {
 		stata.ConvertDates = true
 		stata.InsertCategoryLabels = true
 		stata.InsertStrls = true
+		stata.UseLongNames = *longNames
 		rdr = stata
+		prec = stataPrecision(stata)
+	}
+
+	if prec == nil || *decimals >= 0 {
+		prec = make([]int, len(rdr.ColumnNames()))
+		for j := range prec {
+			prec[j] = *decimals
+		}
 	}
 
-	doConversion(rdr)
+	doConversion(rdr, prec)
 }
@@ -0,0 +1,202 @@
+package datareader
+
+import (
+	"fmt"
+	"io"
+)
+
+// A DataFrame is a collection of Series of the same length, which
+// can be looked up by name.  When read from a Stata file, it also
+// holds the metadata needed to interpret the data.
+type DataFrame struct {
+
+	// The value labels, keyed by label set name and then by value
+	ValueLabels map[string]map[int32]string
+
+	// The name of the value label set of each column, which is
+	// empty for columns without value labels
+	ValueLabelNames []string
+
+	// The format of each column, empty if not known
+	Formats []string
+
+	// The variable label of each column, empty if not known
+	ColumnNamesLong []string
+
+	cols  SeriesArray
+	names map[string]int
+}
+
+// NewDataFrame returns a DataFrame holding the given Series.  An
+// error is returned if the Series do not all have the same length,
+// or their names are not unique.  The Series are not copied.
+func NewDataFrame(cols []*Series) (*DataFrame, error) {
+
+	df := &DataFrame{
+		cols:            cols,
+		names:           make(map[string]int, len(cols)),
+		ValueLabels:     make(map[string]map[int32]string),
+		ValueLabelNames: make([]string, len(cols)),
+		Formats:         make([]string, len(cols)),
+		ColumnNamesLong: make([]string, len(cols)),
+	}
+
+	for j, s := range cols {
+		if s.Length() != cols[0].Length() {
+			return nil, fmt.Errorf("column %s has length %d, expected %d", s.Name, s.Length(), cols[0].Length())
+		}
+		if _, ok := df.names[s.Name]; ok {
+			return nil, fmt.Errorf("duplicate column name %s", s.Name)
+		}
+		df.names[s.Name] = j
+	}
+
+	return df, nil
+}
+
+// ReadDataFrame reads the remaining rows of the file into a DataFrame,
+// along with the value labels, formats and labels of the variables.
+func (rdr *StataReader) ReadDataFrame() (*DataFrame, error) {
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	// No rows remain
+	if ds == nil {
+		ds = make([]*Series, 0)
+	}
+
+	df, err := NewDataFrame(ds)
+	if err != nil {
+		return nil, err
+	}
+
+	if rdr.ValueLabels != nil {
+		df.ValueLabels = rdr.ValueLabels
+	}
+	copy(df.ValueLabelNames, rdr.ValueLabelNames)
+	copy(df.Formats, rdr.Formats)
+	copy(df.ColumnNamesLong, rdr.ColumnNamesLong)
+
+	return df, nil
+}
+
+// Col returns the column with the given name.
+func (df *DataFrame) Col(name string) (*Series, error) {
+
+	j, ok := df.names[name]
+	if !ok {
+		return nil, fmt.Errorf("no column named %s", name)
+	}
+
+	return df.cols[j], nil
+}
+
+// Columns returns the columns of the DataFrame.
+func (df *DataFrame) Columns() SeriesArray {
+	return df.cols
+}
+
+// ColNames returns the names of the columns, in order.
+func (df *DataFrame) ColNames() []string {
+
+	names := make([]string, len(df.cols))
+	for j, s := range df.cols {
+		names[j] = s.Name
+	}
+
+	return names
+}
+
+// NumRows returns the number of rows in the DataFrame.
+func (df *DataFrame) NumRows() int {
+
+	if len(df.cols) == 0 {
+		return 0
+	}
+
+	return df.cols[0].Length()
+}
+
+// NumCols returns the number of columns in the DataFrame.
+func (df *DataFrame) NumCols() int {
+	return len(df.cols)
+}
+
+// Select returns a DataFrame holding the named columns, in the given
+// order.  The formats, variable labels and value label names of the
+// columns are carried along with them, and the value labels of the
+// new DataFrame only include the label sets used by its columns.  The
+// Series are not copied.
+func (df *DataFrame) Select(names ...string) (*DataFrame, error) {
+
+	cols := make([]*Series, len(names))
+	ix := make([]int, len(names))
+	for k, na := range names {
+		j, ok := df.names[na]
+		if !ok {
+			return nil, fmt.Errorf("no column named %s", na)
+		}
+		cols[k] = df.cols[j]
+		ix[k] = j
+	}
+
+	sel, err := NewDataFrame(cols)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, j := range ix {
+		sel.Formats[k] = df.Formats[j]
+		sel.ColumnNamesLong[k] = df.ColumnNamesLong[j]
+		sel.ValueLabelNames[k] = df.ValueLabelNames[j]
+	}
+	sel.ValueLabels = df.usedValueLabels(sel.ValueLabelNames)
+
+	return sel, nil
+}
+
+// usedValueLabels returns the label sets of df.ValueLabels that are
+// named in labelNames.
+func (df *DataFrame) usedValueLabels(labelNames []string) map[string]map[int32]string {
+
+	used := make(map[string]map[int32]string)
+	for _, na := range labelNames {
+		if labels, ok := df.ValueLabels[na]; ok && na != "" {
+			used[na] = labels
+		}
+	}
+
+	return used
+}
+
+// WriteDta writes the DataFrame to w as a version 118 dta file, using
+// the formats, variable labels and value label names of the columns.
+// Label sets that are not used by any column are not written.  String
+// columns, such as columns whose category labels were inserted when
+// reading, do not keep their value label names, and are given a
+// default format if their format is not a string format.
+func (df *DataFrame) WriteDta(w io.WriteSeeker) error {
+
+	wtr, err := NewStataWriter(w, df.cols)
+	if err != nil {
+		return err
+	}
+
+	wtr.Formats = make([]string, len(df.cols))
+	wtr.VariableLabels = df.ColumnNamesLong
+	wtr.ValueLabelNames = make([]string, len(df.cols))
+	for j, s := range df.cols {
+		wtr.Formats[j] = df.Formats[j]
+		if _, ok := s.Data().([]string); !ok {
+			wtr.ValueLabelNames[j] = df.ValueLabelNames[j]
+		} else if pf, err := ParseFormat(df.Formats[j]); err != nil || pf.Type != "s" {
+			wtr.Formats[j] = ""
+		}
+	}
+	wtr.ValueLabels = df.usedValueLabels(wtr.ValueLabelNames)
+
+	return wtr.Write()
+}
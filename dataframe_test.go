@@ -0,0 +1,172 @@
+package datareader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDataFrame(t *testing.T) {
+
+	x, _ := NewSeries("x", []float64{1, 2, 3}, nil)
+	y, _ := NewSeries("y", []string{"a", "b", "c"}, nil)
+	df, err := NewDataFrame([]*Series{x, y})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if df.NumRows() != 3 || df.NumCols() != 2 {
+		t.Errorf("got %d rows and %d columns", df.NumRows(), df.NumCols())
+	}
+	if names := df.ColNames(); names[0] != "x" || names[1] != "y" {
+		t.Errorf("got names %v", names)
+	}
+	if s, err := df.Col("y"); err != nil || s != y {
+		t.Errorf("column y not found")
+	}
+	if _, err := df.Col("z"); err == nil {
+		t.Errorf("expected an error for a missing column")
+	}
+
+	z, _ := NewSeries("x", []float64{1, 2, 3}, nil)
+	if _, err := NewDataFrame([]*Series{x, z}); err == nil {
+		t.Errorf("expected an error for duplicate names")
+	}
+	w, _ := NewSeries("w", []float64{1, 2}, nil)
+	if _, err := NewDataFrame([]*Series{x, w}); err == nil {
+		t.Errorf("expected an error for unequal lengths")
+	}
+}
+
+func TestReadDataFrame(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata4_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.InsertCategoryLabels = false
+
+	df, err := stata.ReadDataFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if df.NumRows() != stata.RowCount() || df.NumCols() != stata.Nvar {
+		t.Errorf("got %d rows and %d columns", df.NumRows(), df.NumCols())
+	}
+
+	s, err := df.Col("fully_labeled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _, err := s.AsInt64Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := df.ValueLabels[df.ValueLabelNames[0]]
+	if labels == nil || labels[int32(x[0])] == "" {
+		t.Errorf("no value label for %d", x[0])
+	}
+	if df.Formats[0] != stata.Formats[0] {
+		t.Errorf("got format %s", df.Formats[0])
+	}
+}
+
+func TestDataFrameWriteDta(t *testing.T) {
+
+	readBack := func(df *DataFrame) *StataReader {
+		f, err := ioutil.TempFile("", "datareader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		if err := df.WriteDta(f); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata
+	}
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata4_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.InsertCategoryLabels = false
+	df, err := stata.ReadDataFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Reorder the columns and drop the only column labeled with
+	// incomplete_lbl
+	sel, err := df.Select("labeled_with_missings", "float_labelled", "fully_labeled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sel.ValueLabels["incomplete_lbl"]; ok || len(sel.ValueLabels) != 2 {
+		t.Errorf("unexpected label sets in the selection")
+	}
+
+	out := readBack(sel)
+	for k, j := range []int{3, 4, 0} {
+		if out.ColumnNames()[k] != stata.ColumnNames()[j] || out.Formats[k] != stata.Formats[j] ||
+			out.ColumnNamesLong[k] != stata.ColumnNamesLong[j] || out.ValueLabelNames[k] != stata.ValueLabelNames[j] {
+			t.Errorf("metadata of column %d does not follow the column", k)
+		}
+	}
+	if len(out.ValueLabels) != 2 || out.ValueLabels["incomplete_lbl"] != nil {
+		t.Errorf("unexpected label sets %v", out.ValueLabels)
+	}
+	for k, v := range df.ValueLabels["missing_lbl"] {
+		if out.ValueLabels["missing_lbl"][k] != v {
+			t.Errorf("label %d is %q, expected %q", k, out.ValueLabels["missing_lbl"][k], v)
+		}
+	}
+	out.InsertCategoryLabels = false
+	ds, err := out.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := df.Col("fully_labeled")
+	if eq, i := ds[2].AllEqual(x); !eq {
+		t.Errorf("fully_labeled differs at row %d", i)
+	}
+
+	// Columns with inserted labels are written as strings without
+	// value labels
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	df, err = stata.ReadDataFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = readBack(df)
+	if len(out.ValueLabels) != 0 || out.ValueLabelNames[0] != "" || out.Formats[0] == stata.Formats[0] {
+		t.Errorf("unexpected value labels or formats for string columns")
+	}
+
+	if _, err := df.Select("fully_labeled", "no_such_column"); err == nil {
+		t.Errorf("expected an error for a missing column")
+	}
+}
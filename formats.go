@@ -0,0 +1,529 @@
+package datareader
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParsedFormat is the parsed representation of a Stata display
+// format such as "%9.2f" or "%-20s".
+type ParsedFormat struct {
+
+	// The display width, zero if not specified.
+	Width int
+
+	// The number of digits displayed after the decimal point, -1
+	// if not specified.
+	Precision int
+
+	// The format type letter, one of "f", "g", "e", "s", or "x".
+	// Date formats have type "t".
+	Type string
+
+	// True if the values are left-aligned ("%-20s"), otherwise
+	// they are right-aligned.
+	LeftAlign bool
+
+	// For date formats, the kind of date without any display
+	// details, e.g. "%td" for "%tdCCYY-NN-DD".
+	Date string
+
+	// For date formats, the display template that follows the kind
+	// of date, e.g. "CCYY-NN-DD" for "%tdCCYY-NN-DD".  Empty if the
+	// default display is used.
+	Template string
+
+	// True if numbers are displayed with commas separating the
+	// thousands ("%10.2fc").
+	Comma bool
+}
+
+// ParseFormat parses a Stata display format string.
+func ParseFormat(format string) (ParsedFormat, error) {
+
+	pf := ParsedFormat{Precision: -1}
+
+	if !strings.HasPrefix(format, "%") {
+		return pf, fmt.Errorf("invalid Stata format %q", format)
+	}
+	pf.LeftAlign = strings.HasPrefix(format, "%-")
+	s := strings.TrimLeft(format[1:], "-~0")
+
+	// Date formats, e.g. %td or %tdCCYY-NN-DD
+	if strings.HasPrefix(s, "t") {
+		if len(s) < 2 {
+			return pf, fmt.Errorf("invalid Stata format %q", format)
+		}
+		pf.Type = "t"
+		pf.Date = "%" + s[0:2]
+		pf.Template = s[2:]
+		return pf, nil
+	}
+
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i > 0 {
+		pf.Width, _ = strconv.Atoi(s[0:i])
+	}
+	s = s[i:]
+
+	if strings.HasPrefix(s, ".") {
+		i = 1
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 1 {
+			return pf, fmt.Errorf("invalid Stata format %q", format)
+		}
+		pf.Precision, _ = strconv.Atoi(s[1:i])
+		s = s[i:]
+	}
+
+	if len(s) == 0 || !strings.Contains("fgesx", s[0:1]) {
+		return pf, fmt.Errorf("invalid Stata format %q", format)
+	}
+	pf.Type = s[0:1]
+	pf.Comma = strings.HasPrefix(s[1:], "c")
+
+	return pf, nil
+}
+
+// FormatSpec returns the parsed display format of the variable in
+// the given column.
+func (rdr *StataReader) FormatSpec(col int) (ParsedFormat, error) {
+
+	if col < 0 || col >= len(rdr.Formats) {
+		return ParsedFormat{Precision: -1}, fmt.Errorf("column %d is out of range", col)
+	}
+
+	return ParseFormat(rdr.Formats[col])
+}
+
+// DateFormatTemplate returns the display template of the date format
+// of the variable in the given column, e.g. "CCYY.NN.DD" for the
+// format "%tdCCYY.NN.DD".  The empty string is returned if the
+// variable does not have a date format, or if its format has no
+// template.
+func (rdr *StataReader) DateFormatTemplate(col int) string {
+
+	pf, err := rdr.FormatSpec(col)
+	if err != nil || pf.Type != "t" {
+		return ""
+	}
+
+	return pf.Template
+}
+
+// The Go layout fragments for the components of Stata's date display
+// templates.  The components that are missing have no equivalent in
+// Go layouts.
+var goLayoutTokens = map[string]string{
+	"CCYY":    "2006",
+	"YY":      "06",
+	"NN":      "01",
+	"nn":      "1",
+	"DD":      "02",
+	"dd":      "2",
+	"JJJ":     "002",
+	"Month":   "January",
+	"Mon":     "Jan",
+	"Dayname": "Monday",
+	"Day":     "Mon",
+	"HH":      "15",
+	"Hh":      "03",
+	"hh":      "3",
+	"MM":      "04",
+	"mm":      "4",
+	"SS":      "05",
+	"ss":      "5",
+	".s":      ".0",
+	".ss":     ".00",
+	".sss":    ".000",
+	"am":      "pm",
+	"pm":      "pm",
+	"AM":      "PM",
+	"PM":      "PM",
+}
+
+// GoDateLayout translates the template of a Stata date format (e.g.
+// "%tdCCYY.NN.DD") to a layout for time.Time.Format (e.g.
+// "2006.01.02").  Formats without a template are translated using
+// the default template of the kind of date, e.g. "DDmonCCYY" for
+// %td.  An error is returned if the format is not a date format, or
+// if its template has components that Go layouts cannot express,
+// such as lower case month names, week and quarter numbers, or
+// literal letters and digits.  FormatValue renders all templates.
+func GoDateLayout(format string) (string, error) {
+
+	pf, err := ParseFormat(format)
+	if err != nil {
+		return "", err
+	}
+	if pf.Type != "t" {
+		return "", fmt.Errorf("%s is not a date format", format)
+	}
+
+	tmpl := pf.Template
+	if tmpl == "" {
+		tmpl = defaultDateTemplates[pf.Date]
+	}
+
+	var b strings.Builder
+	for len(tmpl) > 0 {
+		if strings.HasPrefix(tmpl, "CCYY") {
+			b.WriteString(goLayoutTokens["CCYY"])
+			tmpl = tmpl[4:]
+			continue
+		}
+
+		tok := ""
+		for _, tk := range dateTokens {
+			if strings.HasPrefix(tmpl, tk) {
+				tok = tk
+				break
+			}
+		}
+		if tok != "" {
+			frag, ok := goLayoutTokens[tok]
+			if !ok {
+				return "", fmt.Errorf("%s in format %s has no Go layout equivalent", tok, format)
+			}
+			b.WriteString(frag)
+			tmpl = tmpl[len(tok):]
+			continue
+		}
+
+		c := tmpl[0]
+		switch {
+		case c == '_':
+			b.WriteByte(' ')
+		case c == '+':
+		case c == '!' && len(tmpl) > 1:
+			tmpl = tmpl[1:]
+			c = tmpl[0]
+			fallthrough
+		default:
+			if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+				return "", fmt.Errorf("literal %q in format %s has no Go layout equivalent", c, format)
+			}
+			b.WriteByte(c)
+		}
+		tmpl = tmpl[1:]
+	}
+
+	return b.String(), nil
+}
+
+// FormatValue returns the text that Stata displays for the value v of
+// the variable in the given column, according to the display format
+// of the variable.  Numbers may be of any Go numeric type, and are
+// padded to the width of the format.  The values of date variables
+// may be time.Time values, as returned by Read, or numbers in Stata's
+// units, and are displayed using the template of the format (e.g.
+// "%tdDD/NN/CCYY"), or Stata's default display if there is none.
+// Strings, such as inserted category labels, are padded to the width
+// of the format.  nil is displayed as "." and a MissingCode as its
+// Stata representation.
+func (rdr *StataReader) FormatValue(col int, v interface{}) (string, error) {
+
+	pf, err := rdr.FormatSpec(col)
+	if err != nil {
+		return "", err
+	}
+
+	pad := func(s string) string {
+		if pf.LeftAlign {
+			return fmt.Sprintf("%-*s", pf.Width, s)
+		}
+		return fmt.Sprintf("%*s", pf.Width, s)
+	}
+
+	switch x := v.(type) {
+	case nil:
+		return pad("."), nil
+	case MissingCode:
+		return pad(x.String()), nil
+	case string:
+		return pad(x), nil
+	case time.Time:
+		if pf.Type != "t" {
+			return "", fmt.Errorf("column %d has format %s, cannot display a time", col, rdr.Formats[col])
+		}
+		return formatDate(x, pf), nil
+	}
+
+	y, ok := numericValue(v)
+	if !ok {
+		return "", fmt.Errorf("cannot display a value of type %T", v)
+	}
+
+	switch pf.Type {
+	case "t":
+		for _, df := range dateFormats {
+			if pf.Date == df {
+				t := rdr.doConvertDates([]float64{y}, []bool{false}, pf.Date).([]time.Time)[0]
+				return formatDate(t, pf), nil
+			}
+		}
+		return "", fmt.Errorf("column %d has format %s, which cannot be displayed", col, rdr.Formats[col])
+	case "s":
+		return "", fmt.Errorf("column %d has format %s, cannot display a number", col, rdr.Formats[col])
+	default:
+		return pad(formatNumber(y, pf)), nil
+	}
+}
+
+// numericValue returns the number held in x, which may be of any Go
+// numeric type or bool.
+func numericValue(x interface{}) (float64, bool) {
+
+	switch x := x.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	case uint8:
+		return float64(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// formatNumber formats x using a numeric display format.  The general
+// format (g) uses as many significant digits as fit within the width
+// of the format.
+func formatNumber(x float64, pf ParsedFormat) string {
+
+	var s string
+	switch pf.Type {
+	case "f":
+		s = strconv.FormatFloat(x, 'f', pf.Precision, 64)
+	case "e":
+		s = strconv.FormatFloat(x, 'e', pf.Precision, 64)
+	case "x":
+		s = strconv.FormatFloat(x, 'x', -1, 64)
+	default:
+		s = strconv.FormatFloat(x, 'g', -1, 64)
+		if x == math.Trunc(x) && math.Abs(x) < 1e15 {
+			s = strconv.FormatFloat(x, 'f', 0, 64)
+		}
+		for prec := 15; len(s) > pf.Width && prec > 0 && pf.Width > 0; prec-- {
+			s = strconv.FormatFloat(x, 'g', prec, 64)
+		}
+	}
+
+	if pf.Comma {
+		s = insertCommas(s)
+	}
+
+	return s
+}
+
+// insertCommas inserts commas between the groups of three digits in
+// the integer part of the number in s.
+func insertCommas(s string) string {
+
+	i := 0
+	if strings.HasPrefix(s, "-") {
+		i = 1
+	}
+	j := i
+	for j < len(s) && s[j] >= '0' && s[j] <= '9' {
+		j++
+	}
+
+	var b strings.Builder
+	b.WriteString(s[0:i])
+	for k := i; k < j; k++ {
+		if k > i && (j-k)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(s[k])
+	}
+	b.WriteString(s[j:])
+
+	return b.String()
+}
+
+// The default display templates of the date formats
+var defaultDateTemplates = map[string]string{
+	"%tc": "DDmonCCYY_HH:MM:SS",
+	"%tC": "DDmonCCYY_HH:MM:SS",
+	"%td": "DDmonCCYY",
+	"%tw": "CCYY!www",
+	"%tm": "CCYY!mnn",
+	"%tq": "CCYY!qq",
+	"%th": "CCYY!hh",
+	"%ty": "CCYY",
+}
+
+// The components of Stata's date display templates, longest first
+// where one is a prefix of another.
+var dateTokens = []string{
+	"Dayname", "dayname", "Month", "month", "a.m.", "A.M.", "p.m.", "P.M.",
+	".sss", "JJJ", "jjj", "Mon", "mon", "Day", "day", ".ss",
+	"CC", "cc", "YY", "yy", "NN", "nn", "DD", "dd", "WW", "ww",
+	"HH", "Hh", "hH", "hh", "MM", "mm", "SS", "ss", "Da", "da",
+	"am", "pm", "AM", "PM", ".s", "q", "h",
+}
+
+// formatDate displays the time t according to the template of the
+// date format pf, or the default template if the format has none.
+func formatDate(t time.Time, pf ParsedFormat) string {
+
+	t = t.UTC()
+	tmpl := pf.Template
+	if tmpl == "" {
+		tmpl = defaultDateTemplates[pf.Date]
+	}
+
+	// The hour on a 12 hour clock
+	h12 := t.Hour() % 12
+	if h12 == 0 {
+		h12 = 12
+	}
+	pm := t.Hour() >= 12
+	ampm := func(am, pmText string) string {
+		if pm {
+			return pmText
+		}
+		return am
+	}
+	week := (t.YearDay()-1)/7 + 1
+	if week > 52 {
+		week = 52
+	}
+	ms := t.Nanosecond() / 1e6
+
+	var b strings.Builder
+	for len(tmpl) > 0 {
+		tok := ""
+		for _, tk := range dateTokens {
+			if strings.HasPrefix(tmpl, tk) {
+				tok = tk
+				break
+			}
+		}
+
+		switch tok {
+		case "CC":
+			fmt.Fprintf(&b, "%02d", t.Year()/100)
+		case "cc":
+			fmt.Fprintf(&b, "%d", t.Year()/100)
+		case "YY":
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case "yy":
+			fmt.Fprintf(&b, "%d", t.Year()%100)
+		case "JJJ":
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case "jjj":
+			fmt.Fprintf(&b, "%d", t.YearDay())
+		case "Month":
+			b.WriteString(t.Month().String())
+		case "month":
+			b.WriteString(strings.ToLower(t.Month().String()))
+		case "Mon":
+			b.WriteString(t.Month().String()[0:3])
+		case "mon":
+			b.WriteString(strings.ToLower(t.Month().String()[0:3]))
+		case "NN":
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case "nn":
+			fmt.Fprintf(&b, "%d", int(t.Month()))
+		case "DD":
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case "dd":
+			fmt.Fprintf(&b, "%d", t.Day())
+		case "Dayname":
+			b.WriteString(t.Weekday().String())
+		case "dayname":
+			b.WriteString(strings.ToLower(t.Weekday().String()))
+		case "Day":
+			b.WriteString(t.Weekday().String()[0:3])
+		case "day":
+			b.WriteString(strings.ToLower(t.Weekday().String()[0:3]))
+		case "Da":
+			b.WriteString(t.Weekday().String()[0:2])
+		case "da":
+			b.WriteString(strings.ToLower(t.Weekday().String()[0:2]))
+		case "WW":
+			fmt.Fprintf(&b, "%02d", week)
+		case "ww":
+			fmt.Fprintf(&b, "%d", week)
+		case "q":
+			fmt.Fprintf(&b, "%d", (int(t.Month())-1)/3+1)
+		case "h":
+			fmt.Fprintf(&b, "%d", (int(t.Month())-1)/6+1)
+		case "HH":
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case "hH":
+			fmt.Fprintf(&b, "%d", t.Hour())
+		case "Hh":
+			fmt.Fprintf(&b, "%02d", h12)
+		case "hh":
+			fmt.Fprintf(&b, "%d", h12)
+		case "MM":
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case "mm":
+			fmt.Fprintf(&b, "%d", t.Minute())
+		case "SS":
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case "ss":
+			fmt.Fprintf(&b, "%d", t.Second())
+		case ".s":
+			fmt.Fprintf(&b, ".%d", ms/100)
+		case ".ss":
+			fmt.Fprintf(&b, ".%02d", ms/10)
+		case ".sss":
+			fmt.Fprintf(&b, ".%03d", ms)
+		case "am", "pm":
+			b.WriteString(ampm("am", "pm"))
+		case "AM", "PM":
+			b.WriteString(ampm("AM", "PM"))
+		case "a.m.", "p.m.":
+			b.WriteString(ampm("a.m.", "p.m."))
+		case "A.M.", "P.M.":
+			b.WriteString(ampm("A.M.", "P.M."))
+		case "":
+			// Literal text, where _ is a space and ! quotes the
+			// following character
+			c := tmpl[0:1]
+			switch {
+			case c == "_":
+				b.WriteString(" ")
+			case c == "!" && len(tmpl) > 1:
+				tok = tmpl[0:2]
+				b.WriteString(tmpl[1:2])
+			case c == "+":
+			default:
+				b.WriteString(c)
+			}
+			if tok == "" {
+				tok = c
+			}
+		}
+		tmpl = tmpl[len(tok):]
+	}
+
+	return b.String()
+}
@@ -0,0 +1,186 @@
+package datareader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+
+	for _, tc := range []struct {
+		format string
+		pf     ParsedFormat
+	}{
+		{"%9.2f", ParsedFormat{Width: 9, Precision: 2, Type: "f"}},
+		{"%9.0g", ParsedFormat{Width: 9, Precision: 0, Type: "g"}},
+		{"%10.3fc", ParsedFormat{Width: 10, Precision: 3, Type: "f", Comma: true}},
+		{"%-20s", ParsedFormat{Width: 20, Precision: -1, Type: "s", LeftAlign: true}},
+		{"%-9.0g", ParsedFormat{Width: 9, Precision: 0, Type: "g", LeftAlign: true}},
+		{"%244s", ParsedFormat{Width: 244, Precision: -1, Type: "s"}},
+		{"%td", ParsedFormat{Precision: -1, Type: "t", Date: "%td"}},
+		{"%tcHH:MM", ParsedFormat{Precision: -1, Type: "t", Date: "%tc", Template: "HH:MM"}},
+		{"%-tdCCYY", ParsedFormat{Precision: -1, Type: "t", Date: "%td", Template: "CCYY", LeftAlign: true}},
+	} {
+		pf, err := ParseFormat(tc.format)
+		if err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if pf != tc.pf {
+			t.Errorf("%s: got %+v, expected %+v", tc.format, pf, tc.pf)
+		}
+	}
+
+	for _, f := range []string{"", "9.2f", "%9.f", "%9.2q", "%t"} {
+		if _, err := ParseFormat(f); err == nil {
+			t.Errorf("expected an error for format %q", f)
+		}
+	}
+}
+
+func TestFormatSpec(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata2_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for j := range stata.Formats {
+		pf, err := stata.FormatSpec(j)
+		if err != nil {
+			t.Errorf("column %d: %v", j, err)
+			continue
+		}
+		var isDate bool
+		for _, f := range dateFormats {
+			isDate = isDate || pf.Date == f
+		}
+		if isDate != stata.isDate[j] {
+			t.Errorf("column %d: format %s has date type %q", j, stata.Formats[j], pf.Date)
+		}
+	}
+
+	if _, err := stata.FormatSpec(stata.Nvar); err == nil {
+		t.Errorf("expected an error for a column that is out of range")
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+
+	formats := []string{"%9.2f", "%12.2fc", "%9.0g", "%10.3e", "%-8s", "%td", "%tdDD/NN/CCYY",
+		"%tdCCYY-NN-DD", "%tcHH:MM:SS", "%tc", "%tm", "%tq", "%tdDayname,_Month_dd,_CCYY", "%tb"}
+	stata := &StataReader{Formats: formats}
+
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		col int
+		v   interface{}
+		s   string
+	}{
+		{0, 3.14159, "     3.14"},
+		{0, int16(-2), "    -2.00"},
+		{0, nil, "        ."},
+		{0, MissingSystem + 2, "       .b"},
+		{1, 1234567.891, "1,234,567.89"},
+		{1, -1234.5, "   -1,234.50"},
+		{2, 3, "        3"},
+		{2, 0.5, "      0.5"},
+		{2, 1.0 / 3, "0.3333333"},
+		{3, 12345.678, " 1.235e+04"},
+		{4, "abc", "abc     "},
+		{5, 0, "01jan1960"},
+		{5, day, "03feb2001"},
+		{6, day, "03/02/2001"},
+		{7, int32(15009), "2001-02-03"},
+		{8, day.Add(13*time.Hour + 4*time.Minute + 5*time.Second), "13:04:05"},
+		{9, 1000.0, "01jan1960 00:00:01"},
+		{10, 13, "1961m2"},
+		{11, -1, "1959q4"},
+		{12, day, "Saturday, February 3, 2001"},
+	} {
+		s, err := stata.FormatValue(tc.col, tc.v)
+		if err != nil {
+			t.Errorf("%s: %v", formats[tc.col], err)
+			continue
+		}
+		if s != tc.s {
+			t.Errorf("%s: got %q for %v, expected %q", formats[tc.col], s, tc.v, tc.s)
+		}
+	}
+
+	for _, tc := range []struct {
+		col int
+		v   interface{}
+	}{
+		{4, 1.0},
+		{0, day},
+		{0, []int{1}},
+		{13, 1.0},
+		{len(formats), 1.0},
+	} {
+		if _, err := stata.FormatValue(tc.col, tc.v); err == nil {
+			t.Errorf("expected an error for %v with column %d", tc.v, tc.col)
+		}
+	}
+}
+
+func TestGoDateLayout(t *testing.T) {
+
+	day := time.Date(2001, 2, 3, 14, 5, 6, 789e6, time.UTC)
+	for _, tc := range []struct {
+		format string
+		layout string
+		text   string
+	}{
+		{"%tdCCYY.NN.DD", "2006.01.02", "2001.02.03"},
+		{"%tdDD/NN/YY", "02/01/06", "03/02/01"},
+		{"%tdMon_dd,_CCYY", "Jan 2, 2006", "Feb 3, 2001"},
+		{"%tdDayname!,_Month_dd", "Monday, January 2", "Saturday, February 3"},
+		{"%tcCCYY-NN-DD_HH:MM:SS.sss", "2006-01-02 15:04:05.000", "2001-02-03 14:05:06.789"},
+		{"%tchh:MM_AM", "3:04 PM", "2:05 PM"},
+		{"%tmCCYY-NN", "2006-01", "2001-02"},
+	} {
+		layout, err := GoDateLayout(tc.format)
+		if err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if layout != tc.layout {
+			t.Errorf("%s: got layout %q, expected %q", tc.format, layout, tc.layout)
+		}
+		if s := day.Format(layout); s != tc.text {
+			t.Errorf("%s: got %q, expected %q", tc.format, s, tc.text)
+		}
+		pf, _ := ParseFormat(tc.format)
+		if s := formatDate(day, pf); s != tc.text {
+			t.Errorf("%s: formatDate gives %q, expected %q", tc.format, s, tc.text)
+		}
+	}
+
+	for _, f := range []string{"%td", "%tw", "%tdCC", "%tdDD!xNN", "%9.2f", "%tq"} {
+		if _, err := GoDateLayout(f); err == nil {
+			t.Errorf("expected an error for format %s", f)
+		}
+	}
+}
+
+func TestDateFormatTemplate(t *testing.T) {
+
+	stata := &StataReader{Formats: []string{"%tdCCYY.NN.DD", "%td", "%9.2f", "%-tcHH:MM"}}
+	for j, e := range []string{"CCYY.NN.DD", "", "", "HH:MM"} {
+		if s := stata.DateFormatTemplate(j); s != e {
+			t.Errorf("column %d: got template %q, expected %q", j, s, e)
+		}
+	}
+	if s := stata.DateFormatTemplate(4); s != "" {
+		t.Errorf("got template %q for a column that is out of range", s)
+	}
+}
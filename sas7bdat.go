@@ -542,11 +542,10 @@ func (sas *SAS7BDAT) readBytes(offset, length int) error {
 		if _, err := sas.file.Seek(int64(offset), 0); err != nil {
 			panic(err)
 		}
-		n, err := sas.file.Read(sas.buf[0:length])
-		if err != nil {
-			return err
-		} else if n < length {
+		if _, err := io.ReadFull(sas.file, sas.buf[0:length]); err == io.ErrUnexpectedEOF || err == io.EOF {
 			return fmt.Errorf("Unable to read %d bytes from file position %d.", length, offset)
+		} else if err != nil {
+			return err
 		}
 	} else {
 		if offset+length > len(sas.cachedPage) {
@@ -846,18 +845,16 @@ func (sas *SAS7BDAT) readNextPage() (error, bool) {
 
 	sas.currentPageDataSubheaderPointers = make([]*subheaderPointer, 0, 10)
 	sas.cachedPage = make([]byte, sas.properties.pageLength)
-	n, err := sas.file.Read(sas.cachedPage)
-	if n <= 0 {
+	n, err := io.ReadFull(sas.file, sas.cachedPage)
+	if err == io.EOF {
 		return nil, true
 	}
 
-	if err != nil && err != io.EOF {
-		return err, false
-	}
-
-	if len(sas.cachedPage) != sas.properties.pageLength {
+	if err == io.ErrUnexpectedEOF {
 		return fmt.Errorf("failed to read complete page from file (read %d of %d bytes)",
-			len(sas.cachedPage), sas.properties.pageLength), false
+			n, sas.properties.pageLength), false
+	} else if err != nil {
+		return err, false
 	}
 
 	if err := sas.readPageHeader(); err != nil {
@@ -992,13 +989,12 @@ func (sas *SAS7BDAT) getProperties() error {
 
 	// Read the rest of the header into cachedPage.
 	v := make([]byte, prop.headerLength-288)
-	if _, err := sas.file.Read(v); err != nil {
+	if _, err := io.ReadFull(sas.file, v); err == io.ErrUnexpectedEOF || err == io.EOF {
+		return fmt.Errorf("The SAS7BDAT file appears to be truncated.")
+	} else if err != nil {
 		return err
 	}
 	sas.cachedPage = append(sas.cachedPage, v...)
-	if len(sas.cachedPage) != prop.headerLength {
-		return fmt.Errorf("The SAS7BDAT file appears to be truncated.")
-	}
 
 	prop.pageLength, err = sas.readInt(page_size_offset+align1, page_size_length)
 	if err != nil {
@@ -1525,15 +1521,14 @@ func (sas *SAS7BDAT) ColumnTypes() []ColumnTypeT {
 func (sas *SAS7BDAT) parseMetadata() error {
 
 	for {
-		n, err := sas.file.Read(sas.cachedPage)
-		if n <= 0 {
+		_, err := io.ReadFull(sas.file, sas.cachedPage)
+		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			return err
-		}
-		if n != sas.properties.pageLength {
+		if err == io.ErrUnexpectedEOF {
 			return fmt.Errorf("Failed to read a meta data page from the SAS file.")
+		} else if err != nil {
+			return err
 		}
 		var done bool
 		if done, err = sas.processPageMeta(); err != nil {
@@ -1,7 +1,10 @@
 package datareader
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
@@ -164,3 +167,31 @@ func TestSASGenerated2(t *testing.T) {
 		}
 	}
 }
+
+func TestSASShortReads(t *testing.T) {
+
+	for _, fname := range []string{"test1.sas7bdat", "test16.sas7bdat"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var cols [][]*Series
+		for _, r := range []io.ReadSeeker{bytes.NewReader(b), &shortReader{r: bytes.NewReader(b)}} {
+			sas, err := NewSAS7BDATReader(r)
+			if err != nil {
+				t.Fatalf("%s: %v", fname, err)
+			}
+			ds, err := sas.Read(-1)
+			if err != nil {
+				t.Fatalf("%s: %v", fname, err)
+			}
+			cols = append(cols, ds)
+		}
+
+		if eq, j, i := SeriesArray(cols[0]).AllEqual(cols[1]); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package datareader
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"math"
+	"math/rand"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -16,6 +19,11 @@ type Series struct {
 	// A name describing what is in this series.
 	Name string
 
+	// Arbitrary metadata supplied by the caller.  It is not used
+	// by this package, but is carried over to the Series derived
+	// from this Series by its methods.  It is nil until set.
+	Meta map[string]string
+
 	// The length of the series.
 	length int
 
@@ -25,6 +33,34 @@ type Series struct {
 	// Indicators that data values are missing.  If nil, there are
 	// no missing values.
 	missing []bool
+
+	// The kind of each missing value, if known.
+	missingCodes []MissingCode
+}
+
+// MissingCode indicates the kind of a missing value.  Stata has a
+// system missing value (".") and 26 extended missing values (".a"
+// through ".z"), which are MissingSystem+1 through MissingSystem+26.
+// The zero value indicates that a value is not missing, or that the
+// kind of missing value is not known.
+type MissingCode uint8
+
+// MissingSystem is the code of Stata's system missing value.
+const MissingSystem MissingCode = 1
+
+// String returns the Stata representation of the missing value code,
+// e.g. "." or ".a", or the empty string for the zero code.
+func (c MissingCode) String() string {
+	switch {
+	case c == 0:
+		return ""
+	case c == MissingSystem:
+		return "."
+	case c <= MissingSystem+26:
+		return "." + string(rune('a'+c-MissingSystem-1))
+	default:
+		return fmt.Sprintf("MissingCode(%d)", c)
+	}
 }
 
 // ilen returns the length of a slice, held in an interface value.
@@ -51,6 +87,10 @@ func ilen(data interface{}) (int, error) {
 		return len(data.([]uint64)), nil
 	case []time.Time:
 		return len(data.([]time.Time)), nil
+	case []bool:
+		return len(data.([]bool)), nil
+	case [][]byte:
+		return len(data.([][]byte)), nil
 	default:
 		return 0, fmt.Errorf("Unknown data type")
 	}
@@ -75,6 +115,30 @@ func NewSeries(name string, data interface{}, missing []bool) (*Series, error) {
 	return &ser, nil
 }
 
+// derive returns a new Series with the given data and missing value
+// indicators, and with the name and metadata of this Series.
+func (ser *Series) derive(data interface{}, missing []bool) *Series {
+
+	s, err := NewSeries(ser.Name, data, missing)
+	if err != nil {
+		panic(err)
+	}
+
+	if ser.Meta != nil {
+		s.Meta = make(map[string]string, len(ser.Meta))
+		for k, v := range ser.Meta {
+			s.Meta[k] = v
+		}
+	}
+
+	// Methods that change the rows must set the codes themselves.
+	if ser.missingCodes != nil && len(ser.missingCodes) == s.length {
+		s.missingCodes = ser.missingCodes
+	}
+
+	return s
+}
+
 // Write writes the entire Series to the given writer.
 func (ser *Series) Write(w io.Writer) {
 	ser.WriteRange(w, 0, ser.length)
@@ -218,6 +282,34 @@ func (ser *Series) WriteRange(w io.Writer, first, last int) {
 				}
 			}
 		}
+	case []bool:
+		data := ser.data.([]bool)
+		for j := first; j < last; j++ {
+			if ser.missing == nil || !ser.missing[j] {
+				s := fmt.Sprintf("%d:  %t\n", j, data[j])
+				if _, err := io.WriteString(w, s); err != nil {
+					panic(err)
+				}
+			} else {
+				if _, err := io.WriteString(w, fmt.Sprintf("%d:\n", j)); err != nil {
+					panic(err)
+				}
+			}
+		}
+	case [][]byte:
+		data := ser.data.([][]byte)
+		for j := first; j < last; j++ {
+			if ser.missing == nil || !ser.missing[j] {
+				s := fmt.Sprintf("%d:  %q\n", j, data[j])
+				if _, err := io.WriteString(w, s); err != nil {
+					panic(err)
+				}
+			} else {
+				if _, err := io.WriteString(w, fmt.Sprintf("%d:\n", j)); err != nil {
+					panic(err)
+				}
+			}
+		}
 	default:
 		panic("Unknown type in WriteRange")
 	}
@@ -243,6 +335,19 @@ func (ser *Series) Missing() []bool {
 	return ser.missing
 }
 
+// IsMissing returns true if value i of the Series is missing.
+func (ser *Series) IsMissing(i int) bool {
+	return ser.missing != nil && ser.missing[i]
+}
+
+// MissingCodes returns the kind of each missing value, or nil if this
+// is not known.  The codes are only meaningful for values that are
+// missing according to Missing.  The returned slice should not be
+// modified.
+func (ser *Series) MissingCodes() []MissingCode {
+	return ser.missingCodes
+}
+
 // Length returns the number of elements in a Series.
 func (ser *Series) Length() int {
 	return ser.length
@@ -394,6 +499,36 @@ func (ser *Series) AllClose(other *Series, tol float64) (bool, int) {
 				return false, j
 			}
 		}
+	case []bool:
+		u := ser.data.([]bool)
+		v, ok := other.data.([]bool)
+		if !ok {
+			return false, -2
+		}
+		for j := 0; j < ser.length; j++ {
+			c := cmiss(j)
+			if c == 0 {
+				return false, j
+			}
+			if (c == 1) && (u[j] != v[j]) {
+				return false, j
+			}
+		}
+	case [][]byte:
+		u := ser.data.([][]byte)
+		v, ok := other.data.([][]byte)
+		if !ok {
+			return false, -2
+		}
+		for j := 0; j < ser.length; j++ {
+			c := cmiss(j)
+			if c == 0 {
+				return false, j
+			}
+			if (c == 1) && !bytes.Equal(u[j], v[j]) {
+				return false, j
+			}
+		}
 	}
 	return true, 0
 }
@@ -404,7 +539,8 @@ func (ser *Series) AllEqual(other *Series) (bool, int) {
 }
 
 // UpcastNumeric converts in-place all numeric type variables to
-// float64 values.  Non-numeric data is not affected.
+// float64 values.  Boolean values are converted to 0/1.
+// Non-numeric data is not affected.
 func (ser *Series) UpcastNumeric() *Series {
 
 	n := ser.Length()
@@ -422,6 +558,8 @@ func (ser *Series) UpcastNumeric() *Series {
 		return ser
 	case []string:
 		return ser
+	case [][]byte:
+		return ser
 	case []time.Time:
 		return ser
 	case []float32:
@@ -431,7 +569,7 @@ func (ser *Series) UpcastNumeric() *Series {
 		for i := 0; i < n; i++ {
 			a[i] = float64(d[i])
 		}
-		s, _ := NewSeries(ser.Name, a, cmiss)
+		s := ser.derive(a, cmiss)
 		return s
 	case []int64:
 		d := ser.data.([]int64)
@@ -440,7 +578,7 @@ func (ser *Series) UpcastNumeric() *Series {
 		for i := 0; i < n; i++ {
 			a[i] = float64(d[i])
 		}
-		s, _ := NewSeries(ser.Name, a, cmiss)
+		s := ser.derive(a, cmiss)
 		return s
 	case []int32:
 		d := ser.data.([]int32)
@@ -450,7 +588,7 @@ func (ser *Series) UpcastNumeric() *Series {
 			a[i] = float64(d[i])
 		}
 		ser.data = a
-		s, _ := NewSeries(ser.Name, a, cmiss)
+		s := ser.derive(a, cmiss)
 		return s
 	case []int16:
 		d := ser.data.([]int16)
@@ -460,7 +598,7 @@ func (ser *Series) UpcastNumeric() *Series {
 			a[i] = float64(d[i])
 		}
 		ser.data = a
-		s, _ := NewSeries(ser.Name, a, cmiss)
+		s := ser.derive(a, cmiss)
 		return s
 	case []int8:
 		d := ser.data.([]int8)
@@ -470,7 +608,18 @@ func (ser *Series) UpcastNumeric() *Series {
 			a[i] = float64(d[i])
 		}
 		ser.data = a
-		s, _ := NewSeries(ser.Name, a, cmiss)
+		s := ser.derive(a, cmiss)
+		return s
+	case []bool:
+		d := ser.data.([]bool)
+		n := len(d)
+		a := make([]float64, n)
+		for i := 0; i < n; i++ {
+			if d[i] {
+				a[i] = 1
+			}
+		}
+		s := ser.derive(a, cmiss)
 		return s
 	}
 }
@@ -502,7 +651,7 @@ func (ser *Series) ForceNumeric() *Series {
 				}
 			}
 		}
-		s, _ := NewSeries(ser.Name, x, cmiss)
+		s := ser.derive(x, cmiss)
 		return s
 	}
 }
@@ -520,6 +669,36 @@ func (ser *Series) CountMissing() int {
 	return m
 }
 
+// NUnique returns the number of distinct non-missing values in the
+// Series.
+func (ser *Series) NUnique() int {
+
+	seen := make(map[interface{}]bool)
+	for i := 0; i < ser.length; i++ {
+		if ser.missing != nil && ser.missing[i] {
+			continue
+		}
+		v := ser.value(i)
+		switch x := v.(type) {
+		case time.Time:
+			// Times in different locations may be equal.
+			v = x.UnixNano()
+		case []byte:
+			// Slices cannot be map keys
+			v = string(x)
+		}
+		seen[v] = true
+	}
+
+	return len(seen)
+}
+
+// IsConstant returns true if all non-missing values in the Series
+// are equal, or if there are no non-missing values.
+func (ser *Series) IsConstant() bool {
+	return ser.NUnique() <= 1
+}
+
 // StringFunc applies the given function to all values in the series,
 // if the series holds string values.  Otherwise calling this method has
 // no effect.
@@ -540,7 +719,7 @@ func (ser *Series) StringFunc(f func(string) string) *Series {
 		for i, v := range x {
 			y[i] = f(v)
 		}
-		s, _ := NewSeries(ser.Name, y, cmiss)
+		s := ser.derive(y, cmiss)
 		return s
 	}
 }
@@ -566,7 +745,7 @@ func (ser *Series) ToString() *Series {
 				x[i] = y[i].UTC().Format("2006-01-02 15:04:05")
 			}
 		}
-		s, _ := NewSeries(ser.Name, x, cmiss)
+		s := ser.derive(x, cmiss)
 		return s
 	case []string:
 		return ser
@@ -578,7 +757,7 @@ func (ser *Series) ToString() *Series {
 				x[i] = fmt.Sprintf("%v", y[i])
 			}
 		}
-		s, _ := NewSeries(ser.Name, x, cmiss)
+		s := ser.derive(x, cmiss)
 		return s
 	}
 }
@@ -607,7 +786,7 @@ func (ser *Series) NullStringMissing() *Series {
 				cmiss[i] = true
 			}
 		}
-		s, _ := NewSeries(ser.Name, x, cmiss)
+		s := ser.derive(x, cmiss)
 		return s
 	}
 }
@@ -647,6 +826,372 @@ func (ser SeriesArray) AllEqual(other []*Series) (bool, int, int) {
 	return ser.AllClose(other, 0.0)
 }
 
+// selectRows returns a new Series containing the values at the given
+// positions of the Series, in the given order.
+func (ser *Series) selectRows(ix []int) *Series {
+
+	var miss []bool
+	if ser.missing != nil {
+		miss = make([]bool, len(ix))
+		for i, k := range ix {
+			miss[i] = ser.missing[k]
+		}
+	}
+
+	var data interface{}
+	switch x := ser.data.(type) {
+	default:
+		panic(fmt.Sprintf("unknown data type %T in selectRows", ser.data))
+	case []float64:
+		y := make([]float64, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []float32:
+		y := make([]float32, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []int64:
+		y := make([]int64, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []int32:
+		y := make([]int32, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []int16:
+		y := make([]int16, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []int8:
+		y := make([]int8, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []uint64:
+		y := make([]uint64, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []string:
+		y := make([]string, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []time.Time:
+		y := make([]time.Time, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case []bool:
+		y := make([]bool, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	case [][]byte:
+		y := make([][]byte, len(ix))
+		for i, k := range ix {
+			y[i] = x[k]
+		}
+		data = y
+	}
+
+	s := ser.derive(data, miss)
+	s.missingCodes = nil
+	if ser.missingCodes != nil {
+		s.missingCodes = make([]MissingCode, len(ix))
+		for i, k := range ix {
+			s.missingCodes[i] = ser.missingCodes[k]
+		}
+	}
+
+	return s
+}
+
+// Split randomly partitions the rows of the SeriesArray into two
+// SeriesArray values.  The first contains the given fraction of the
+// rows (rounded to the nearest integer), and the second contains the
+// remaining rows.  The rows of each part remain in their original
+// order.  The partition is determined by the given seed.
+func (ser SeriesArray) Split(fraction float64, seed int64) (SeriesArray, SeriesArray, error) {
+
+	if fraction < 0 || fraction > 1 {
+		return nil, nil, fmt.Errorf("fraction %v is not between 0 and 1", fraction)
+	}
+	if len(ser) == 0 {
+		return SeriesArray{}, SeriesArray{}, nil
+	}
+
+	n := ser[0].Length()
+	for j, s := range ser {
+		if s.Length() != n {
+			return nil, nil, fmt.Errorf("column %d has length %d, expected %d", j, s.Length(), n)
+		}
+	}
+
+	perm := rand.New(rand.NewSource(seed)).Perm(n)
+	m := int(math.Floor(fraction*float64(n) + 0.5))
+	ix1 := perm[0:m]
+	ix2 := perm[m:]
+	sort.Ints(ix1)
+	sort.Ints(ix2)
+
+	s1 := make(SeriesArray, len(ser))
+	s2 := make(SeriesArray, len(ser))
+	for j, s := range ser {
+		s1[j] = s.selectRows(ix1)
+		s2[j] = s.selectRows(ix2)
+	}
+
+	return s1, s2, nil
+}
+
+// value returns the value at position i of the Series.
+func (ser *Series) value(i int) interface{} {
+
+	switch x := ser.data.(type) {
+	default:
+		panic(fmt.Sprintf("unknown data type %T in value", ser.data))
+	case []float64:
+		return x[i]
+	case []float32:
+		return x[i]
+	case []int64:
+		return x[i]
+	case []int32:
+		return x[i]
+	case []int16:
+		return x[i]
+	case []int8:
+		return x[i]
+	case []uint64:
+		return x[i]
+	case []string:
+		return x[i]
+	case []time.Time:
+		return x[i]
+	case []bool:
+		return x[i]
+	case [][]byte:
+		return x[i]
+	}
+}
+
+// A Row provides access to the values in one row of a SeriesArray.
+type Row struct {
+	sa    SeriesArray
+	names map[string]int
+	i     int
+}
+
+// Index returns the position of the row in the SeriesArray.
+func (row Row) Index() int {
+	return row.i
+}
+
+// Value returns the value of the named variable in the row.  The
+// second return value is false if the value is missing or there is
+// no variable with the given name.
+func (row Row) Value(name string) (interface{}, bool) {
+
+	j, ok := row.names[name]
+	if !ok {
+		return nil, false
+	}
+	s := row.sa[j]
+	if s.missing != nil && s.missing[row.i] {
+		return nil, false
+	}
+
+	return s.value(row.i), true
+}
+
+// Float64 returns the value of the named numeric variable in the row,
+// converted to float64.  The second return value is false if the
+// value is missing, is not numeric, or there is no variable with the
+// given name.
+func (row Row) Float64(name string) (float64, bool) {
+
+	v, ok := row.Value(name)
+	if !ok {
+		return 0, false
+	}
+
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int16:
+		return float64(x), true
+	case int8:
+		return float64(x), true
+	default:
+		return 0, false
+	}
+}
+
+// AddColumn returns a new SeriesArray containing the Series of this
+// SeriesArray, followed by a new Series with the given name.  The
+// values of the new Series are obtained by calling fn for each row.
+// The function returns the value for the row, and false if the value
+// is missing.  The type of the new Series is the type of the
+// non-missing values, which must all have the same type.  The
+// supported types are float64, float32, int64, int32, int16, int8,
+// uint64, string, time.Time, and bool, values of type int are stored
+// as int64.  The original SeriesArray is not modified.
+func (ser SeriesArray) AddColumn(name string, fn func(row Row) (interface{}, bool)) (SeriesArray, error) {
+
+	names := make(map[string]int)
+	n := 0
+	for j, s := range ser {
+		if j == 0 {
+			n = s.Length()
+		} else if s.Length() != n {
+			return nil, fmt.Errorf("column %d has length %d, expected %d", j, s.Length(), n)
+		}
+		names[s.Name] = j
+	}
+	if _, ok := names[name]; ok {
+		return nil, fmt.Errorf("there is already a column named %s", name)
+	}
+
+	vals := make([]interface{}, n)
+	miss := make([]bool, n)
+	var first interface{}
+	for i := 0; i < n; i++ {
+		v, ok := fn(Row{sa: ser, names: names, i: i})
+		if !ok {
+			miss[i] = true
+			continue
+		}
+		if x, ok := v.(int); ok {
+			v = int64(x)
+		}
+		if first == nil {
+			first = v
+		} else if fmt.Sprintf("%T", v) != fmt.Sprintf("%T", first) {
+			return nil, fmt.Errorf("row %d has type %T, expected %T", i, v, first)
+		}
+		vals[i] = v
+	}
+
+	var data interface{}
+	switch first.(type) {
+	default:
+		return nil, fmt.Errorf("unsupported type %T in AddColumn", first)
+	case nil, float64:
+		x := make([]float64, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(float64)
+			}
+		}
+		data = x
+	case float32:
+		x := make([]float32, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(float32)
+			}
+		}
+		data = x
+	case int64:
+		x := make([]int64, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(int64)
+			}
+		}
+		data = x
+	case int32:
+		x := make([]int32, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(int32)
+			}
+		}
+		data = x
+	case int16:
+		x := make([]int16, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(int16)
+			}
+		}
+		data = x
+	case int8:
+		x := make([]int8, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(int8)
+			}
+		}
+		data = x
+	case uint64:
+		x := make([]uint64, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(uint64)
+			}
+		}
+		data = x
+	case string:
+		x := make([]string, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(string)
+			}
+		}
+		data = x
+	case time.Time:
+		x := make([]time.Time, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(time.Time)
+			}
+		}
+		data = x
+	case bool:
+		x := make([]bool, n)
+		for i, v := range vals {
+			if !miss[i] {
+				x[i] = v.(bool)
+			}
+		}
+		data = x
+	}
+
+	s, err := NewSeries(name, data, miss)
+	if err != nil {
+		return nil, err
+	}
+
+	rslt := make(SeriesArray, len(ser), len(ser)+1)
+	copy(rslt, ser)
+	rslt = append(rslt, s)
+
+	return rslt, nil
+}
+
 // DateFromDuration returns a new Series in which the data are dates, derived
 // from a given duration value.  Currently, units must be "days".
 func (ser *Series) DateFromDuration(base time.Time, units string) (*Series, error) {
@@ -676,12 +1221,7 @@ func (ser *Series) DateFromDuration(base time.Time, units string) (*Series, erro
 		}
 	}
 
-	rslt, err := NewSeries(ser.Name, newdate, miss)
-	if err != nil {
-		return nil, err
-	}
-
-	return rslt, nil
+	return ser.derive(newdate, miss), nil
 }
 
 // AsFloat64Slice returns the data of the series as a float64 slice,
@@ -722,3 +1262,46 @@ func (ser *Series) AsStringSlice() ([]string, []bool, error) {
 
 	return v, ser.missing, nil
 }
+
+// AsInt64Slice returns the data of the series as an int64 slice, and
+// a boolean slice for the missing value indicators.  Data of any
+// integer type are converted to a new int64 slice.
+func (ser *Series) AsInt64Slice() ([]int64, []bool, error) {
+
+	switch x := ser.data.(type) {
+	case []int64:
+		return x, ser.missing, nil
+	case []int32:
+		v := make([]int64, len(x))
+		for i := range x {
+			v[i] = int64(x[i])
+		}
+		return v, ser.missing, nil
+	case []int16:
+		v := make([]int64, len(x))
+		for i := range x {
+			v[i] = int64(x[i])
+		}
+		return v, ser.missing, nil
+	case []int8:
+		v := make([]int64, len(x))
+		for i := range x {
+			v[i] = int64(x[i])
+		}
+		return v, ser.missing, nil
+	default:
+		return nil, nil, fmt.Errorf("can't convert %T to []int64", ser.data)
+	}
+}
+
+// AsTimeSlice returns the data of the series as a time.Time slice,
+// and a boolean slice for the missing value indicators.
+func (ser *Series) AsTimeSlice() ([]time.Time, []bool, error) {
+
+	v, ok := ser.data.([]time.Time)
+	if !ok {
+		return nil, nil, fmt.Errorf("can't convert %T to []time.Time", ser.data)
+	}
+
+	return v, ser.missing, nil
+}
@@ -0,0 +1,205 @@
+package datareader
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSplit(t *testing.T) {
+
+	x, _ := NewSeries("x", []float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, nil)
+	y, _ := NewSeries("y", []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+		[]bool{false, true, false, true, false, true, false, true, false, true})
+	sa := SeriesArray{x, y}
+
+	s1, s2, err := sa.Split(0.7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1[0].Length() != 7 || s2[0].Length() != 3 {
+		t.Fatalf("unexpected split sizes %d, %d", s1[0].Length(), s2[0].Length())
+	}
+
+	// The columns must stay aligned, and every row appears once.
+	seen := make(map[float64]bool)
+	for _, part := range []SeriesArray{s1, s2} {
+		u := part[0].Data().([]float64)
+		v := part[1].Data().([]string)
+		m := part[1].Missing()
+		for i := range u {
+			if v[i] != strconv.Itoa(int(u[i])) || m[i] != (int(u[i])%2 == 1) {
+				t.Fatalf("misaligned row %d", i)
+			}
+			seen[u[i]] = true
+		}
+	}
+	if len(seen) != 10 {
+		t.Fail()
+	}
+
+	// Deterministic given the seed
+	t1, _, _ := sa.Split(0.7, 1)
+	if f, _, _ := s1.AllEqual(t1); !f {
+		t.Fail()
+	}
+
+	if _, _, err := sa.Split(1.5, 1); err == nil {
+		t.Fail()
+	}
+}
+
+func TestMeta(t *testing.T) {
+
+	x, _ := NewSeries("x", []int16{1, 2, 3}, []bool{false, true, false})
+	x.Meta = map[string]string{"source": "test.dta"}
+
+	y := x.UpcastNumeric()
+	z := y.ToString()
+	for _, s := range []*Series{y, z} {
+		if s.Meta["source"] != "test.dta" {
+			t.Errorf("metadata not preserved")
+		}
+	}
+
+	// The metadata is copied, not shared.
+	z.Meta["source"] = "other"
+	if x.Meta["source"] != "test.dta" {
+		t.Fail()
+	}
+}
+
+func TestUpcastNumeric(t *testing.T) {
+
+	x, _ := NewSeries("x", []int8{1, 2, 3}, []bool{false, true, false})
+	y, ok := x.UpcastNumeric().Data().([]float64)
+	if !ok || y[0] != 1 || y[2] != 3 {
+		t.Errorf("unexpected values %v", y)
+	}
+
+	// Non-numeric data are returned unchanged
+	for _, d := range []interface{}{[]string{"a", "b"}, [][]byte{{1, 2}, {3}}} {
+		s, _ := NewSeries("s", d, nil)
+		if u := s.UpcastNumeric(); u != s {
+			t.Errorf("%T: got a different Series", d)
+		}
+	}
+}
+
+func TestNUniqueBytes(t *testing.T) {
+
+	x, _ := NewSeries("x", [][]byte{{1, 2}, {3}, {1, 2}, nil}, []bool{false, false, false, true})
+	if n := x.NUnique(); n != 2 {
+		t.Errorf("got %d unique values, expected 2", n)
+	}
+	if x.IsConstant() {
+		t.Errorf("unexpected constant Series")
+	}
+}
+
+func TestAddColumn(t *testing.T) {
+
+	w, _ := NewSeries("weight", []float64{70, 80, 90}, []bool{false, false, true})
+	h, _ := NewSeries("height", []float32{1.75, 2, 1.8}, nil)
+	sa := SeriesArray{w, h}
+
+	sb, err := sa.AddColumn("bmi", func(row Row) (interface{}, bool) {
+		wt, ok1 := row.Float64("weight")
+		ht, ok2 := row.Float64("height")
+		if !ok1 || !ok2 {
+			return nil, false
+		}
+		return wt / (ht * ht), true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sa) != 2 || len(sb) != 3 {
+		t.Fatalf("unexpected number of columns")
+	}
+
+	bmi, _ := NewSeries("bmi", []float64{70 / (1.75 * 1.75), 20, 0}, []bool{false, false, true})
+	if f, _ := sb[2].AllClose(bmi, 1e-6); !f {
+		t.Errorf("unexpected values")
+		sb[2].Print()
+	}
+
+	_, err = sa.AddColumn("mixed", func(row Row) (interface{}, bool) {
+		if row.Index() == 0 {
+			return "a", true
+		}
+		return 1, true
+	})
+	if err == nil {
+		t.Errorf("expected an error for mixed types")
+	}
+
+	if _, err = sa.AddColumn("weight", nil); err == nil {
+		t.Errorf("expected an error for a duplicate name")
+	}
+}
+
+func TestIsConstant(t *testing.T) {
+
+	x, _ := NewSeries("x", []int8{3, 1, 3, 2}, []bool{false, true, false, false})
+	if x.NUnique() != 2 || x.IsConstant() {
+		t.Errorf("x: NUnique=%d", x.NUnique())
+	}
+
+	y, _ := NewSeries("y", []string{"a", "b", "a"}, []bool{false, true, false})
+	if y.NUnique() != 1 || !y.IsConstant() {
+		t.Errorf("y: NUnique=%d", y.NUnique())
+	}
+
+	z, _ := NewSeries("z", []float64{1, 2}, []bool{true, true})
+	if z.NUnique() != 0 || !z.IsConstant() {
+		t.Errorf("z: NUnique=%d", z.NUnique())
+	}
+}
+
+func TestAsSlice(t *testing.T) {
+
+	miss := []bool{false, true, false}
+
+	x, _ := NewSeries("x", []int16{1, 2, 3}, miss)
+	v, m, err := x.AsInt64Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 3 || v[0] != 1 || v[2] != 3 || !m[1] {
+		t.Errorf("got %v, %v", v, m)
+	}
+	if _, _, err := x.AsFloat64Slice(); err == nil {
+		t.Errorf("expected an error converting []int16 to []float64")
+	}
+
+	tm := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	y, _ := NewSeries("y", []time.Time{tm, tm, {}}, miss)
+	u, m, err := y.AsTimeSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !u[0].Equal(tm) || !m[1] {
+		t.Errorf("got %v, %v", u, m)
+	}
+	if _, _, err := y.AsInt64Slice(); err == nil {
+		t.Errorf("expected an error converting []time.Time to []int64")
+	}
+	if _, _, err := x.AsTimeSlice(); err == nil {
+		t.Errorf("expected an error converting []int16 to []time.Time")
+	}
+}
+
+func TestIsMissing(t *testing.T) {
+
+	x, _ := NewSeries("x", []float64{1, 2, 3}, []bool{false, true, false})
+	y, _ := NewSeries("y", []float64{1, 2, 3}, nil)
+	for i := 0; i < 3; i++ {
+		if x.IsMissing(i) != (i == 1) {
+			t.Errorf("x: unexpected missing status at %d", i)
+		}
+		if y.IsMissing(i) {
+			t.Errorf("y: unexpected missing value at %d", i)
+		}
+	}
+}
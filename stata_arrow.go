@@ -0,0 +1,130 @@
+//go:build arrow
+// +build arrow
+
+package datareader
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// arrowType returns the Arrow data type for the values of a Series,
+// as returned by Read.
+func arrowType(data interface{}) (arrow.DataType, error) {
+
+	switch data.(type) {
+	case []float64:
+		return arrow.PrimitiveTypes.Float64, nil
+	case []float32:
+		return arrow.PrimitiveTypes.Float32, nil
+	case []int64:
+		return arrow.PrimitiveTypes.Int64, nil
+	case []int32:
+		return arrow.PrimitiveTypes.Int32, nil
+	case []int16:
+		return arrow.PrimitiveTypes.Int16, nil
+	case []int8:
+		return arrow.PrimitiveTypes.Int8, nil
+	case []uint64:
+		return arrow.PrimitiveTypes.Uint64, nil
+	case []bool:
+		return arrow.FixedWidthTypes.Boolean, nil
+	case []string:
+		return arrow.BinaryTypes.String, nil
+	case [][]byte:
+		return arrow.BinaryTypes.Binary, nil
+	case []time.Time:
+		return &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: "UTC"}, nil
+	default:
+		return nil, fmt.Errorf("cannot convert data of type %T to Arrow", data)
+	}
+}
+
+// WriteArrow reads the given number of rows (or the remaining rows, if
+// rows is negative) and returns them as an Arrow record batch.  The
+// data are read with Read, so the settings of the reader apply:
+// doubles, floats, longs, ints and bytes become float64, float32,
+// int32, int16 and int8 columns, strings and strls (and labeled
+// categoricals, when InsertCategoryLabels is set) become string
+// columns, binary strls become binary columns when BinaryStrls is
+// set, and dates become millisecond timestamps in UTC when
+// ConvertDates is set.  Missing values are nulls.  The variable
+// labels and display formats are stored in the metadata of each
+// field, under the keys "label" and "format".  If no rows remain,
+// io.EOF is returned, except that a file with no observations gives
+// one empty record.  The caller must call Release on the record.
+// WriteArrow is only available when building with the arrow tag.
+func (rdr *StataReader) WriteArrow(rows int) (arrow.Record, error) {
+
+	ds, err := rdr.Read(rows)
+	if err != nil {
+		return nil, err
+	}
+	if ds == nil {
+		return nil, io.EOF
+	}
+
+	fields := make([]arrow.Field, len(ds))
+	for j, s := range ds {
+		dt, err := arrowType(s.Data())
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %v", s.Name, err)
+		}
+		var label string
+		if j < len(rdr.ColumnNamesLong) {
+			label = rdr.ColumnNamesLong[j]
+		}
+		md := arrow.NewMetadata([]string{"label", "format"}, []string{label, rdr.Formats[j]})
+		fields[j] = arrow.Field{Name: s.Name, Type: dt, Nullable: true, Metadata: md}
+	}
+	schema := arrow.NewSchema(fields, nil)
+
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	for j, s := range ds {
+		var valid []bool
+		if miss := s.Missing(); miss != nil {
+			valid = make([]bool, len(miss))
+			for i, m := range miss {
+				valid[i] = !m
+			}
+		}
+
+		switch x := s.Data().(type) {
+		case []float64:
+			b.Field(j).(*array.Float64Builder).AppendValues(x, valid)
+		case []float32:
+			b.Field(j).(*array.Float32Builder).AppendValues(x, valid)
+		case []int64:
+			b.Field(j).(*array.Int64Builder).AppendValues(x, valid)
+		case []int32:
+			b.Field(j).(*array.Int32Builder).AppendValues(x, valid)
+		case []int16:
+			b.Field(j).(*array.Int16Builder).AppendValues(x, valid)
+		case []int8:
+			b.Field(j).(*array.Int8Builder).AppendValues(x, valid)
+		case []uint64:
+			b.Field(j).(*array.Uint64Builder).AppendValues(x, valid)
+		case []bool:
+			b.Field(j).(*array.BooleanBuilder).AppendValues(x, valid)
+		case []string:
+			b.Field(j).(*array.StringBuilder).AppendValues(x, valid)
+		case [][]byte:
+			b.Field(j).(*array.BinaryBuilder).AppendValues(x, valid)
+		case []time.Time:
+			ts := make([]arrow.Timestamp, len(x))
+			for i, t := range x {
+				ts[i] = arrow.Timestamp(t.Unix()*1000 + int64(t.Nanosecond()/1e6))
+			}
+			b.Field(j).(*array.TimestampBuilder).AppendValues(ts, valid)
+		}
+	}
+
+	return b.NewRecord(), nil
+}
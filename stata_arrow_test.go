@@ -0,0 +1,72 @@
+//go:build arrow
+// +build arrow
+
+package datareader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+func TestWriteArrow(t *testing.T) {
+
+	open := func() *StataReader {
+		r, err := os.Open(filepath.Join("test_files", "data", "stata2_117.dta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata
+	}
+
+	ds, err := open().Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stata := open()
+	rec, err := stata.WriteArrow(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	if int(rec.NumRows()) != stata.RowCount() || int(rec.NumCols()) != stata.Nvar {
+		t.Fatalf("record has %d rows and %d columns", rec.NumRows(), rec.NumCols())
+	}
+	for j, s := range ds {
+		col := rec.Column(j)
+		if rec.ColumnName(j) != s.Name {
+			t.Errorf("column %d has name %s, expected %s", j, rec.ColumnName(j), s.Name)
+		}
+		for i := 0; i < s.Length(); i++ {
+			if col.IsNull(i) != s.IsMissing(i) {
+				t.Errorf("column %d, row %d: null is %v", j, i, col.IsNull(i))
+			}
+		}
+		if x, ok := s.Data().([]time.Time); ok {
+			a, ok := col.(*array.Timestamp)
+			if !ok || a.DataType().(*arrow.TimestampType).Unit != arrow.Millisecond {
+				t.Fatalf("column %d has type %v", j, col.DataType())
+			}
+			for i := range x {
+				if !s.IsMissing(i) && int64(a.Value(i)) != x[i].UnixNano()/1e6 {
+					t.Errorf("column %d, row %d: got %d", j, i, a.Value(i))
+				}
+			}
+		}
+	}
+
+	if _, err := stata.WriteArrow(-1); err != io.EOF {
+		t.Errorf("expected io.EOF when no rows remain, got %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package datareader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ReadBuffer holds the data of a block of rows read by ReadInto.  The
+// slices are reused by subsequent calls to ReadInto, so that reading
+// many blocks, or many files with the same variables, does not
+// allocate new slices for each block.  A ReadBuffer takes the layout
+// of the first file that it is used with, and can only be used with
+// files whose variables have the same types, read with the same
+// settings of InsertStrls, BinaryStrls and ForceFloat64.
+type ReadBuffer struct {
+
+	// The data of each variable, with the types that Read uses
+	// before any conversions (e.g. []float64, []int16, []string).
+	// Only the first Rows values are valid.
+	Data []interface{}
+
+	// The missing value indicators of each variable
+	Missing [][]bool
+
+	// The kind of each missing value, see Series.MissingCodes
+	MissingCodes [][]MissingCode
+
+	// The number of rows held in the buffer
+	Rows int
+}
+
+// ReadInto reads the given number of rows (or the remaining rows, if
+// rows is negative) into dst, reusing the slices of dst when they are
+// large enough.  Unlike Read, the values are not converted: category
+// labels are not inserted, dates are not converted and booleans are
+// not detected.  ReadInto shares its position in the file with Read.
+// If no rows remain, io.EOF is returned.  An error is returned if the
+// layout of dst does not match the variables of the file.
+func (rdr *StataReader) ReadInto(dst *ReadBuffer, rows int) error {
+
+	nval := rdr.rowCount - rdr.rowsRead
+	if rows >= 0 && rows < nval {
+		nval = rows
+	}
+	if nval <= 0 {
+		dst.Rows = 0
+		return io.EOF
+	}
+
+	if err := rdr.checkRows(rdr.rowsRead + nval); err != nil {
+		return err
+	}
+
+	if err := rdr.prepareBuffer(dst, nval); err != nil {
+		return err
+	}
+
+	if rdr.FormatVersion >= 117 && rdr.rowsRead == 0 {
+		if _, err := rdr.reader.Seek(rdr.dataStart(), 0); err != nil {
+			return err
+		}
+	}
+
+	if err := rdr.readRows(context.Background(), nval, dst.Data, dst.Missing, dst.MissingCodes, true); err != nil {
+		return err
+	}
+	rdr.rowsRead += nval
+	dst.Rows = nval
+
+	return nil
+}
+
+// prepareBuffer checks that the layout of dst matches the variables of
+// the file, and sizes its slices to hold nval values.
+func (rdr *StataReader) prepareBuffer(dst *ReadBuffer, nval int) error {
+
+	layout := rdr.allocateCols(0, nil)
+
+	if dst.Data == nil {
+		dst.Data = layout
+		dst.Missing = make([][]bool, rdr.Nvar)
+		dst.MissingCodes = make([][]MissingCode, rdr.Nvar)
+	}
+	if len(dst.Data) != rdr.Nvar {
+		return fmt.Errorf("buffer has %d variables, expected %d", len(dst.Data), rdr.Nvar)
+	}
+
+	for j := range layout {
+		if reflect.TypeOf(dst.Data[j]) != reflect.TypeOf(layout[j]) {
+			return fmt.Errorf("variable %s has type %T in the buffer, expected %T", rdr.columnNames[j], dst.Data[j], layout[j])
+		}
+		dst.Data[j] = resizeSlice(dst.Data[j], nval)
+
+		if cap(dst.Missing[j]) < nval {
+			dst.Missing[j] = make([]bool, nval)
+			dst.MissingCodes[j] = make([]MissingCode, nval)
+			continue
+		}
+		dst.Missing[j] = dst.Missing[j][0:nval]
+		dst.MissingCodes[j] = dst.MissingCodes[j][0:nval]
+		for i := range dst.Missing[j] {
+			dst.Missing[j][i] = false
+			dst.MissingCodes[j][i] = 0
+		}
+	}
+
+	return nil
+}
+
+// resizeSlice returns a slice of the same type as x with length n,
+// reusing the array of x if it is large enough.
+func resizeSlice(x interface{}, n int) interface{} {
+
+	switch x := x.(type) {
+	default:
+		panic(fmt.Sprintf("unknown data type %T in resizeSlice", x))
+	case []float64:
+		if cap(x) < n {
+			return make([]float64, n)
+		}
+		return x[0:n]
+	case []float32:
+		if cap(x) < n {
+			return make([]float32, n)
+		}
+		return x[0:n]
+	case []int32:
+		if cap(x) < n {
+			return make([]int32, n)
+		}
+		return x[0:n]
+	case []int16:
+		if cap(x) < n {
+			return make([]int16, n)
+		}
+		return x[0:n]
+	case []int8:
+		if cap(x) < n {
+			return make([]int8, n)
+		}
+		return x[0:n]
+	case []uint64:
+		if cap(x) < n {
+			return make([]uint64, n)
+		}
+		return x[0:n]
+	case []string:
+		if cap(x) < n {
+			return make([]string, n)
+		}
+		return x[0:n]
+	case [][]byte:
+		if cap(x) < n {
+			return make([][]byte, n)
+		}
+		return x[0:n]
+	}
+}
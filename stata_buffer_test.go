@@ -0,0 +1,84 @@
+package datareader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadInto(t *testing.T) {
+
+	open := func(fname string) *StataReader {
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata.InsertCategoryLabels = false
+		stata.ConvertDates = false
+		return stata
+	}
+
+	var buf ReadBuffer
+	for _, fname := range []string{"stata8_117.dta", "stata8_115.dta"} {
+		stata := open(fname)
+		full, err := open(fname).Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var pos int
+		for {
+			err := stata.ReadInto(&buf, 4)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			ix := make([]int, buf.Rows)
+			for i := range ix {
+				ix[i] = pos + i
+			}
+			for j := range full {
+				s, err := NewSeries(full[j].Name, buf.Data[j], buf.Missing[j])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if eq, i := full[j].selectRows(ix).AllEqual(s); !eq {
+					t.Errorf("%s: column %d differs at row %d", fname, j, pos+i)
+				}
+				for i, k := range ix {
+					if buf.Missing[j][i] && buf.MissingCodes[j][i] != full[j].MissingCodes()[k] {
+						t.Errorf("%s: missing code of column %d differs at row %d", fname, j, k)
+					}
+				}
+			}
+			pos += buf.Rows
+		}
+		if pos != stata.RowCount() {
+			t.Errorf("%s: read %d rows, expected %d", fname, pos, stata.RowCount())
+		}
+	}
+
+	// The buffer is reused
+	stata := open("stata8_117.dta")
+	if err := stata.ReadInto(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	x := buf.Data[0].([]int8)
+	if err := stata.ReadInto(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	if y := buf.Data[0].([]int8); &x[0] != &y[0] {
+		t.Errorf("the buffer was not reused")
+	}
+
+	// A file with different variables
+	if err := open("stata12_117.dta").ReadInto(&buf, 2); err == nil {
+		t.Errorf("expected an error for a buffer with a different layout")
+	}
+}
@@ -0,0 +1,405 @@
+package datareader
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// ConvertToV118 reads the Stata file in src, which may be of any
+// supported version, and writes it to dst as a version 118 file.  The
+// text of files of version 117 and earlier is converted from the
+// character set named by srcEncoding (e.g. "windows-1252") to UTF-8,
+// or is copied unchanged if srcEncoding is empty.  The variable names,
+// variable labels, formats, value labels, missing value codes and
+// data are preserved.  String variables are widened if their values
+// no longer fit after conversion to UTF-8.  Binary strls are written
+// as text strls, and characteristics and notes are not copied.  The
+// data are held in memory while they are converted.
+func ConvertToV118(src io.ReadSeeker, dst io.WriteSeeker, srcEncoding string) error {
+
+	rdr, err := NewStataReader(src)
+	if err != nil {
+		return err
+	}
+	rdr.InsertCategoryLabels = false
+	rdr.ConvertDates = false
+
+	if srcEncoding != "" {
+		enc, err := htmlindex.Get(srcEncoding)
+		if err != nil {
+			return fmt.Errorf("unknown encoding %s: %v", srcEncoding, err)
+		}
+		if err := rdr.SetStringEncoding(enc); err != nil {
+			return err
+		}
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		return err
+	}
+	if ds == nil {
+		return fmt.Errorf("no data to convert")
+	}
+
+	vartypes := rdr.ColumnTypes()
+	coltypes := make([]ColumnTypeT, len(ds))
+	for j, s := range ds {
+		t := vartypes[j]
+		coltypes[j] = t
+		if t > 2045 {
+			continue
+		}
+		for _, v := range s.Data().([]string) {
+			if n := ColumnTypeT(len(v)); n > coltypes[j] {
+				coltypes[j] = n
+			}
+		}
+		if coltypes[j] > 2045 {
+			coltypes[j] = StataStrlType
+		}
+	}
+
+	wtr, err := NewStataWriter(dst, ds)
+	if err != nil {
+		return err
+	}
+	wtr.DatasetLabel = rdr.DatasetLabel
+	if ts, err := rdr.TimeStampTime(); err == nil {
+		wtr.TimeStamp = ts
+	}
+	wtr.Formats = rdr.Formats
+	wtr.ColumnTypes = coltypes
+	wtr.VariableLabels = rdr.ColumnNamesLong
+	wtr.ValueLabels = rdr.ValueLabels
+	wtr.ValueLabelNames = rdr.ValueLabelNames
+
+	return wtr.Write()
+}
+
+// stataNames returns valid Stata variable names for the given
+// names.  Characters other than letters, digits and underscores are
+// replaced with underscores, an underscore is prepended to a name that
+// starts with a digit, and names are cut to 32 characters.  Empty
+// names are replaced with "v" followed by their position (starting at
+// 1), and repeated names are made unique by appending "_2", "_3", ...,
+// shortening the name if needed.
+func stataNames(names []string) []string {
+
+	const maxLen = 32
+
+	truncate := func(r []rune, n int) string {
+		if len(r) > n {
+			r = r[0:n]
+		}
+		return string(r)
+	}
+
+	valid := make([][]rune, len(names))
+	used := make(map[string]bool, len(names))
+	for j, na := range names {
+		r := []rune(na)
+		for k, c := range r {
+			if c != '_' && !unicode.IsLetter(c) && !unicode.IsDigit(c) {
+				r[k] = '_'
+			}
+		}
+		if len(r) == 0 {
+			r = []rune(fmt.Sprintf("v%d", j+1))
+		} else if unicode.IsDigit(r[0]) {
+			r = append([]rune{'_'}, r...)
+		}
+		valid[j] = r
+		used[truncate(r, maxLen)] = true
+	}
+
+	seen := make(map[string]bool, len(names))
+	rnames := make([]string, len(names))
+	for j, r := range valid {
+		na := truncate(r, maxLen)
+		if seen[na] {
+			for k := 2; ; k++ {
+				suffix := fmt.Sprintf("_%d", k)
+				if c := truncate(r, maxLen-len(suffix)) + suffix; !used[c] {
+					na = c
+					break
+				}
+			}
+		}
+		seen[na] = true
+		used[na] = true
+		rnames[j] = na
+	}
+
+	return rnames
+}
+
+// CSVOptions controls the conversion of CSV data by ConvertCSVToDta.
+type CSVOptions struct {
+
+	// Fields with this text are missing values.  Empty fields are
+	// always missing.
+	NullToken string
+
+	// The columns that hold dates, keyed by column name, with the
+	// layout of their values as used by time.Parse.  A date column
+	// is written with format %td if all of its values are at
+	// midnight, and with format %tc otherwise.
+	DateColumns map[string]string
+
+	// The format version of the dta file.  Only version 118 can be
+	// written, which is also used if FormatVersion is zero.
+	FormatVersion int
+}
+
+// csvColumn holds what has been learned about the values of a CSV
+// column during type inference.
+type csvColumn struct {
+
+	// The layout of the values of a date column, empty for other
+	// columns
+	layout string
+
+	// True if all the non-missing values are numbers, or integers
+	numeric bool
+	integer bool
+
+	// True if all the dates are at midnight
+	midnight bool
+
+	// True if there is a non-missing value
+	observed bool
+
+	// The range of the numeric values
+	min, max float64
+
+	// The length of the longest value
+	width int
+}
+
+// observe updates the column with the field v.
+func (c *csvColumn) observe(v, null string) error {
+
+	if v == "" || v == null {
+		return nil
+	}
+
+	if c.layout != "" {
+		t, err := time.Parse(c.layout, v)
+		if err != nil {
+			return err
+		}
+		if t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0 || t.Nanosecond() != 0 {
+			c.midnight = false
+		}
+		c.observed = true
+		return nil
+	}
+
+	if len(v) > c.width {
+		c.width = len(v)
+	}
+	if !c.numeric {
+		return nil
+	}
+
+	x, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsInf(x, 0) || math.IsNaN(x) {
+		c.numeric = false
+		return nil
+	}
+	if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+		c.integer = false
+	}
+	if !c.observed || x < c.min {
+		c.min = x
+	}
+	if !c.observed || x > c.max {
+		c.max = x
+	}
+	c.observed = true
+
+	return nil
+}
+
+// stataType returns the type of the variable that holds the column.
+// Integer columns use the smallest type that holds their range.
+func (c *csvColumn) stataType() ColumnTypeT {
+
+	switch {
+	case c.layout != "":
+		return StataFloat64Type
+	case !c.numeric && c.width > 2045:
+		return StataStrlType
+	case !c.numeric && c.width == 0:
+		return 1
+	case !c.numeric:
+		return ColumnTypeT(c.width)
+	case !c.observed:
+		return StataInt8Type
+	case c.integer:
+		for _, t := range []ColumnTypeT{StataInt8Type, StataInt16Type, StataInt32Type} {
+			if numericFits(t, []float64{c.min, c.max}, []bool{false, false}) {
+				return t
+			}
+		}
+	}
+
+	return StataFloat64Type
+}
+
+// value returns the value of the field v, for StreamingStataWriter.
+func (c *csvColumn) value(v, null string) (interface{}, error) {
+
+	if !c.numeric && c.layout == "" {
+		if v == null {
+			return "", nil
+		}
+		return v, nil
+	}
+
+	if v == "" || v == null {
+		return nil, nil
+	}
+	if c.layout != "" {
+		return time.Parse(c.layout, v)
+	}
+
+	return strconv.ParseFloat(v, 64)
+}
+
+// ConvertCSVToDta reads CSV data from r and writes it to w as a Stata
+// dta file.  The first record holds the column names, which are made
+// into valid Stata names by stataNames; a column whose name is changed
+// keeps its header as its variable label.  The variable types
+// are inferred from the data: columns of integers are stored in the
+// smallest integer type that holds their range, other numeric columns
+// as doubles, and the remaining columns as strings wide enough for
+// their longest value (or as strls, if that exceeds 2045 bytes).
+// Records with fewer fields than the header are padded with missing
+// values.  The records are held in a temporary file between the
+// inference of the types and the writing of the data, so that the
+// data need not fit in memory.
+func ConvertCSVToDta(r io.Reader, w io.WriteSeeker, opts CSVOptions) error {
+
+	if opts.FormatVersion != 0 && opts.FormatVersion != 118 {
+		return fmt.Errorf("cannot write format version %d, only version 118 is supported", opts.FormatVersion)
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return fmt.Errorf("no header in CSV data")
+	} else if err != nil {
+		return err
+	}
+
+	cols := make([]csvColumn, len(header))
+	for j := range cols {
+		cols[j] = csvColumn{layout: opts.DateColumns[header[j]], numeric: true, integer: true, midnight: true}
+	}
+	for na := range opts.DateColumns {
+		var ok bool
+		for _, h := range header {
+			ok = ok || h == na
+		}
+		if !ok {
+			return fmt.Errorf("date column %s is not in the CSV header", na)
+		}
+	}
+
+	spool, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	// First pass, infer the types and copy the records to the
+	// temporary file
+	bw := bufio.NewWriter(spool)
+	enc := gob.NewEncoder(bw)
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(rec) > len(header) {
+			return fmt.Errorf("record %d has %d fields, the header has %d", line, len(rec), len(header))
+		}
+		for j, v := range rec {
+			if err := cols[j].observe(v, opts.NullToken); err != nil {
+				return fmt.Errorf("column %s, record %d: %v", header[j], line, err)
+			}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	names := stataNames(header)
+	schema := make([]ColumnInfo, len(cols))
+	for j := range cols {
+		c := &cols[j]
+		schema[j] = ColumnInfo{Name: names[j], StataType: c.stataType()}
+		if names[j] != header[j] {
+			schema[j].LongName = header[j]
+		}
+		if c.layout != "" && c.midnight {
+			schema[j].Format = "%td"
+		} else if c.layout != "" {
+			schema[j].Format = "%tc"
+		}
+	}
+
+	sw, err := NewStreamingStataWriter(w, schema)
+	if err != nil {
+		return err
+	}
+
+	// Second pass, write the records
+	if _, err := spool.Seek(0, 0); err != nil {
+		return err
+	}
+	dec := gob.NewDecoder(bufio.NewReader(spool))
+	row := make([]interface{}, len(cols))
+	for {
+		var rec []string
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		for j := range cols {
+			var v string
+			if j < len(rec) {
+				v = rec[j]
+			}
+			if row[j], err = cols[j].value(v, opts.NullToken); err != nil {
+				return err
+			}
+		}
+		if err := sw.AppendRow(row); err != nil {
+			return err
+		}
+	}
+
+	return sw.Finish()
+}
@@ -0,0 +1,300 @@
+package datareader
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// convertAndRead converts the dta file in b to version 118 and returns
+// readers for the original and converted files.
+func convertAndRead(t *testing.T, b []byte, enc string) (*StataReader, *StataReader, func()) {
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if err := ConvertToV118(bytes.NewReader(b), f, enc); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	src, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	dst, err := NewStataReader(f)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return src, dst, cleanup
+}
+
+func TestConvertToV118(t *testing.T) {
+
+	for _, fname := range []string{"stata2_115.dta", "stata4_115.dta", "stata8_115.dta", "test1_115.dta", "stata12_117.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, dst, cleanup := convertAndRead(t, b, "")
+		defer cleanup()
+
+		if dst.FormatVersion != 118 {
+			t.Errorf("%s: converted to version %d", fname, dst.FormatVersion)
+		}
+		if dst.DatasetLabel != src.DatasetLabel {
+			t.Errorf("%s: dataset label %q, expected %q", fname, dst.DatasetLabel, src.DatasetLabel)
+		}
+		for j := 0; j < src.Nvar; j++ {
+			if dst.ColumnNames()[j] != src.ColumnNames()[j] || dst.ColumnNamesLong[j] != src.ColumnNamesLong[j] ||
+				dst.Formats[j] != src.Formats[j] || dst.ValueLabelNames[j] != src.ValueLabelNames[j] {
+				t.Errorf("%s: metadata of column %d differs", fname, j)
+			}
+		}
+		for na, mp := range src.ValueLabels {
+			for k, v := range mp {
+				if dst.ValueLabels[na][k] != v {
+					t.Errorf("%s: label %d of %s is %q, expected %q", fname, k, na, dst.ValueLabels[na][k], v)
+				}
+			}
+		}
+
+		x, err := src.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := dst.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, j, i := SeriesArray(x).AllEqual(y); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+		for j := range x {
+			cx, cy := x[j].MissingCodes(), y[j].MissingCodes()
+			for i := range cx {
+				if x[j].IsMissing(i) && cx[i] != cy[i] {
+					t.Errorf("%s: missing code of column %d differs at row %d", fname, j, i)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertToV118Encoding(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata4_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b, []byte("<value_labels>"))
+	i += bytes.Index(b[i:], []byte("three"))
+	b[i+3] = 0xe9
+
+	_, dst, cleanup := convertAndRead(t, b, "windows-1252")
+	defer cleanup()
+
+	ds, err := dst.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]string); x[2] != "thrée" {
+		t.Errorf("unexpected label %q", x[2])
+	}
+
+	if err := ConvertToV118(bytes.NewReader(b), nil, "no-such-encoding"); err == nil {
+		t.Errorf("expected an error for an unknown encoding")
+	}
+}
+
+// convertCSV converts the CSV data to a dta file and returns a reader
+// for the converted file.
+func convertCSV(t *testing.T, data string, opts CSVOptions) (*StataReader, func()) {
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	if err := ConvertCSVToDta(strings.NewReader(data), f, opts); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	rdr, err := NewStataReader(f)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return rdr, cleanup
+}
+
+func TestConvertCSVToDta(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "test1.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := csv.NewReader(bytes.NewReader(b)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rdr, cleanup := convertCSV(t, string(b), CSVOptions{})
+	defer cleanup()
+
+	if rdr.RowCount() != len(recs)-1 || rdr.Nvar != len(recs[0]) {
+		t.Fatalf("got %d rows and %d columns", rdr.RowCount(), rdr.Nvar)
+	}
+	types := rdr.ColumnTypes()
+	for j, e := range []ColumnTypeT{StataFloat64Type, 9, StataInt8Type, StataInt16Type} {
+		if types[j] != e {
+			t.Errorf("column %d has type %d, expected %d", j, types[j], e)
+		}
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, s := range ds {
+		if s.Name != recs[0][j] {
+			t.Errorf("column %d has name %s", j, s.Name)
+		}
+		for i, rec := range recs[1:] {
+			v := rec[j]
+			if x, ok := s.Data().([]string); ok {
+				if x[i] != v {
+					t.Errorf("column %d, row %d: got %q, expected %q", j, i, x[i], v)
+				}
+				continue
+			}
+			if v == "" {
+				if !s.IsMissing(i) {
+					t.Errorf("column %d, row %d: expected a missing value", j, i)
+				}
+				continue
+			}
+			y, _ := strconv.ParseFloat(v, 64)
+			if x, _ := upcastNumeric(s.Data()); x[i] != y {
+				t.Errorf("column %d, row %d: got %v, expected %v", j, i, x[i], y)
+			}
+		}
+	}
+}
+
+func TestStataNames(t *testing.T) {
+
+	long := strings.Repeat("x", 40)
+	names := stataNames([]string{"first name", "2019", long, long, "", "ok_1", "a-b", "a_b", "ünï", "x_2"})
+	expected := []string{"first_name", "_2019", long[0:32], long[0:30] + "_2", "v5", "ok_1", "a_b", "a_b_2", "ünï", "x_2"}
+	for j, na := range names {
+		if na != expected[j] {
+			t.Errorf("name %d is %q, expected %q", j, na, expected[j])
+		}
+	}
+
+	// The original headers are kept as variable labels
+	rdr, cleanup := convertCSV(t, "first name,2019,x\n1,2,3\n", CSVOptions{})
+	defer cleanup()
+	if n := rdr.ColumnNames(); n[0] != "first_name" || n[1] != "_2019" || n[2] != "x" {
+		t.Errorf("unexpected names %v", n)
+	}
+	if l := rdr.ColumnNamesLong; l[0] != "first name" || l[1] != "2019" || l[2] != "" {
+		t.Errorf("unexpected variable labels %q", l)
+	}
+}
+
+func TestConvertCSVToDtaOptions(t *testing.T) {
+
+	long := strings.Repeat("x", 3000)
+	data := "a,b,c,d,,a,f\n" +
+		"1,NA,2001-02-03,2001-02-03 04:05:06,x,-3000000000,1.5\n" +
+		"NA,abc,,,,40000,NA\n" +
+		"2,\"" + long + "\",2001-02-04,2001-02-03 00:00:00\n"
+	opts := CSVOptions{
+		NullToken:   "NA",
+		DateColumns: map[string]string{"c": "2006-01-02", "d": "2006-01-02 15:04:05"},
+	}
+	rdr, cleanup := convertCSV(t, data, opts)
+	defer cleanup()
+
+	if n := rdr.ColumnNames(); n[4] != "v5" || n[5] != "a_2" {
+		t.Errorf("unexpected names %v", n)
+	}
+	if l := rdr.ColumnNamesLong; l[0] != "" || l[4] != "" || l[5] != "a" {
+		t.Errorf("unexpected variable labels %q", l)
+	}
+	if f := rdr.Formats; f[2] != "%td" || f[3] != "%tc" {
+		t.Errorf("unexpected formats %v", f)
+	}
+	types := rdr.ColumnTypes()
+	for j, e := range []ColumnTypeT{StataInt8Type, StataStrlType, StataFloat64Type, StataFloat64Type, 1, StataFloat64Type, StataFloat64Type} {
+		if types[j] != e {
+			t.Errorf("column %d has type %d, expected %d", j, types[j], e)
+		}
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]int8); x[0] != 1 || !ds[0].IsMissing(1) || x[2] != 2 {
+		t.Errorf("unexpected values %v", x)
+	}
+	if x := ds[1].Data().([]string); x[0] != "" || x[1] != "abc" || x[2] != long {
+		t.Errorf("unexpected strings")
+	}
+	if x := ds[2].Data().([]time.Time); !x[1].IsZero() || !x[2].Equal(time.Date(2001, 2, 4, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected dates %v", x)
+	}
+	if x := ds[3].Data().([]time.Time); !x[0].Equal(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)) {
+		t.Errorf("unexpected times %v", x)
+	}
+	if x := ds[5].Data().([]float64); x[0] != -3000000000 || x[1] != 40000 || !ds[5].IsMissing(2) {
+		t.Errorf("unexpected values %v", x)
+	}
+
+	for _, tc := range []struct {
+		data string
+		opts CSVOptions
+	}{
+		{"a\n1\n", CSVOptions{FormatVersion: 117}},
+		{"", CSVOptions{}},
+		{"a\n1,2\n", CSVOptions{}},
+		{"a\n1\n", CSVOptions{DateColumns: map[string]string{"b": "2006"}}},
+		{"a\nx\n", CSVOptions{DateColumns: map[string]string{"a": "2006"}}},
+	} {
+		if err := ConvertCSVToDta(strings.NewReader(tc.data), nil, tc.opts); err == nil {
+			t.Errorf("expected an error for %q", tc.data)
+		}
+	}
+}
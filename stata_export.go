@@ -0,0 +1,393 @@
+package datareader
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// formatCell returns the text representation of value i of the given
+// Series, using the display format of the column.  Dates are rendered
+// using the template of their format, if pf has one, and otherwise in
+// ISO 8601 form.  Missing values are represented as ".".
+func formatCell(ser *Series, i int, format string, pf ParsedFormat) string {
+
+	if ser.missing != nil && ser.missing[i] {
+		return "."
+	}
+
+	prec := -1
+	if pf.Type == "f" {
+		prec = pf.Precision
+	}
+
+	switch x := ser.data.(type) {
+	default:
+		panic(fmt.Sprintf("unknown data type %T in formatCell", ser.data))
+	case []float64:
+		if prec >= 0 {
+			return strconv.FormatFloat(x[i], 'f', prec, 64)
+		}
+		return strconv.FormatFloat(x[i], 'g', -1, 64)
+	case []float32:
+		if prec >= 0 {
+			return strconv.FormatFloat(float64(x[i]), 'f', prec, 32)
+		}
+		return strconv.FormatFloat(float64(x[i]), 'g', -1, 32)
+	case []int64:
+		return strconv.FormatInt(x[i], 10)
+	case []int32:
+		return strconv.FormatInt(int64(x[i]), 10)
+	case []int16:
+		return strconv.FormatInt(int64(x[i]), 10)
+	case []int8:
+		return strconv.FormatInt(int64(x[i]), 10)
+	case []uint64:
+		return strconv.FormatUint(x[i], 10)
+	case []string:
+		return x[i]
+	case []bool:
+		return strconv.FormatBool(x[i])
+	case [][]byte:
+		return string(x[i])
+	case []time.Time:
+		if pf.Template != "" {
+			return formatDate(x[i], pf)
+		}
+		if strings.HasPrefix(format, "%tc") || strings.HasPrefix(format, "%tC") {
+			return x[i].UTC().Format("2006-01-02 15:04:05")
+		}
+		return x[i].UTC().Format("2006-01-02")
+	}
+}
+
+// WriteFixedWidth reads the remaining rows of the file and writes
+// them to w as aligned text columns, similar to the output of Stata's
+// list command.  The first line contains the variable names.  Each
+// column is at least as wide as its display format, and is widened as
+// needed to fit its name and values.  Columns whose display format
+// has the '-' flag are left-aligned, the other columns are
+// right-aligned.  Missing values are written as ".".
+func (rdr *StataReader) WriteFixedWidth(w io.Writer) error {
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		return err
+	}
+
+	names := rdr.ColumnNames()
+	pfs := make([]ParsedFormat, rdr.Nvar)
+	cells := make([][]string, rdr.Nvar)
+	widths := make([]int, rdr.Nvar)
+	for j := range ds {
+		pfs[j], _ = ParseFormat(rdr.Formats[j])
+		widths[j] = pfs[j].Width
+		if n := utf8.RuneCountInString(names[j]); n > widths[j] {
+			widths[j] = n
+		}
+		cells[j] = make([]string, ds[j].Length())
+		for i := range cells[j] {
+			c := formatCell(ds[j], i, rdr.Formats[j], pfs[j])
+			if n := utf8.RuneCountInString(c); n > widths[j] {
+				widths[j] = n
+			}
+			cells[j][i] = c
+		}
+	}
+
+	line := make([]string, rdr.Nvar)
+	writeLine := func(vals func(j int) string) error {
+		for j := range line {
+			if pfs[j].LeftAlign {
+				line[j] = fmt.Sprintf("%-*s", widths[j], vals(j))
+			} else {
+				line[j] = fmt.Sprintf("%*s", widths[j], vals(j))
+			}
+		}
+		_, err := io.WriteString(w, strings.TrimRight(strings.Join(line, "  "), " ")+"\n")
+		return err
+	}
+
+	if err := writeLine(func(j int) string { return names[j] }); err != nil {
+		return err
+	}
+
+	nrow := 0
+	if len(ds) > 0 {
+		nrow = ds[0].Length()
+	}
+	for i := 0; i < nrow; i++ {
+		if err := writeLine(func(j int) string { return cells[j][i] }); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// doQuote quotes a string for use in a Stata do file.  Compound
+// quotes are used if the string contains a double quote.
+func doQuote(s string) string {
+	if strings.Contains(s, "\"") {
+		return "`\"" + s + "\"'"
+	}
+	return "\"" + s + "\""
+}
+
+// WriteDoFile writes a Stata do file to w that recreates the value
+// labels, variable labels, and display formats of the data set.  The
+// do file contains "label define" commands for every value label
+// set, "label values" commands attaching the label sets to the
+// variables, "label variable" commands for the variable labels, and
+// "format" commands for the display formats.
+func (rdr *StataReader) WriteDoFile(w io.Writer) error {
+
+	var lines []string
+
+	labnames := make([]string, 0, len(rdr.ValueLabels))
+	for k := range rdr.ValueLabels {
+		labnames = append(labnames, k)
+	}
+	sort.Strings(labnames)
+	for _, labname := range labnames {
+		mp := rdr.ValueLabels[labname]
+		codes := make([]int, 0, len(mp))
+		for k := range mp {
+			codes = append(codes, int(k))
+		}
+		sort.Ints(codes)
+		for _, k := range codes {
+			lines = append(lines, fmt.Sprintf("label define %s %d %s, modify", labname, k, doQuote(mp[int32(k)])))
+		}
+	}
+
+	// Stata commands refer to the variable names, regardless of
+	// UseLongNames.
+	names := rdr.columnNames
+	for j, labname := range rdr.ValueLabelNames {
+		if labname != "" {
+			lines = append(lines, fmt.Sprintf("label values %s %s", names[j], labname))
+		}
+	}
+
+	for j, label := range rdr.ColumnNamesLong {
+		if label != "" {
+			lines = append(lines, fmt.Sprintf("label variable %s %s", names[j], doQuote(label)))
+		}
+	}
+
+	for j, format := range rdr.Formats {
+		if format != "" {
+			lines = append(lines, fmt.Sprintf("format %s %s", names[j], format))
+		}
+	}
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, line+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteCSV reads the given number of rows (or the remaining rows, if
+// rows is negative) and writes them to w in CSV format, with a header
+// line containing the column names.  The data are read with Read, so
+// the settings of the reader (InsertStrls, ConvertDates, etc.) apply.
+// Floating point numbers are written with the precision given by
+// ExportDecimals, and times in ISO 8601 format, including the time of day for %tc and %tC variables.
+// Missing values are written as empty fields, or as their Stata codes
+// if CSVMissingCodes is set.
+func (rdr *StataReader) WriteCSV(w io.Writer, rows int) error {
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(rdr.ColumnNames()); err != nil {
+		return err
+	}
+
+	pfs := rdr.exportFormats()
+	line := make([]string, rdr.Nvar)
+	for rows != 0 {
+		n := 1000
+		if rows > 0 && rows < n {
+			n = rows
+		}
+		ds, err := rdr.Read(n)
+		if err != nil {
+			return err
+		}
+		if len(ds) == 0 || ds[0].Length() == 0 {
+			break
+		}
+		if rows > 0 {
+			rows -= ds[0].Length()
+		}
+
+		for i := 0; i < ds[0].Length(); i++ {
+			for j, s := range ds {
+				line[j] = rdr.csvCell(s, i, j, pfs[j])
+			}
+			if err := cw.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportFormats returns, for each variable, the format used by
+// WriteCSV and WriteJSONL for floating point values, following
+// ExportDecimals.
+func (rdr *StataReader) exportFormats() []ParsedFormat {
+
+	pfs := make([]ParsedFormat, rdr.Nvar)
+	for j := range pfs {
+		pfs[j].Precision = -1
+		if rdr.ExportDecimals >= 0 {
+			pfs[j] = ParsedFormat{Type: "f", Precision: rdr.ExportDecimals}
+		} else if pf, err := ParseFormat(rdr.Formats[j]); err == nil && pf.Type == "f" {
+			pfs[j] = ParsedFormat{Type: "f", Precision: pf.Precision}
+		}
+	}
+
+	return pfs
+}
+
+// csvCell returns the text of value i of the Series s, which holds
+// variable j, writing floating point values with the format pf.
+func (rdr *StataReader) csvCell(s *Series, i, j int, pf ParsedFormat) string {
+
+	if s.missing != nil && s.missing[i] {
+		if rdr.CSVMissingCodes {
+			if s.missingCodes != nil && s.missingCodes[i] != 0 {
+				return s.missingCodes[i].String()
+			}
+			return MissingSystem.String()
+		}
+		return ""
+	}
+
+	if x, ok := s.data.([]time.Time); ok {
+		f := rdr.Formats[j]
+		if strings.HasPrefix(f, "%tc") || strings.HasPrefix(f, "%tC") {
+			return x[i].UTC().Format("2006-01-02T15:04:05.000Z")
+		}
+		return x[i].UTC().Format("2006-01-02")
+	}
+
+	return formatCell(s, i, rdr.Formats[j], pf)
+}
+
+// WriteJSONL reads the given number of rows (or the remaining rows, if
+// rows is negative) and writes them to w as JSON lines, one object per
+// row.  The object keys are the variable labels (ColumnNamesLong),
+// falling back to the variable names for unlabeled variables.  The
+// keys are always normalized as by NormalizeNames, so that variables
+// with the same label have distinct keys.  The data are read with
+// Read, so labeled categoricals and strls are written as strings when
+// InsertCategoryLabels and InsertStrls are set.  Floating point
+// numbers are written with the precision given by ExportDecimals,
+// dates in RFC 3339 format, and missing values as null.
+func (rdr *StataReader) WriteJSONL(w io.Writer, rows int) error {
+
+	names := make([]string, rdr.Nvar)
+	for j, na := range rdr.columnNames {
+		names[j] = na
+		if j < len(rdr.ColumnNamesLong) && rdr.ColumnNamesLong[j] != "" {
+			names[j] = rdr.ColumnNamesLong[j]
+		}
+	}
+	names = normalizeNames(names)
+
+	keys := make([][]byte, rdr.Nvar)
+	for j, na := range names {
+		b, err := json.Marshal(na)
+		if err != nil {
+			return err
+		}
+		keys[j] = b
+	}
+
+	pfs := rdr.exportFormats()
+	var buf bytes.Buffer
+	for rows != 0 {
+		n := 1000
+		if rows > 0 && rows < n {
+			n = rows
+		}
+		ds, err := rdr.Read(n)
+		if err != nil {
+			return err
+		}
+		if len(ds) == 0 || ds[0].Length() == 0 {
+			break
+		}
+		if rows > 0 {
+			rows -= ds[0].Length()
+		}
+
+		for i := 0; i < ds[0].Length(); i++ {
+			buf.Reset()
+			buf.WriteByte('{')
+			for j, s := range ds {
+				if j > 0 {
+					buf.WriteByte(',')
+				}
+				buf.Write(keys[j])
+				buf.WriteByte(':')
+				v, err := jsonCell(s, i, pfs[j])
+				if err != nil {
+					return err
+				}
+				buf.Write(v)
+			}
+			buf.WriteString("}\n")
+			if _, err := w.Write(buf.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonCell returns the JSON encoding of value i of the Series s,
+// writing floating point values with the format pf.
+func jsonCell(s *Series, i int, pf ParsedFormat) ([]byte, error) {
+
+	if s.missing != nil && s.missing[i] {
+		return []byte("null"), nil
+	}
+
+	switch x := s.data.(type) {
+	case []time.Time:
+		return json.Marshal(x[i].UTC().Format(time.RFC3339))
+	case []float64:
+		if math.IsNaN(x[i]) || math.IsInf(x[i], 0) {
+			return []byte("null"), nil
+		}
+		if pf.Precision >= 0 {
+			return []byte(strconv.FormatFloat(x[i], 'f', pf.Precision, 64)), nil
+		}
+	case []float32:
+		if math.IsNaN(float64(x[i])) || math.IsInf(float64(x[i]), 0) {
+			return []byte("null"), nil
+		}
+		if pf.Precision >= 0 {
+			return []byte(strconv.FormatFloat(float64(x[i]), 'f', pf.Precision, 32)), nil
+		}
+	}
+
+	return json.Marshal(s.value(i))
+}
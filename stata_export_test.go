@@ -0,0 +1,265 @@
+package datareader
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFixedWidth(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.Formats[2] = "%-9s"
+
+	var buf bytes.Buffer
+	if err := stata.WriteFixedWidth(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "        x          y  z\n" +
+		"        1        abc  abcdefghi\n" +
+		"        3        cba  qwertywertyqwerty\n" +
+		"       93             strl\n"
+	if buf.String() != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestWriteDoFile(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.ColumnNamesLong[0] = `Here are "some" things`
+
+	var buf bytes.Buffer
+	if err := stata.WriteDoFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`label define alabel 0 "option a", modify`,
+		`label define alabel 1 "option b Ünicode", modify`,
+		`label values Bytes alabel`,
+		"label variable Things `\"Here are \"some\" things\"'",
+		`label variable Ints "int data"`,
+		`format Longs %9.0g`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("missing line: %s", line)
+		}
+	}
+}
+
+func TestUseLongNames(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.ColumnNamesLong[1] = ""
+	stata.UseLongNames = true
+
+	var buf bytes.Buffer
+	if err := stata.WriteFixedWidth(&buf); err != nil {
+		t.Fatal(err)
+	}
+	header := strings.SplitN(buf.String(), "\n", 2)[0]
+	fields := strings.Fields(header)
+	if strings.Join(fields[0:4], " ") != "Here are some things" || fields[4] != "Cities" {
+		t.Errorf("unexpected header: %s", header)
+	}
+
+	// The do file still uses the variable names.
+	buf.Reset()
+	if err := stata.WriteDoFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "label values Bytes alabel\n") {
+		t.Errorf("do file uses the long names")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+
+	openStata := func(fname string) *StataReader {
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata
+	}
+
+	var buf bytes.Buffer
+	stata := openStata("stata9_117.dta")
+	if err := stata.WriteCSV(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("wrote %d lines, expected 3", len(lines))
+	}
+	if lines[0] != "date_tc,date_td,date_tw,date_tm,date_tq,date_th,date_ty" {
+		t.Errorf("unexpected header %s", lines[0])
+	}
+	if lines[2] != "2000-01-01T00:00:00.000Z,2000-01-01,2000-01-01,2000-01-01,2000-01-01,2000-01-01,2000-01-01" {
+		t.Errorf("unexpected line %s", lines[2])
+	}
+
+	buf.Reset()
+	stata = openStata("stata8_117.dta")
+	stata.CSVMissingCodes = true
+	if err := stata.WriteCSV(&buf, -1); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 28 || lines[1] != ".,.,.,.,." || lines[27] != ".z,.z,.z,.z,.z" {
+		t.Errorf("unexpected missing values %q", lines)
+	}
+
+	buf.Reset()
+	stata = openStata("stata14_118.dta")
+	if err := stata.WriteCSV(&buf, -1); err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 || !strings.HasPrefix(lines[2], "Dog,Boston,Uzunköprü,,,,") || !strings.HasSuffix(lines[5], ",0.3333,option a,0.3333333333333333") {
+		t.Errorf("unexpected lines %q", lines)
+	}
+}
+
+func TestExportDecimals(t *testing.T) {
+
+	a, _ := NewSeries("a", []float64{1.23456, 2}, nil)
+	b, _ := NewSeries("b", []float32{1.5, 2.25}, nil)
+	c, _ := NewSeries("c", []float64{1.23456, 2}, nil)
+	read := func() (*StataReader, func()) {
+		return writeAndRead(t, []*Series{a, b, c}, func(w *StataWriter) {
+			w.Formats = []string{"%9.3f", "%8.0f", "%9.0g"}
+		})
+	}
+
+	for _, tc := range []struct {
+		decimals int
+		csv      string
+		jsonl    string
+	}{
+		{-1, "a,b,c\n1.235,2,1.23456\n2.000,2,2\n",
+			`{"a":1.235,"b":2,"c":1.23456}` + "\n" + `{"a":2.000,"b":2,"c":2}` + "\n"},
+		{2, "a,b,c\n1.23,1.50,1.23\n2.00,2.25,2.00\n",
+			`{"a":1.23,"b":1.50,"c":1.23}` + "\n" + `{"a":2.00,"b":2.25,"c":2.00}` + "\n"},
+	} {
+		var buf bytes.Buffer
+		stata, cleanup := read()
+		defer cleanup()
+		stata.ExportDecimals = tc.decimals
+		if err := stata.WriteCSV(&buf, -1); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.csv {
+			t.Errorf("decimals %d: unexpected CSV %q", tc.decimals, buf.String())
+		}
+
+		buf.Reset()
+		stata, cleanup = read()
+		defer cleanup()
+		stata.ExportDecimals = tc.decimals
+		if err := stata.WriteJSONL(&buf, -1); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != tc.jsonl {
+			t.Errorf("decimals %d: unexpected JSON %q", tc.decimals, buf.String())
+		}
+	}
+}
+
+func TestWriteJSONL(t *testing.T) {
+
+	openStata := func(fname string) *StataReader {
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata
+	}
+
+	var buf bytes.Buffer
+	stata := openStata("stata9_117.dta")
+	if err := stata.WriteJSONL(&buf, 2); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wrote %d lines, expected 2", len(lines))
+	}
+	if !strings.HasPrefix(lines[1], `{"date_tc":"2000-01-01T00:00:00Z","date_td":"2000-01-01T00:00:00Z",`) {
+		t.Errorf("unexpected line %s", lines[1])
+	}
+
+	buf.Reset()
+	stata = openStata("stata8_117.dta")
+	if err := stata.WriteJSONL(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimSpace(buf.String()); s != `{"int8_":null,"int16_":null,"int32_":null,"float32_":null,"float64_":null}` {
+		t.Errorf("unexpected missing values %s", s)
+	}
+
+	// Variable labels are used as keys, and value labels as values
+	buf.Reset()
+	stata = openStata("stata4_117.dta")
+	if err := stata.WriteJSONL(&buf, 1); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.HasPrefix(s, `{"A fully labeled variable.":"one","Another fully labeled variable.":"ten",`) {
+		t.Errorf("unexpected labeled values %s", s)
+	}
+
+	// Repeated labels give distinct keys
+	a, _ := NewSeries("a", []int8{1}, nil)
+	b, _ := NewSeries("b", []int8{2}, nil)
+	c, _ := NewSeries("c", []int8{3}, nil)
+	stata, cleanup := writeAndRead(t, []*Series{a, b, c}, func(w *StataWriter) {
+		w.VariableLabels = []string{"Age", "Age", ""}
+	})
+	defer cleanup()
+	buf.Reset()
+	if err := stata.WriteJSONL(&buf, -1); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimSpace(buf.String()); s != `{"Age":1,"Age_2":2,"c":3}` {
+		t.Errorf("unexpected keys %s", s)
+	}
+}
@@ -0,0 +1,124 @@
+package datareader
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// OpenStataFile opens the named dta file and returns a StataReader
+// for it.  The file is closed by calling Close on the reader.
+func OpenStataFile(path string) (*StataReader, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rdr, err := NewStataReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	rdr.closer = f
+
+	return rdr, nil
+}
+
+// Close closes the file that was opened by OpenStataFile.  If the
+// reader was created from an io.ReadSeeker provided by the caller,
+// the caller remains responsible for closing it, and Close does
+// nothing.
+func (rdr *StataReader) Close() error {
+
+	if rdr.closer == nil {
+		return nil
+	}
+
+	err := rdr.closer.Close()
+	rdr.closer = nil
+
+	return err
+}
+
+// NewStataReaderFromZip returns a StataReader for the single dta file
+// contained in the given zip archive.  An error is returned if the
+// archive contains no dta files or more than one.  Since the reader
+// requires random access, the dta file is decompressed into memory.
+func NewStataReaderFromZip(path string) (*StataReader, error) {
+
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var member *zip.File
+	for _, f := range zr.File {
+		if !strings.HasSuffix(strings.ToLower(f.Name), ".dta") {
+			continue
+		}
+		if member != nil {
+			return nil, fmt.Errorf("%s contains more than one dta file", path)
+		}
+		member = f
+	}
+	if member == nil {
+		return nil, fmt.Errorf("%s does not contain a dta file", path)
+	}
+
+	rc, err := member.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStataReader(bytes.NewReader(b))
+}
+
+// NewStataReaderFromReader returns a StataReader for a dta file read
+// from a stream that does not support seeking, such as a network
+// connection.  Since the reader requires random access, the entire
+// stream is read into memory, so the memory used is at least the size
+// of the file.  Files too large to hold in memory should be copied to
+// disk and opened with OpenStataFile instead.
+func NewStataReaderFromReader(r io.Reader) (*StataReader, error) {
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewStataReader(bytes.NewReader(b))
+}
+
+// OpenStataFileGz opens the named gzip-compressed dta file and
+// returns a StataReader for it.  Since the reader requires random
+// access, the file is decompressed into memory, so the memory used is
+// at least the uncompressed size of the file.
+func OpenStataFileGz(path string) (*StataReader, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return NewStataReaderFromReader(gz)
+}
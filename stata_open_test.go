@@ -0,0 +1,195 @@
+package datareader
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeZip creates a zip archive at path containing the given test
+// data files.
+func makeZip(path string, fnames ...string) error {
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for _, fname := range fnames {
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			return err
+		}
+		w, err := zw.Create(fname)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func TestStataZip(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fname := filepath.Join(dir, "one.zip")
+	if err := makeZip(fname, "stata12_117.dta", "test1.csv"); err != nil {
+		t.Fatal(err)
+	}
+	stata, err := NewStataReaderFromZip(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _, _ := ds[2].AsStringSlice()
+	if x[1] != "qwertywertyqwerty" {
+		t.Errorf("unexpected value %q", x[1])
+	}
+
+	fname = filepath.Join(dir, "two.zip")
+	if err := makeZip(fname, "stata12_117.dta", "stata14_118.dta"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStataReaderFromZip(fname); err == nil {
+		t.Errorf("expected an error for an archive with two dta files")
+	}
+
+	fname = filepath.Join(dir, "none.zip")
+	if err := makeZip(fname, "test1.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStataReaderFromZip(fname); err == nil {
+		t.Errorf("expected an error for an archive without dta files")
+	}
+}
+
+func TestOpenStataFile(t *testing.T) {
+
+	stata, err := OpenStataFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 7 || ds[0].Length() != 5 {
+		t.Errorf("unexpected data")
+	}
+	f := stata.closer.(*os.File)
+	if err := stata.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, 0); err == nil {
+		t.Errorf("file was not closed")
+	}
+	if err := stata.Close(); err != nil {
+		t.Errorf("second Close returned %v", err)
+	}
+
+	if _, err := OpenStataFile(filepath.Join("test_files", "data", "nosuchfile.dta")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+
+	// Close does not close a reader provided by the caller
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stata.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Errorf("caller's file was closed")
+	}
+}
+
+func TestStataReaderFromReader(t *testing.T) {
+
+	f, err := os.Open(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// Hide the Seek method of the file
+	stata, err := NewStataReaderFromReader(struct{ io.Reader }{f})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _, _ := ds[2].AsStringSlice()
+	if x[1] != "qwertywertyqwerty" {
+		t.Errorf("unexpected value %q", x[1])
+	}
+}
+
+func TestStataGz(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, "stata12_117.dta.gz")
+	f, err := os.Create(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	stata, err := OpenStataFileGz(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _, _ := ds[2].AsStringSlice()
+	if x[1] != "qwertywertyqwerty" {
+		t.Errorf("unexpected value %q", x[1])
+	}
+
+	// Not compressed
+	if _, err := OpenStataFileGz(filepath.Join("test_files", "data", "stata12_117.dta")); err == nil {
+		t.Errorf("expected an error for an uncompressed file")
+	}
+}
@@ -0,0 +1,137 @@
+package datareader
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReadParallel behaves as Read, but the rows are divided into ranges
+// of consecutive rows that are read and decoded concurrently by the
+// given number of goroutines.  The source of the reader must implement
+// io.ReaderAt (as *os.File does), so that each goroutine can read its
+// range independently, otherwise an error is returned.  Files with
+// strl variables, and files whose text is decoded with
+// SetStringEncoding, are read serially with Read, as are all files if
+// workers is less than 2.  The rows are returned in file order, and
+// ReadParallel shares its position in the file with Read.
+func (rdr *StataReader) ReadParallel(rows, workers int) ([]*Series, error) {
+
+	ra, ok := rdr.reader.(io.ReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("ReadParallel requires a source that implements io.ReaderAt")
+	}
+
+	serial := workers < 2 || rdr.decoder != nil
+	for _, t := range rdr.varTypes {
+		if t == StataStrlType {
+			serial = true
+		}
+	}
+
+	nval := rdr.rowCount - rdr.rowsRead
+	if rows >= 0 && rows < nval {
+		nval = rows
+	}
+
+	// Read also handles the end of the file, and files with no
+	// observations
+	if serial || nval <= 0 {
+		return rdr.Read(rows)
+	}
+
+	if err := rdr.checkRows(rdr.rowsRead + nval); err != nil {
+		return nil, err
+	}
+
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return nil, err
+	}
+
+	data := rdr.allocateCols(nval, nil)
+	missing := make([][]bool, rdr.Nvar)
+	codes := make([][]MissingCode, rdr.Nvar)
+	for j := range missing {
+		missing[j] = make([]bool, nval)
+		codes[j] = make([]MissingCode, nval)
+	}
+
+	start := rdr.dataStart() + int64(rdr.rowsRead)*int64(reclen)
+	chunk := (nval + workers - 1) / workers
+
+	// Each goroutine decodes its rows into its own part of the
+	// slices.
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		first := w * chunk
+		if first >= nval {
+			break
+		}
+		m := chunk
+		if first+m > nval {
+			m = nval - first
+		}
+		wg.Add(1)
+		go func(w, first, m int) {
+			defer wg.Done()
+			r := io.NewSectionReader(ra, start+int64(first)*int64(reclen), int64(m)*int64(reclen))
+			errs[w] = rdr.readRowsAt(r, first, m, reclen, data, missing, codes)
+		}(w, first, m)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Subsequent calls to Read continue after these rows
+	rdr.rowsRead += nval
+	if _, err := rdr.reader.Seek(start+int64(nval)*int64(reclen), 0); err != nil {
+		return nil, err
+	}
+
+	return rdr.makeSeries(data, missing, codes, nval)
+}
+
+// readRowsAt reads m records of length reclen from r, and decodes them
+// into the rows first, first+1, ... of data, missing and codes.  It is
+// safe to call concurrently for ranges of rows that do not overlap.
+func (rdr *StataReader) readRowsAt(r io.Reader, first, m, reclen int, data []interface{}, missing [][]bool, codes [][]MissingCode) error {
+
+	// The position of each variable within a record
+	offsets := make([]int, rdr.Nvar)
+	var off int
+	for j, t := range rdr.varTypes {
+		offsets[j] = off
+		off += varWidth(t)
+	}
+
+	per := 1
+	if reclen > 0 && reclen < 1<<16 {
+		per = (1 << 16) / reclen
+	}
+	block := make([]byte, per*reclen)
+
+	for i := 0; i < m; {
+		n := m - i
+		if n > per {
+			n = per
+		}
+		if _, err := io.ReadFull(r, block[0:n*reclen]); err != nil {
+			return err
+		}
+		for j := range data {
+			b := block[offsets[j] : n*reclen]
+			if err := rdr.decodeColumn(b, n, reclen, rdr.varTypes[j], data[j], first+i, missing[j], codes[j]); err != nil {
+				return err
+			}
+		}
+		i += n
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package datareader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadParallel(t *testing.T) {
+
+	for _, fname := range []string{"stata8_117.dta", "stata8_115.dta", "stata2_117.dta", "stata14_118.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, workers := range []int{1, 2, 3, 100} {
+			stata, err := NewStataReader(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Read the first rows in parallel and the rest
+			// sequentially
+			n := stata.RowCount() / 2
+			x, err := stata.ReadParallel(n, workers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			y, err := stata.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ix1 := make([]int, n)
+			for i := range ix1 {
+				ix1[i] = i
+			}
+			ix2 := make([]int, stata.RowCount()-n)
+			for i := range ix2 {
+				ix2[i] = n + i
+			}
+			for j := range full {
+				if eq, i := full[j].selectRows(ix1).AllEqual(x[j]); !eq {
+					t.Errorf("%s: column %d differs at row %d with %d workers", fname, j, i, workers)
+				}
+				if eq, i := full[j].selectRows(ix2).AllEqual(y[j]); !eq {
+					t.Errorf("%s: column %d differs at row %d after reading with %d workers", fname, j, n+i, workers)
+				}
+			}
+			if ds, err := stata.ReadParallel(-1, workers); err != nil || ds != nil {
+				t.Errorf("%s: expected no rows at the end of the file", fname)
+			}
+		}
+	}
+
+	// A source without ReadAt
+	r, err := os.Open(filepath.Join("test_files", "data", "stata8_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(&readRecorder{r: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stata.ReadParallel(-1, 2); err == nil {
+		t.Errorf("expected an error for a source without ReadAt")
+	}
+}
+
+func BenchmarkReadSerial(b *testing.B) {
+	benchmarkReadParallel(b, 1)
+}
+
+func BenchmarkReadParallel(b *testing.B) {
+	benchmarkReadParallel(b, 4)
+}
+
+func benchmarkReadParallel(b *testing.B, workers int) {
+
+	fname := benchFile(b, 50000, false, binary.LittleEndian)
+	defer os.Remove(fname)
+
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		f, err := os.Open(fname)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stata, err := NewStataReader(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := stata.ReadParallel(-1, workers); err != nil {
+			b.Fatal(err)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,48 @@
+//go:build arrow
+// +build arrow
+
+package datareader
+
+import (
+	"io"
+
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+// WriteParquet reads the given number of rows (or the remaining rows,
+// if rows is negative) and writes them to w as a Parquet file.  The
+// columns are converted as in WriteArrow: missing values are nulls,
+// and dates become timestamps (in milliseconds, UTC) when ConvertDates
+// is set.  String columns, including labeled categoricals when
+// InsertCategoryLabels is set, are dictionary encoded.  The Arrow
+// schema, with the variable labels and display formats, is stored in
+// the file metadata.  If no rows remain, io.EOF is returned and nothing
+// is written.  WriteParquet is only available when building with the
+// arrow tag.
+func (rdr *StataReader) WriteParquet(w io.Writer, rows int) error {
+
+	rec, err := rdr.WriteArrow(rows)
+	if err != nil {
+		return err
+	}
+	defer rec.Release()
+
+	props := parquet.NewWriterProperties(
+		parquet.WithDictionaryDefault(true),
+		parquet.WithCompression(compress.Codecs.Snappy),
+	)
+	arrprops := pqarrow.NewArrowWriterProperties(pqarrow.WithStoreSchema())
+
+	fw, err := pqarrow.NewFileWriter(rec.Schema(), w, props, arrprops)
+	if err != nil {
+		return err
+	}
+	if err := fw.Write(rec); err != nil {
+		fw.Close()
+		return err
+	}
+
+	return fw.Close()
+}
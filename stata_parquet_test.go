@@ -0,0 +1,84 @@
+//go:build arrow
+// +build arrow
+
+package datareader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet/file"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+func TestWriteParquet(t *testing.T) {
+
+	open := func() *StataReader {
+		r, err := os.Open(filepath.Join("test_files", "data", "stata2_117.dta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata
+	}
+
+	ds, err := open().Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stata := open()
+	var buf bytes.Buffer
+	if err := stata.WriteParquet(&buf, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	pf, err := file.NewParquetReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.Close()
+	fr, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := fr.ReadTable(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.Release()
+
+	if int(tbl.NumRows()) != stata.RowCount() || int(tbl.NumCols()) != stata.Nvar {
+		t.Fatalf("table has %d rows and %d columns", tbl.NumRows(), tbl.NumCols())
+	}
+	for j, s := range ds {
+		if f := tbl.Schema().Field(j); f.Name != s.Name {
+			t.Errorf("column %d has name %s, expected %s", j, f.Name, s.Name)
+		}
+		var nulls int
+		for _, c := range tbl.Column(j).Data().Chunks() {
+			nulls += c.NullN()
+		}
+		var nmiss int
+		for i := 0; i < s.Length(); i++ {
+			if s.IsMissing(i) {
+				nmiss++
+			}
+		}
+		if nulls != nmiss {
+			t.Errorf("column %d has %d nulls, expected %d", j, nulls, nmiss)
+		}
+	}
+
+	if err := stata.WriteParquet(&buf, -1); err != io.EOF {
+		t.Errorf("expected io.EOF when no rows remain, got %v", err)
+	}
+}
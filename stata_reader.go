@@ -4,16 +4,23 @@
 package datareader
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/rand"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
+	xencoding "golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
 )
 
 // These are constants used in Dta files to represent different data types.
@@ -26,13 +33,117 @@ const (
 	StataStrlType    ColumnTypeT = 32768
 )
 
+// StataType is the kind of a Stata variable, without the width of
+// string variables.
+type StataType int
+
+// The kinds of Stata variables.  StataUnknown is returned for a type
+// code that is not valid.
+const (
+	StataUnknown StataType = iota
+	StataByte
+	StataInt
+	StataLong
+	StataFloat
+	StataDouble
+	StataStr
+	StataStrL
+)
+
+// String returns the Stata name of the type, e.g. "byte" or "strL".
+func (t StataType) String() string {
+	switch t {
+	case StataByte:
+		return "byte"
+	case StataInt:
+		return "int"
+	case StataLong:
+		return "long"
+	case StataFloat:
+		return "float"
+	case StataDouble:
+		return "double"
+	case StataStr:
+		return "str"
+	case StataStrL:
+		return "strL"
+	default:
+		return "unknown"
+	}
+}
+
+// The system missing value (".") for each numeric type.  The
+// extended missing values .a, .b, ..., .z follow in order, spaced by
+// one for the integer types and by 1<<11 and 1<<40 in the bit
+// patterns of the float and double types.
+const (
+	stataMissingInt8    int8   = 101
+	stataMissingInt16   int16  = 32741
+	stataMissingInt32   int32  = 2147483621
+	stataMissingFloat32 uint32 = 0x7f000000
+	stataMissingFloat64 uint64 = 0x7fe0000000000000
+)
+
+// DefaultStataEncoding is the name of the character set reported by
+// the Encoding field of StataReaders for files of version 117 and
+// earlier, which do not record their encoding.
+var DefaultStataEncoding = "windows-1252"
+
 var (
-	supportedDtaVersions = []int{114, 115, 117, 118}
-	rowCountLength       = map[int]int{114: 4, 115: 4, 117: 4, 118: 8}
-	nvarLength           = map[int]int{114: 2, 115: 2, 117: 2, 118: 2}
-	datasetLabelLength   = map[int]int{117: 1, 118: 2}
-	valueLabelLength     = map[int]int{117: 33, 118: 129}
-	voLength             = map[int]int{117: 8, 118: 12}
+	supportedDtaVersions = []int{108, 110, 111, 112, 113, 114, 115, 117, 118, 119}
+	rowCountLength       = map[int]int{108: 4, 110: 4, 111: 4, 112: 4, 113: 4, 114: 4, 115: 4, 117: 4, 118: 8, 119: 8}
+	nvarLength           = map[int]int{108: 2, 110: 2, 111: 2, 112: 2, 113: 2, 114: 2, 115: 2, 117: 2, 118: 2, 119: 4}
+	datasetLabelLength   = map[int]int{117: 1, 118: 2, 119: 2}
+	oldLabelWidth        = map[int]int{108: 32, 110: 81, 111: 81, 112: 81, 113: 81, 114: 81, 115: 81}
+	valueLabelLength     = map[int]int{117: 33, 118: 129, 119: 129}
+	voLength             = map[int]int{117: 8, 118: 12, 119: 12}
+	strlVLength          = map[int]int{117: 4, 118: 2, 119: 3}
+
+	// The formats of variables that are converted to time.Time
+	// values when ConvertDates is set.
+	dateFormats = []string{"%tc", "%tC", "%td", "%tw", "%tm", "%tq", "%th", "%ty"}
+
+	// Date formats that cannot be converted to time.Time values.
+	// Business calendar dates (%tb) depend on a calendar that is
+	// defined outside the file, and generic dates (%tg) have no
+	// fixed unit.
+	otherDateFormats = []string{"%tb", "%tg"}
+
+	// The origin of Stata dates
+	stataEpoch = time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// The days at the end of which a leap second was inserted, as
+	// the following midnight (UTC).  %tC values count these seconds,
+	// %tc values do not.
+	leapSeconds = []time.Time{
+		time.Date(1972, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1973, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1974, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1975, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1976, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1977, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1978, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1979, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1981, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1982, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1983, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1985, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1988, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1991, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1992, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1993, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1994, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1996, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1997, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2006, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2009, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2012, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2015, 7, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
 )
 
 func logerr(err error) {
@@ -42,7 +153,7 @@ func logerr(err error) {
 }
 
 // StataReader reads Stata dta data files.  Currently dta format
-// versions 114, 115, 117, and 118 can be read.
+// versions 108, 110 through 115, 117, 118, and 119 can be read.
 //
 // The Read method reads and returns the data.  Several fields of the
 // StataReader struct may also be of interest.
@@ -52,16 +163,81 @@ func logerr(err error) {
 type StataReader struct {
 
 	// If true, the strl numerical codes are replaced with their
-	// string values when available.
+	// string values when available.  Binary strls are inserted
+	// with their contents unchanged.
 	InsertStrls bool
 
+	// If true (and InsertStrls is true), strl variables are
+	// returned as [][]byte values rather than as strings, so that
+	// binary strls can be distinguished from text.  Text strls
+	// are returned without their terminating null byte.  Use
+	// ReadColumns to read only some of the strl variables in this
+	// way.
+	BinaryStrls bool
+
 	// If true, the categorial numerical codes are replaced with
-	// their string labels when available.
+	// their string labels when available.  This applies to labeled
+	// variables of any numeric type.  Codes without a label are
+	// replaced with their numeric value as a string.
 	InsertCategoryLabels bool
 
-	// If true, dates are converted to Go date format.
+	// If true, dates are converted to Go date format.  Business
+	// calendar (%tb) and generic (%tg) dates are not converted,
+	// see UnconvertedDates.
 	ConvertDates bool
 
+	// The origin of the dates that are converted when ConvertDates
+	// is set, which is 1960-01-01 UTC in files written by Stata.
+	// Dates are converted as numbers of periods since DateEpoch,
+	// so that files that use a different origin can be read.  %ty
+	// dates hold years, and do not depend on DateEpoch.  If zero,
+	// the Stata epoch is used.  A file may give its own origin in
+	// the "epoch" characteristic of the data set, see readEpoch.
+	DateEpoch time.Time
+
+	// If true, byte variables whose non-missing values are all 0
+	// or 1 are returned as bool values.  A byte variable with a
+	// value label set only qualifies if the labels are attached
+	// to the codes 0 and 1 alone.  Detection is based on all the
+	// rows of the file, so that a variable has the same type in
+	// every chunk that is read.
+	DetectBooleans bool
+
+	// If true, all numeric variables except dates are returned as
+	// float64 values, with missing values set to NaN.
+	ForceFloat64 bool
+
+	// If true, the variable labels (ColumnNamesLong) are used in
+	// place of the variable names, for variables that have a
+	// label.  This affects ColumnNames, the names of the Series
+	// returned by Read, and the column headers of the exporters.
+	UseLongNames bool
+
+	// If true, empty variable names are replaced with "v1", "v2",
+	// ... (according to the position of the variable), and
+	// repeated names are made unique by appending "_2", "_3", ...
+	// to the later occurrences.  This affects ColumnNames, the
+	// names of the Series returned by Read, and the keys used by
+	// ReadAll and the exporters.  The names in the file are
+	// available from OriginalColumnNames.
+	NormalizeNames bool
+
+	// If not nil, ProgressFunc is called as Read (and the methods
+	// that use it) decodes each block of rows, with the number of
+	// rows of the file that have been read, and RowCount.
+	ProgressFunc func(rowsRead, totalRows int)
+
+	// If true, WriteCSV writes missing values as their Stata codes
+	// (".", ".a", ..., ".z") rather than as empty fields.
+	CSVMissingCodes bool
+
+	// The number of decimals used by WriteCSV and WriteJSONL for
+	// floating point values.  If negative (the default), values are
+	// written with the number of decimals of the variable's fixed
+	// display format (e.g. 3 for %9.3f), or with full precision if
+	// the variable does not have a fixed format.
+	ExportDecimals int
+
 	// A short text label for the data set.
 	DatasetLabel string
 
@@ -80,17 +256,45 @@ type StataReader struct {
 	// A name for each variable
 	columnNames []string
 
+	// The position of each variable name, and of each variable
+	// name in lower case.  Repeated names refer to their first
+	// occurrence.
+	nameIndex     map[string]int
+	nameIndexFold map[string]int
+
 	// An additional text entry describing each variable
 	ColumnNamesLong []string
 
-	// String labels for categorical variables
+	// Characteristics, keyed by variable name and then by
+	// characteristic name.  Characteristics of the data set are
+	// stored under the variable name "_dta".
+	Characteristics map[string]map[string]string
+
+	// String labels for categorical variables.  All versions of
+	// the dta format store the labeled codes as 4 byte integers,
+	// so values outside the range of int32 never have a label.
 	ValueLabels     map[string]map[int32]string
 	ValueLabelNames []string
 
 	// Format codes for each variable
 	Formats []string
 
-	// Maps from strl keys to values
+	// If true, strl values are read from the file when a row refers
+	// to them, rather than all being held in Strls and StrlsBytes.
+	LazyStrls bool
+
+	// If true, numeric values in the ranges that Stata reserves for
+	// missing values are returned as data rather than being flagged
+	// as missing, for files that use such values as real data.
+	KeepExtremeValues bool
+
+	// The number of strl values kept in memory when LazyStrls is
+	// set, so that values referred to by many rows are not read
+	// again.  The least recently used values are dropped first.
+	StrlCacheSize int
+
+	// Maps from strl keys to values, populated when data are first
+	// read (unless LazyStrls is set)
 	Strls      map[uint64]string
 	StrlsBytes map[uint64][]byte
 
@@ -100,9 +304,19 @@ type StataReader struct {
 	// The endian-ness of the file
 	ByteOrder binary.ByteOrder
 
+	// The name of the character set of the text in the file.  This
+	// is "utf-8" for files of version 118 and later, and otherwise
+	// DefaultStataEncoding, or the encoding given to
+	// SetStringEncoding.
+	Encoding string
+
 	// The number of rows of data that have been read.
 	rowsRead int
 
+	// True if empty Series have been returned for a file with no
+	// observations.
+	emptyReturned bool
+
 	// Map information
 	seekVartypes        int64
 	seekVarnames        int64
@@ -115,22 +329,69 @@ type StataReader struct {
 	seekStrls           int64
 	seekValueLabels     int64
 
+	// The position of each GSO in the strls section, keyed by strl
+	// key
+	strlOffsets map[uint64]int64
+
+	// Reads and caches strl values when LazyStrls is set
+	strlResolver *StrlResolver
+
+	// The variables found to be boolean by DetectBooleans, nil until
+	// they are first needed, and the setting of KeepExtremeValues
+	// with which they were found
+	booleanCols     []bool
+	booleanColsKeep bool
+
 	// Indicates the columns that contain dates
 	isDate []bool
 
+	// Decodes the text of files that are not in UTF-8, nil if the
+	// text is not decoded
+	decoder *xencoding.Decoder
+
+	// The time spent in each phase of reading the metadata
+	timings map[string]time.Duration
+
 	// An io channel from which the data are read
 	reader io.ReadSeeker
+
+	// Closes the reader, if it is owned by the StataReader
+	closer io.Closer
 }
 
-// NewStataReader returns a StataReader for reading from the given io.ReadSeeker.
+// StataReaderOptions holds settings that take effect while the
+// metadata are read, so cannot be changed after a StataReader is
+// constructed.
+type StataReaderOptions struct {
+
+	// If true, the time spent in each phase of parsing the
+	// metadata is recorded, and reported by the Timings method.
+	RecordTimings bool
+}
+
+// NewStataReader returns a StataReader for reading from the given
+// io.ReadSeeker.  Only the metadata are read, the data section is not
+// read until Read (or a similar method) is called.
 func NewStataReader(r io.ReadSeeker) (*StataReader, error) {
+	return NewStataReaderWithOptions(r, StataReaderOptions{})
+}
+
+// NewStataReaderWithOptions behaves as NewStataReader, using the
+// given options while reading the metadata.
+func NewStataReaderWithOptions(r io.ReadSeeker, opts StataReaderOptions) (*StataReader, error) {
 	rdr := new(StataReader)
 	rdr.reader = r
+	if opts.RecordTimings {
+		rdr.timings = make(map[string]time.Duration)
+	}
 
 	// Defaults, can be changed before reading
 	rdr.InsertStrls = true
 	rdr.InsertCategoryLabels = true
 	rdr.ConvertDates = true
+	rdr.DateEpoch = stataEpoch
+	rdr.StrlCacheSize = 1000
+	rdr.ExportDecimals = -1
 
 	err := rdr.init()
 	if err != nil {
@@ -144,11 +405,163 @@ func (rdr *StataReader) RowCount() int {
 	return rdr.rowCount
 }
 
-// ColumnNames returns the names of the columns in the data file.
+// TimeStampTime returns the time stamp of the data set, which Stata
+// records in the form "17 Jan 2020 14:30", as a time.Time in UTC.  If
+// the file has no time stamp, the zero time is returned.
+func (rdr *StataReader) TimeStampTime() (time.Time, error) {
+
+	f := strings.Fields(rdr.TimeStamp)
+	if len(f) == 0 {
+		return time.Time{}, nil
+	}
+	if len(f) == 4 && len(f[1]) == 3 {
+		f[1] = strings.ToUpper(f[1][0:1]) + strings.ToLower(f[1][1:])
+	}
+
+	t, err := time.Parse("2 Jan 2006 15:04", strings.Join(f, " "))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time stamp %q", rdr.TimeStamp)
+	}
+
+	return t, nil
+}
+
+// ColumnNames returns the names of the columns in the data file.  If
+// UseLongNames is true, the variable labels are returned for the
+// columns that have a label.  If NormalizeNames is true, the names
+// are normalized.
 func (rdr *StataReader) ColumnNames() []string {
+
+	if !rdr.UseLongNames {
+		return rdr.variableNames()
+	}
+
+	names := make([]string, len(rdr.columnNames))
+	for j, na := range rdr.columnNames {
+		names[j] = na
+		if j < len(rdr.ColumnNamesLong) && rdr.ColumnNamesLong[j] != "" {
+			names[j] = rdr.ColumnNamesLong[j]
+		}
+	}
+
+	if rdr.NormalizeNames {
+		return normalizeNames(names)
+	}
+
+	return names
+}
+
+// ColumnIndex returns the position of the variable with the given
+// name, which is matched against ColumnNames.  The second return value
+// is false if there is no such variable.  If a name occurs more than
+// once, the position of the first occurrence is returned.
+func (rdr *StataReader) ColumnIndex(name string) (int, bool) {
+
+	if !rdr.UseLongNames && !rdr.NormalizeNames {
+		j, ok := rdr.nameIndex[name]
+		return j, ok
+	}
+
+	for j, na := range rdr.ColumnNames() {
+		if na == name {
+			return j, true
+		}
+	}
+
+	return -1, false
+}
+
+// ColumnIndexFold behaves as ColumnIndex, but ignores the case of the
+// names.  An exact match is preferred to a match that differs in case.
+func (rdr *StataReader) ColumnIndexFold(name string) (int, bool) {
+
+	if j, ok := rdr.ColumnIndex(name); ok {
+		return j, true
+	}
+
+	if !rdr.UseLongNames && !rdr.NormalizeNames {
+		j, ok := rdr.nameIndexFold[strings.ToLower(name)]
+		return j, ok
+	}
+
+	for j, na := range rdr.ColumnNames() {
+		if strings.EqualFold(na, name) {
+			return j, true
+		}
+	}
+
+	return -1, false
+}
+
+// indexNames builds the maps used by ColumnIndex and ColumnIndexFold.
+func (rdr *StataReader) indexNames() {
+
+	rdr.nameIndex = make(map[string]int, len(rdr.columnNames))
+	rdr.nameIndexFold = make(map[string]int, len(rdr.columnNames))
+	for j, na := range rdr.columnNames {
+		if _, ok := rdr.nameIndex[na]; !ok {
+			rdr.nameIndex[na] = j
+		}
+		lna := strings.ToLower(na)
+		if _, ok := rdr.nameIndexFold[lna]; !ok {
+			rdr.nameIndexFold[lna] = j
+		}
+	}
+}
+
+// OriginalColumnNames returns the variable names as they are stored
+// in the file, regardless of UseLongNames and NormalizeNames.
+func (rdr *StataReader) OriginalColumnNames() []string {
+	return rdr.columnNames
+}
+
+// variableNames returns the variable names, normalized if
+// NormalizeNames is set.
+func (rdr *StataReader) variableNames() []string {
+
+	if rdr.NormalizeNames {
+		return normalizeNames(rdr.columnNames)
+	}
+
 	return rdr.columnNames
 }
 
+// normalizeNames returns a copy of names in which the empty names are
+// replaced with "v" followed by their position (starting at 1), and
+// the repeated names are made unique by appending "_2", "_3", ....  A
+// generated name that is already in use is skipped.
+func normalizeNames(names []string) []string {
+
+	used := make(map[string]bool, len(names))
+	for _, na := range names {
+		used[na] = true
+	}
+
+	seen := make(map[string]int, len(names))
+	rnames := make([]string, len(names))
+	for j, na := range names {
+		if na == "" {
+			na = fmt.Sprintf("v%d", j+1)
+			for k := 2; used[na]; k++ {
+				na = fmt.Sprintf("v%d_%d", j+1, k)
+			}
+			used[na] = true
+		} else if seen[na] > 0 {
+			k := seen[na] + 1
+			for used[fmt.Sprintf("%s_%d", na, k)] {
+				k++
+			}
+			seen[na] = k
+			na = fmt.Sprintf("%s_%d", na, k)
+			used[na] = true
+		}
+		seen[na]++
+		rnames[j] = na
+	}
+
+	return rnames
+}
+
 // ColumnTypes returns integer codes corresponding to the data types
 // in the Stata file.  See the Stata dta doumentation for more
 // information.
@@ -156,13 +569,360 @@ func (rdr *StataReader) ColumnTypes() []ColumnTypeT {
 	return rdr.varTypes
 }
 
+// GoType returns the type of the values of the variable in the given
+// column, in the Series returned by Read with the current settings of
+// the reader, e.g. int8 for a byte variable, string for a labeled
+// variable when InsertCategoryLabels is set, and time.Time for a date
+// when ConvertDates is set.  Since DetectBooleans depends on the data
+// in the file, it is not taken into account: a byte variable that
+// holds only 0 and 1 is reported as int8 but returned as bool.  nil
+// is returned if the column is out of range.
+func (rdr *StataReader) GoType(col int) reflect.Type {
+
+	if col < 0 || col >= rdr.Nvar {
+		return nil
+	}
+
+	selected := make([]bool, rdr.Nvar)
+	selected[col] = true
+	t := reflect.TypeOf(rdr.allocateCols(0, selected)[col]).Elem()
+
+	switch {
+	case t.Kind() == reflect.String || t.Kind() == reflect.Slice || t.Kind() == reflect.Uint64:
+		// Strings and strls
+	case rdr.InsertCategoryLabels && rdr.labeledColumns()[col] != nil:
+		t = reflect.TypeOf("")
+	case rdr.ConvertDates && rdr.isDate[col]:
+		t = reflect.TypeOf(time.Time{})
+	}
+
+	return t
+}
+
+// UnconvertedDates returns the names of the variables that have date
+// formats which cannot be converted to time.Time values, namely
+// business calendar (%tb) and generic (%tg) dates.  These variables
+// are returned as numbers by Read, regardless of ConvertDates.
+func (rdr *StataReader) UnconvertedDates() []string {
+
+	var names []string
+	for j, f := range rdr.Formats {
+		for _, df := range otherDateFormats {
+			if strings.HasPrefix(f, df) {
+				names = append(names, rdr.columnNames[j])
+			}
+		}
+	}
+
+	return names
+}
+
+// VariableValueLabels returns the value labels of the variable in the
+// given column, and true if the variable has value labels.  The map
+// is shared with ValueLabels and should not be modified.
+func (rdr *StataReader) VariableValueLabels(col int) (map[int32]string, bool) {
+
+	if col < 0 || col >= len(rdr.ValueLabelNames) || rdr.ValueLabelNames[col] == "" {
+		return nil, false
+	}
+
+	labels, ok := rdr.ValueLabels[rdr.ValueLabelNames[col]]
+	return labels, ok
+}
+
+// LabeledColumns returns the positions of the variables that have
+// value labels, in increasing order.  A variable that names a label
+// set that is absent from the file, or that has no labels, is not
+// included.  These are the variables whose values are replaced with
+// labels when InsertCategoryLabels is set.
+func (rdr *StataReader) LabeledColumns() []int {
+
+	var cols []int
+	for j, mp := range rdr.labeledColumns() {
+		if mp != nil {
+			cols = append(cols, j)
+		}
+	}
+
+	return cols
+}
+
+// IsDate returns, for each column, whether the column holds dates
+// according to its display format.  These are the columns that are
+// converted to time.Time values when ConvertDates is true, and hold
+// the raw Stata date values otherwise.
+func (rdr *StataReader) IsDate() []bool {
+
+	isDate := make([]bool, len(rdr.isDate))
+	copy(isDate, rdr.isDate)
+
+	return isDate
+}
+
+// ColumnType returns the kind of the variable in column i, and for
+// str# variables, the width of the string.  The width is zero for
+// other variables.  StataUnknown is returned if the column is out of
+// range.
+func (rdr *StataReader) ColumnType(i int) (StataType, int) {
+
+	if i < 0 || i >= rdr.Nvar {
+		return StataUnknown, 0
+	}
+
+	switch t := rdr.varTypes[i]; {
+	case t <= 2045:
+		return StataStr, int(t)
+	case t == StataStrlType:
+		return StataStrL, 0
+	case t == StataFloat64Type:
+		return StataDouble, 0
+	case t == StataFloat32Type:
+		return StataFloat, 0
+	case t == StataInt32Type:
+		return StataLong, 0
+	case t == StataInt16Type:
+		return StataInt, 0
+	case t == StataInt8Type:
+		return StataByte, 0
+	default:
+		return StataUnknown, 0
+	}
+}
+
+// SetStringEncoding sets the encoding of the text in a file of version
+// 117 or earlier, which can be in any encoding (often Windows-1252).
+// The names, labels, and other metadata that have already been read
+// are converted to UTF-8, as are the string values that are read
+// subsequently.  Files of version 118 and later are in UTF-8, and
+// are not affected.  SetStringEncoding must be called before reading
+// any data, and can only be called once.
+func (rdr *StataReader) SetStringEncoding(enc xencoding.Encoding) error {
+
+	if rdr.FormatVersion >= 118 {
+		return nil
+	}
+	if rdr.decoder != nil {
+		return fmt.Errorf("the string encoding has already been set")
+	}
+	if rdr.rowsRead > 0 {
+		return fmt.Errorf("the string encoding must be set before reading data")
+	}
+	rdr.decoder = enc.NewDecoder()
+	if name, err := htmlindex.Name(enc); err == nil {
+		rdr.Encoding = name
+	}
+
+	rdr.DatasetLabel = rdr.decode(rdr.DatasetLabel)
+	for _, x := range [][]string{rdr.columnNames, rdr.ColumnNamesLong, rdr.ValueLabelNames} {
+		for j := range x {
+			x[j] = rdr.decode(x[j])
+		}
+	}
+	rdr.indexNames()
+
+	for k, v := range rdr.Strls {
+		rdr.Strls[k] = rdr.decode(v)
+	}
+
+	if rdr.ValueLabels != nil {
+		vl := make(map[string]map[int32]string, len(rdr.ValueLabels))
+		for labname, mp := range rdr.ValueLabels {
+			for k, v := range mp {
+				mp[k] = rdr.decode(v)
+			}
+			vl[rdr.decode(labname)] = mp
+		}
+		rdr.ValueLabels = vl
+	}
+
+	if rdr.Characteristics != nil {
+		ch := make(map[string]map[string]string, len(rdr.Characteristics))
+		for varname, mp := range rdr.Characteristics {
+			mp1 := make(map[string]string, len(mp))
+			for k, v := range mp {
+				mp1[rdr.decode(k)] = rdr.decode(v)
+			}
+			ch[rdr.decode(varname)] = mp1
+		}
+		rdr.Characteristics = ch
+	}
+
+	return nil
+}
+
+// decode converts text from the encoding of the file to UTF-8.  Text
+// that cannot be decoded is returned unchanged.
+func (rdr *StataReader) decode(s string) string {
+
+	if rdr.decoder == nil {
+		return s
+	}
+
+	u, err := rdr.decoder.String(s)
+	if err != nil {
+		return s
+	}
+
+	return u
+}
+
+// ColumnInfo describes a variable in a Stata file.
+type ColumnInfo struct {
+
+	// The variable name
+	Name string
+
+	// The variable label, which may be empty
+	LongName string
+
+	// The type code of the variable
+	StataType ColumnTypeT
+
+	// The display format, e.g. "%9.0g"
+	Format string
+
+	// The name of the value label set attached to the variable, if
+	// any
+	ValueLabelName string
+
+	// True if the variable holds dates that are converted to
+	// time.Time values when ConvertDates is set
+	IsDate bool
+}
+
+// Schema returns a description of each variable, in the order that
+// the variables appear in the file.
+func (rdr *StataReader) Schema() []ColumnInfo {
+
+	info := make([]ColumnInfo, rdr.Nvar)
+	for j := range info {
+		info[j] = ColumnInfo{
+			Name:           rdr.columnNames[j],
+			LongName:       rdr.ColumnNamesLong[j],
+			StataType:      rdr.varTypes[j],
+			Format:         rdr.Formats[j],
+			ValueLabelName: rdr.ValueLabelNames[j],
+			IsDate:         rdr.isDate[j],
+		}
+	}
+
+	return info
+}
+
+// FileSummary describes the format and contents of a Stata file, as
+// returned by FileInfo.
+type FileSummary struct {
+
+	// The format version of the dta file, e.g. 118
+	FormatVersion int
+
+	// The byte order of the file, "LittleEndian" or "BigEndian"
+	ByteOrder string
+
+	// The number of variables and observations
+	Nvar     int
+	RowCount int
+
+	// True if any variables are strls
+	HasStrls bool
+
+	// True if the file holds any value labels
+	HasValueLabels bool
+
+	// True if any variables hold dates
+	HasDates bool
+
+	// The data set label and time stamp, which may be empty
+	DatasetLabel string
+	TimeStamp    string
+}
+
+// FileInfo returns a summary of the format and contents of the file,
+// which is assembled from the metadata read by NewStataReader.
+func (rdr *StataReader) FileInfo() FileSummary {
+
+	fs := FileSummary{
+		FormatVersion:  rdr.FormatVersion,
+		ByteOrder:      rdr.ByteOrder.String(),
+		Nvar:           rdr.Nvar,
+		RowCount:       rdr.rowCount,
+		HasValueLabels: len(rdr.ValueLabels) > 0,
+		DatasetLabel:   rdr.DatasetLabel,
+		TimeStamp:      rdr.TimeStamp,
+	}
+	for j, t := range rdr.varTypes {
+		fs.HasStrls = fs.HasStrls || t == StataStrlType
+		fs.HasDates = fs.HasDates || rdr.isDate[j]
+	}
+
+	return fs
+}
+
+// String returns a one line description of the file, e.g. "version
+// 118, LittleEndian, 7 variables, 5 observations, strls", followed by
+// the quoted data set label and time stamp if they are present.
+func (fs FileSummary) String() string {
+
+	parts := []string{
+		fmt.Sprintf("version %d", fs.FormatVersion),
+		fs.ByteOrder,
+		fmt.Sprintf("%d variables", fs.Nvar),
+		fmt.Sprintf("%d observations", fs.RowCount),
+	}
+	if fs.HasStrls {
+		parts = append(parts, "strls")
+	}
+	if fs.HasValueLabels {
+		parts = append(parts, "value labels")
+	}
+	if fs.HasDates {
+		parts = append(parts, "dates")
+	}
+	if fs.DatasetLabel != "" {
+		parts = append(parts, fmt.Sprintf("label %q", fs.DatasetLabel))
+	}
+	if fs.TimeStamp != "" {
+		parts = append(parts, fmt.Sprintf("time stamp %q", fs.TimeStamp))
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Timings returns the time spent in each phase of parsing the file
+// metadata when the reader was constructed, keyed by phase name
+// (e.g. "header", "varnames", "strls", "valuelabels").  nil is
+// returned unless the reader was constructed by
+// NewStataReaderWithOptions with RecordTimings set.
+func (rdr *StataReader) Timings() map[string]time.Duration {
+	if rdr.timings == nil {
+		return nil
+	}
+	tm := make(map[string]time.Duration, len(rdr.timings))
+	for k, v := range rdr.timings {
+		tm[k] = v
+	}
+	return tm
+}
+
+// timed calls f and records the time that it took under the given
+// phase name, if timings are being recorded.
+func (rdr *StataReader) timed(name string, f func() error) error {
+	if rdr.timings == nil {
+		return f()
+	}
+	start := time.Now()
+	err := f()
+	rdr.timings[name] = time.Since(start)
+	return err
+}
+
 func (rdr *StataReader) init() error {
 
 	var err error
 
 	// Determine if we have <117 or >=117 dta version.
 	c := make([]byte, 1)
-	_, err = rdr.reader.Read(c)
+	_, err = io.ReadFull(rdr.reader, c)
 	if err != nil {
 		logerr(err)
 		return err
@@ -172,16 +932,34 @@ func (rdr *StataReader) init() error {
 	}
 
 	if string(c) == "<" {
-		err = rdr.readNewHeader()
+		err = rdr.timed("header", rdr.readNewHeader)
 	} else {
-		err = rdr.readOldHeader()
+		err = rdr.timed("header", rdr.readOldHeader)
 	}
 	if err != nil {
 		logerr(err)
 		return err
 	}
 
-	if err := rdr.readVartypes(); err != nil {
+	if err := rdr.checkCounts(); err != nil {
+		logerr(err)
+		return err
+	}
+
+	if rdr.FormatVersion >= 118 {
+		rdr.Encoding = "utf-8"
+	} else {
+		rdr.Encoding = DefaultStataEncoding
+	}
+
+	if rdr.FormatVersion >= 117 {
+		if err := rdr.checkSections(); err != nil {
+			logerr(err)
+			return err
+		}
+	}
+
+	if err := rdr.timed("vartypes", rdr.readVartypes); err != nil {
 		logerr(err)
 		return err
 	}
@@ -193,10 +971,16 @@ func (rdr *StataReader) init() error {
 		}
 	}
 
-	if err := rdr.readVarnames(); err != nil {
+	if err := rdr.checkVartypes(); err != nil {
+		logerr(err)
+		return err
+	}
+
+	if err := rdr.timed("varnames", rdr.readVarnames); err != nil {
 		logerr(err)
 		return err
 	}
+	rdr.indexNames()
 
 	// Skip over srtlist
 	if rdr.FormatVersion < 117 {
@@ -207,143 +991,384 @@ func (rdr *StataReader) init() error {
 		}
 	}
 
-	if err := rdr.readFormats(); err != nil {
+	if err := rdr.timed("formats", rdr.readFormats); err != nil {
 		logerr(err)
 		return err
 	}
 
-	if err := rdr.readValueLabelNames(); err != nil {
+	if err := rdr.timed("valuelabelnames", rdr.readValueLabelNames); err != nil {
 		logerr(err)
 		return err
 	}
 
-	if err := rdr.readVariableLabels(); err != nil {
+	if err := rdr.timed("variablelabels", rdr.readVariableLabels); err != nil {
 		logerr(err)
 		return err
 	}
 
 	if rdr.FormatVersion < 117 {
-		if err := rdr.readExpansionFields(); err != nil {
+		if err := rdr.timed("expansionfields", rdr.readExpansionFields); err != nil {
 			logerr(err)
 			return err
 		}
-	}
 
-	if rdr.FormatVersion >= 117 {
-		if err := rdr.readStrls(); err != nil {
+		// The data directly follow the expansion fields.
+		if rdr.seekData, err = rdr.reader.Seek(0, 1); err != nil {
 			logerr(err)
 			return err
 		}
+	}
 
-		// Must be called manually for older format < 117.
-		if err := rdr.readValueLabels(); err != nil {
+	if rdr.FormatVersion >= 117 {
+		if err := rdr.timed("characteristics", rdr.readCharacteristics); err != nil {
 			logerr(err)
 			return err
 		}
-	}
-
-	return nil
-}
 
-func (rdr *StataReader) readExpansionFields() error {
-	var b byte
-	var i int32
-
-	for {
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &b)
-		if err != nil {
+		if err := rdr.timed("strls", rdr.readStrls); err != nil {
 			logerr(err)
 			return err
 		}
-		err = binary.Read(rdr.reader, rdr.ByteOrder, &i)
-		if err != nil {
+
+		if err := rdr.timed("valuelabels", rdr.readValueLabels); err != nil {
 			logerr(err)
 			return err
 		}
-
-		if b == 0 && i == 0 {
-			break
-		}
-		if _, err := rdr.reader.Seek(int64(i), 1); err != nil {
+	} else {
+		if err := rdr.timed("valuelabels", rdr.readOldValueLabels); err != nil {
 			logerr(err)
 			return err
 		}
 	}
 
+	rdr.readEpoch()
+
 	return nil
 }
 
-// readInt reads a 1, 2, 4 or 8 byte signed integer.
-func (rdr *StataReader) readInt(width int) (int, error) {
+// epochLayouts are the forms accepted for the "epoch" characteristic,
+// e.g. "1970-01-01", "1970-01-01 00:00:00" or "01jan1970".  Month
+// names are matched regardless of case.
+var epochLayouts = []string{"2006-01-02", "2006-01-02 15:04:05", "02Jan2006", "02Jan2006 15:04:05"}
 
-	switch width {
-	default:
-		return 0, fmt.Errorf("unsupported width %d in readInt", width)
-	case 1:
-		var x int8
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
-		if err != nil {
-			return 0, err
+// readEpoch sets DateEpoch from the "epoch" characteristic of the data
+// set (_dta[epoch]), which files with a non-standard origin for their
+// dates may provide.  If there is no such characteristic, or its value
+// cannot be parsed, DateEpoch is not changed.
+func (rdr *StataReader) readEpoch() {
+
+	v, ok := rdr.Characteristics["_dta"]["epoch"]
+	if !ok {
+		return
+	}
+
+	v = strings.TrimSpace(v)
+	for _, layout := range epochLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			rdr.DateEpoch = t
+			return
 		}
-		return int(x), nil
-	case 2:
-		var x int16
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
+	}
+}
+
+// fileSize returns the size of the file, without changing the
+// position of the reader.
+func (rdr *StataReader) fileSize() (int64, error) {
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return 0, err
+	}
+	size, err := rdr.reader.Seek(0, 2)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// checkCounts rejects negative numbers of variables and observations,
+// and numbers of variables that the file is too small to describe, so
+// that a corrupt header does not lead to huge allocations.
+func (rdr *StataReader) checkCounts() error {
+
+	if rdr.Nvar < 0 {
+		return fmt.Errorf("invalid number of variables %d", rdr.Nvar)
+	}
+	if rdr.rowCount < 0 {
+		return fmt.Errorf("invalid number of observations %d", rdr.rowCount)
+	}
+
+	// Each variable has at least a one byte type code
+	size, err := rdr.fileSize()
+	if err != nil {
+		return err
+	}
+	if int64(rdr.Nvar) > size {
+		return fmt.Errorf("the file is too small to hold %d variables", rdr.Nvar)
+	}
+
+	return nil
+}
+
+// checkRows returns an error if the file is too small to hold the
+// first stop observations, so that space is not allocated for
+// observations that cannot be present, as when the number of
+// observations in the header is corrupt.
+func (rdr *StataReader) checkRows(stop int) error {
+
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return err
+	}
+	if reclen == 0 || stop <= 0 {
+		return nil
+	}
+
+	size, err := rdr.fileSize()
+	if err != nil {
+		return err
+	}
+	avail := size - rdr.dataStart()
+	if avail < 0 || int64(stop) > avail/int64(reclen) {
+		return fmt.Errorf("the file is too small to hold %d observations of %d bytes", stop, reclen)
+	}
+
+	return nil
+}
+
+// readCharacteristics reads the characteristics section (versions
+// 117+).  Each characteristic is stored in a <ch> element, containing
+// its length, the variable name, the characteristic name, and the
+// null-terminated contents.
+func (rdr *StataReader) readCharacteristics() error {
+
+	namew := 129
+	if rdr.FormatVersion == 117 {
+		namew = 33
+	}
+
+	// Skip <characteristics>
+	if _, err := rdr.reader.Seek(rdr.seekCharacteristics+17, 0); err != nil {
+		return err
+	}
+	pos := rdr.seekCharacteristics + 17
+
+	rdr.Characteristics = make(map[string]map[string]string)
+
+	var buf []byte
+	tag := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(rdr.reader, tag); err != nil {
+			return err
+		}
+		if string(tag) != "<ch>" {
+			break
+		}
+
+		var n uint32
+		if err := binary.Read(rdr.reader, rdr.ByteOrder, &n); err != nil {
+			return err
+		}
+		pos += 8
+		if int64(n) < int64(2*namew) || pos+int64(n)+5 > rdr.seekData {
+			return fmt.Errorf("characteristic has invalid length %d", n)
+		}
+
+		if len(buf) < int(n) {
+			buf = make([]byte, n)
+		}
+		if _, err := io.ReadFull(rdr.reader, buf[0:n]); err != nil {
+			return err
+		}
+
+		varname := string(rdr.partition(buf[0:namew]))
+		charname := string(rdr.partition(buf[namew : 2*namew]))
+		mp, ok := rdr.Characteristics[varname]
+		if !ok {
+			mp = make(map[string]string)
+			rdr.Characteristics[varname] = mp
+		}
+		mp[charname] = string(rdr.partition(buf[2*namew : n]))
+
+		// Skip </ch>
+		if _, err := rdr.reader.Seek(5, 1); err != nil {
+			return err
+		}
+		pos += int64(n) + 5
+	}
+
+	return nil
+}
+
+// Notes returns the notes attached to each variable, in order.  The
+// notes for the data set are stored under the empty string.  Notes
+// are stored as the characteristics note1, note2, ..., and note0
+// holds the number of notes; gaps in the numbering are allowed.
+func (rdr *StataReader) Notes() map[string][]string {
+
+	notes := make(map[string][]string)
+	for varname, mp := range rdr.Characteristics {
+		var ix []int
+		for charname := range mp {
+			if !strings.HasPrefix(charname, "note") {
+				continue
+			}
+			k, err := strconv.Atoi(charname[4:])
+			if err != nil || k < 1 {
+				continue
+			}
+			ix = append(ix, k)
+		}
+		if len(ix) == 0 {
+			continue
+		}
+		sort.Ints(ix)
+
+		if varname == "_dta" {
+			varname = ""
+		}
+		for _, k := range ix {
+			notes[varname] = append(notes[varname], mp["note"+strconv.Itoa(k)])
+		}
+	}
+
+	return notes
+}
+
+// readExpansionFields reads the expansion fields that follow the
+// variable labels in versions before 117.  Each field has a type byte
+// and a length.  Fields of type 1 hold a characteristic, as the
+// variable name, the characteristic name and the contents, and are
+// stored in Characteristics, as for later versions.  Other fields are
+// skipped.  The fields end with a field of type 0 and length 0.
+func (rdr *StataReader) readExpansionFields() error {
+
+	// The lengths are 2 bytes wide, and the names are 9 bytes
+	// wide, in version 108 and earlier.
+	width, namew := 4, 33
+	if rdr.FormatVersion <= 108 {
+		width, namew = 2, 9
+	}
+
+	// The end of the file bounds the length of the fields.
+	size, err := rdr.fileSize()
+	if err != nil {
+		logerr(err)
+		return err
+	}
+
+	rdr.Characteristics = make(map[string]map[string]string)
+
+	var b byte
+	var buf []byte
+	for {
+		err := binary.Read(rdr.reader, rdr.ByteOrder, &b)
 		if err != nil {
-			return 0, err
+			logerr(err)
+			return err
 		}
-		return int(x), nil
-	case 4:
-		var x int32
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
+		i, err := rdr.readInt(width)
 		if err != nil {
-			return 0, err
+			logerr(err)
+			return err
+		}
+
+		if b == 0 && i == 0 {
+			break
 		}
-		return int(x), nil
-	case 8:
-		var x int64
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
+
+		pos, err := rdr.reader.Seek(0, 1)
 		if err != nil {
-			return 0, err
+			logerr(err)
+			return err
+		}
+		if i < 0 || int64(i) > size-pos {
+			err := fmt.Errorf("expansion field has invalid length %d", i)
+			logerr(err)
+			return err
+		}
+
+		if b != 1 {
+			if _, err := rdr.reader.Seek(int64(i), 1); err != nil {
+				logerr(err)
+				return err
+			}
+			continue
+		}
+
+		if i < 2*namew {
+			err := fmt.Errorf("characteristic has invalid length %d", i)
+			logerr(err)
+			return err
 		}
-		return int(x), nil
+		if len(buf) < i {
+			buf = make([]byte, i)
+		}
+		if _, err := io.ReadFull(rdr.reader, buf[0:i]); err != nil {
+			logerr(err)
+			return err
+		}
+
+		varname := string(rdr.partition(buf[0:namew]))
+		charname := string(rdr.partition(buf[namew : 2*namew]))
+		mp, ok := rdr.Characteristics[varname]
+		if !ok {
+			mp = make(map[string]string)
+			rdr.Characteristics[varname] = mp
+		}
+		mp[charname] = string(rdr.partition(buf[2*namew : i]))
+	}
+
+	return nil
+}
+
+// readInt reads a 1, 2, 4 or 8 byte signed integer.
+func (rdr *StataReader) readInt(width int) (int, error) {
+
+	var b [8]byte
+	if width != 1 && width != 2 && width != 4 && width != 8 {
+		return 0, fmt.Errorf("unsupported width %d in readInt", width)
+	}
+	if _, err := io.ReadFull(rdr.reader, b[0:width]); err != nil {
+		return 0, err
+	}
+
+	switch width {
+	case 1:
+		return int(int8(b[0])), nil
+	case 2:
+		return int(int16(rdr.ByteOrder.Uint16(b[:]))), nil
+	case 4:
+		return int(int32(rdr.ByteOrder.Uint32(b[:]))), nil
+	default:
+		return int(int64(rdr.ByteOrder.Uint64(b[:]))), nil
 	}
 }
 
 // readUint reads a 1, 2, 4 or 8 byte unsigned integer.
 func (rdr *StataReader) readUint(width int) (int, error) {
 
-	switch width {
-	default:
+	var b [8]byte
+	if width != 1 && width != 2 && width != 4 && width != 8 {
 		panic("unsupported width in readUint")
+	}
+	if _, err := io.ReadFull(rdr.reader, b[0:width]); err != nil {
+		return 0, err
+	}
+
+	switch width {
 	case 1:
-		var x uint8
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
-		if err != nil {
-			return 0, err
-		}
-		return int(x), nil
+		return int(b[0]), nil
 	case 2:
-		var x uint16
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
-		if err != nil {
-			return 0, err
-		}
-		return int(x), nil
+		return int(rdr.ByteOrder.Uint16(b[:])), nil
 	case 4:
-		var x uint32
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
-		if err != nil {
-			return 0, err
-		}
-		return int(x), nil
-	case 8:
-		var x uint64
-		err := binary.Read(rdr.reader, rdr.ByteOrder, &x)
-		if err != nil {
-			return 0, err
-		}
-		return int(x), nil
+		return int(rdr.ByteOrder.Uint32(b[:])), nil
+	default:
+		return int(rdr.ByteOrder.Uint64(b[:])), nil
 	}
 }
 
@@ -364,6 +1389,9 @@ func (rdr *StataReader) readOldHeader() error {
 		return err
 	}
 	rdr.FormatVersion = int(format)
+	if rdr.FormatVersion == 116 {
+		return fmt.Errorf("Stata dta format version 116 is not supported, it was not used by any released version of Stata")
+	}
 	if !rdr.supportedVersion() {
 		return fmt.Errorf("Invalid Stata dta format version: %v\n", rdr.FormatVersion)
 	}
@@ -401,27 +1429,21 @@ func (rdr *StataReader) readOldHeader() error {
 		return err
 	}
 
-	// Data label
-	n, err := rdr.reader.Read(buf[0:81])
-	if err != nil {
+	// Data label, 32 bytes in version 108 and 81 bytes in later
+	// versions
+	w := oldLabelWidth[rdr.FormatVersion]
+	if _, err := io.ReadFull(rdr.reader, buf[0:w]); err != nil {
 		logerr(err)
 		return err
 	}
-	if n != 81 {
-		return fmt.Errorf("stata file appears to be truncated")
-	}
-	rdr.DatasetLabel = string(partition(buf[0:81]))
+	rdr.DatasetLabel = string(rdr.partition(buf[0:w]))
 
 	// Time stamp
-	n, err = rdr.reader.Read(buf[0:18])
-	if err != nil {
+	if _, err := io.ReadFull(rdr.reader, buf[0:18]); err != nil {
 		logerr(err)
 		return err
 	}
-	if n != 18 {
-		return fmt.Errorf("stata file appears to be truncated")
-	}
-	rdr.TimeStamp = string(partition(buf[0:18]))
+	rdr.TimeStamp = string(rdr.partition(buf[0:18]))
 
 	return nil
 }
@@ -443,20 +1465,16 @@ func (rdr *StataReader) readNewHeader() error {
 	var n8 uint8
 
 	// <stata_dta><header><release>
-	n, err := rdr.reader.Read(buf[0:28])
-	if err != nil {
+	if _, err := io.ReadFull(rdr.reader, buf[0:28]); err != nil {
 		logerr(err)
 		return err
 	}
-	if n != 28 {
-		return fmt.Errorf("file appears to be truncated")
-	}
 	if string(buf[0:11]) != "<stata_dta>" {
 		return fmt.Errorf("Invalid Stata file")
 	}
 
 	// Stata file version
-	if _, err := rdr.reader.Read(buf[0:3]); err != nil {
+	if _, err := io.ReadFull(rdr.reader, buf[0:3]); err != nil {
 		logerr(err)
 		return err
 	}
@@ -477,7 +1495,7 @@ func (rdr *StataReader) readNewHeader() error {
 	}
 
 	// Byte order
-	if _, err := rdr.reader.Read(buf[0:3]); err != nil {
+	if _, err := io.ReadFull(rdr.reader, buf[0:3]); err != nil {
 		logerr(err)
 		return err
 	}
@@ -525,14 +1543,10 @@ func (rdr *StataReader) readNewHeader() error {
 		logerr(err)
 		return err
 	}
-	n, err = rdr.reader.Read(buf[0:w])
-	if err != nil {
+	if _, err := io.ReadFull(rdr.reader, buf[0:w]); err != nil {
 		logerr(err)
 		return err
 	}
-	if n != w {
-		return fmt.Errorf("stata file appears to be truncated")
-	}
 	rdr.DatasetLabel = string(buf[0:w])
 
 	// </label><timestamp>
@@ -547,15 +1561,10 @@ func (rdr *StataReader) readNewHeader() error {
 		logerr(err)
 		return err
 	}
-	n, err = rdr.reader.Read(buf[0:n8])
-	if err != nil {
+	if _, err := io.ReadFull(rdr.reader, buf[0:n8]); err != nil {
 		logerr(err)
 		return err
 	}
-	if n != int(n8) {
-		logerr(fmt.Errorf("stata file appears to be truncated"))
-		return err
-	}
 	rdr.TimeStamp = string(buf[0:n8])
 
 	// </timestamp></header><map> + 16 bytes
@@ -599,11 +1608,65 @@ func (rdr *StataReader) readNewHeader() error {
 	return nil
 }
 
+// checkSections confirms that the lengths of the fixed-width
+// metadata sections, as determined by the offsets in the map, are
+// consistent with the number of variables.
+func (rdr *StataReader) checkSections() error {
+
+	// Version 119 has 4 byte sortlist entries, since there may be
+	// more than 65535 variables.
+	var namew, fmtw, labw, sortw int
+	switch rdr.FormatVersion {
+	case 117:
+		namew, fmtw, labw, sortw = 33, 49, 81, 2
+	case 118:
+		namew, fmtw, labw, sortw = 129, 57, 321, 2
+	case 119:
+		namew, fmtw, labw, sortw = 129, 57, 321, 4
+	default:
+		return nil
+	}
+
+	// Some writers store zero for the offset of the variable
+	// labels, which directly follow the value label names.
+	if rdr.seekVariableLabels == 0 {
+		rdr.seekVariableLabels = rdr.seekValueLabelNames + int64(39+namew*rdr.Nvar)
+	}
+
+	// The tags lengths are the combined lengths of the opening and
+	// closing tags, e.g. <varnames> and </varnames>.
+	sections := []struct {
+		name       string
+		start, end int64
+		tags       int
+		n, width   int
+	}{
+		{"variable_types", rdr.seekVartypes, rdr.seekVarnames, 33, rdr.Nvar, 2},
+		{"varnames", rdr.seekVarnames, rdr.seekSortlist, 21, rdr.Nvar, namew},
+		{"sortlist", rdr.seekSortlist, rdr.seekFormats, 21, rdr.Nvar + 1, sortw},
+		{"formats", rdr.seekFormats, rdr.seekValueLabelNames, 19, rdr.Nvar, fmtw},
+		{"value_label_names", rdr.seekValueLabelNames, rdr.seekVariableLabels, 39, rdr.Nvar, namew},
+		{"variable_labels", rdr.seekVariableLabels, rdr.seekCharacteristics, 35, rdr.Nvar, labw},
+	}
+
+	for _, sec := range sections {
+		expected := int64(sec.tags + sec.n*sec.width)
+		if sec.end-sec.start != expected {
+			return fmt.Errorf("%s section has length %d, expected %d for %d variables",
+				sec.name, sec.end-sec.start, expected, rdr.Nvar)
+		}
+	}
+
+	return nil
+}
+
 func (rdr *StataReader) readVartypes() error {
 
 	var err error
 
 	switch {
+	case rdr.FormatVersion == 119:
+		err = rdr.readVartypes16()
 	case rdr.FormatVersion == 118:
 		err = rdr.readVartypes16()
 	case rdr.FormatVersion == 117:
@@ -612,6 +1675,8 @@ func (rdr *StataReader) readVartypes() error {
 		err = rdr.readVartypes8()
 	case rdr.FormatVersion == 114:
 		err = rdr.readVartypes8()
+	case rdr.FormatVersion >= 108 && rdr.FormatVersion <= 113:
+		err = rdr.readVartypes8()
 	default:
 		err = fmt.Errorf("unknown format version %v", rdr.FormatVersion)
 	}
@@ -656,6 +1721,29 @@ func (rdr *StataReader) readVartypes8() error {
 
 func (rdr *StataReader) translateVartypes() error {
 
+	// Version 108 and earlier use letters for the numeric types,
+	// and 0x7f + n for a string of length n.
+	if rdr.FormatVersion <= 108 {
+		for k, t := range rdr.varTypes {
+			switch {
+			case t == 'b':
+				rdr.varTypes[k] = 251
+			case t == 'i':
+				rdr.varTypes[k] = 252
+			case t == 'l':
+				rdr.varTypes[k] = 253
+			case t == 'f':
+				rdr.varTypes[k] = 254
+			case t == 'd':
+				rdr.varTypes[k] = 255
+			case t > 0x7f:
+				rdr.varTypes[k] = t - 0x7f
+			default:
+				return fmt.Errorf("variable %d has unknown type code %d", k, t)
+			}
+		}
+	}
+
 	for k := 0; k < int(rdr.Nvar); k++ {
 		switch {
 		case rdr.varTypes[k] <= 244:
@@ -672,7 +1760,25 @@ func (rdr *StataReader) translateVartypes() error {
 		case rdr.varTypes[k] == 255:
 			rdr.varTypes[k] = StataFloat64Type
 		default:
-			return fmt.Errorf("unknown variable type")
+			return fmt.Errorf("variable %d has unknown type code %d", k, rdr.varTypes[k])
+		}
+	}
+
+	return nil
+}
+
+// checkVartypes returns an error if a variable has a type code that
+// is not known, so that malformed files are rejected before reading.
+func (rdr *StataReader) checkVartypes() error {
+
+	for k, t := range rdr.varTypes {
+		switch {
+		case t >= 1 && t <= 2045:
+		case t == StataStrlType && rdr.FormatVersion >= 117:
+		case t == StataFloat64Type, t == StataFloat32Type, t == StataInt32Type,
+			t == StataInt16Type, t == StataInt8Type:
+		default:
+			return fmt.Errorf("variable %d has unknown type code %d", k, t)
 		}
 	}
 
@@ -684,6 +1790,8 @@ func (rdr *StataReader) readFormats() error {
 	var err error
 
 	switch {
+	case rdr.FormatVersion == 119:
+		err = rdr.doReadFormats(57, true)
 	case rdr.FormatVersion == 118:
 		err = rdr.doReadFormats(57, true)
 	case rdr.FormatVersion == 117:
@@ -692,6 +1800,8 @@ func (rdr *StataReader) readFormats() error {
 		err = rdr.doReadFormats(49, false)
 	case rdr.FormatVersion == 114:
 		err = rdr.doReadFormats(49, false)
+	case rdr.FormatVersion >= 108 && rdr.FormatVersion <= 113:
+		err = rdr.doReadFormats(12, false)
 	default:
 		err = fmt.Errorf("unknown format version %v", rdr.FormatVersion)
 	}
@@ -715,19 +1825,19 @@ func (rdr *StataReader) doReadFormats(bufsize int, seek bool) error {
 
 	rdr.Formats = make([]string, rdr.Nvar)
 	for k := range rdr.Formats {
-		if _, err := rdr.reader.Read(buf); err != nil {
+		if _, err := io.ReadFull(rdr.reader, buf); err != nil {
 			logerr(err)
 			return err
 		}
-		rdr.Formats[k] = string(partition(buf))
+		rdr.Formats[k] = string(rdr.partition(buf))
 	}
 
 	rdr.isDate = make([]bool, rdr.Nvar)
 	for k := range rdr.isDate {
-		if strings.Index(rdr.Formats[k], "%td") == 0 {
-			rdr.isDate[k] = true
-		} else if strings.Index(rdr.Formats[k], "%tc") == 0 {
-			rdr.isDate[k] = true
+		for _, f := range dateFormats {
+			if strings.Index(rdr.Formats[k], f) == 0 {
+				rdr.isDate[k] = true
+			}
 		}
 	}
 
@@ -744,12 +1854,44 @@ func partition(b []byte) []byte {
 	return b
 }
 
+// partition returns the text of a null-terminated field.  In files of
+// version 118 and later, which are in UTF-8, an incomplete multibyte
+// sequence at the end of the text, as left when a tool truncates a
+// string to fit its field, is dropped.
+func (rdr *StataReader) partition(b []byte) []byte {
+	b = partition(b)
+	if rdr.FormatVersion >= 118 {
+		b = trimPartialRune(b)
+	}
+	return b
+}
+
+// trimPartialRune removes an incomplete UTF-8 sequence from the end
+// of b.
+func trimPartialRune(b []byte) []byte {
+	for k := 1; k <= utf8.UTFMax && k <= len(b); k++ {
+		c := b[len(b)-k]
+		if c < utf8.RuneSelf {
+			return b
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(b[len(b)-k:]) {
+				return b[0 : len(b)-k]
+			}
+			return b
+		}
+	}
+	return b
+}
+
 // readVarnames dispatches to the correct function for reading
 // variable names for the dta file format.
 func (rdr *StataReader) readVarnames() error {
 
 	var err error
 	switch rdr.FormatVersion {
+	case 119:
+		err = rdr.doReadVarnames(129, true)
 	case 118:
 		err = rdr.doReadVarnames(129, true)
 	case 117:
@@ -758,6 +1900,10 @@ func (rdr *StataReader) readVarnames() error {
 		err = rdr.doReadVarnames(33, false)
 	case 114:
 		err = rdr.doReadVarnames(33, false)
+	case 110, 111, 112, 113:
+		err = rdr.doReadVarnames(33, false)
+	case 108:
+		err = rdr.doReadVarnames(9, false)
 	default:
 		err = fmt.Errorf("unknown format version %d", rdr.FormatVersion)
 	}
@@ -780,15 +1926,11 @@ func (rdr *StataReader) doReadVarnames(bufsize int, seek bool) error {
 
 	rdr.columnNames = make([]string, rdr.Nvar)
 	for k := 0; k < int(rdr.Nvar); k++ {
-		n, err := rdr.reader.Read(buf)
-		if err != nil {
+		if _, err := io.ReadFull(rdr.reader, buf); err != nil {
 			logerr(err)
 			return err
 		}
-		if n != bufsize {
-			return fmt.Errorf("stata file appears to be truncated")
-		}
-		rdr.columnNames[k] = string(partition(buf))
+		rdr.columnNames[k] = string(rdr.partition(buf))
 	}
 
 	return nil
@@ -798,14 +1940,20 @@ func (rdr *StataReader) readValueLabelNames() error {
 
 	var err error
 	switch rdr.FormatVersion {
+	case 119:
+		err = rdr.doReadValueLabelNames(129, true)
 	case 118:
 		err = rdr.doReadValueLabelNames(129, true)
 	case 117:
 		err = rdr.doReadValueLabelNames(33, true)
-	case 116:
-		err = rdr.doReadValueLabelNames(33, false)
 	case 115:
 		err = rdr.doReadValueLabelNames(33, false)
+	case 114:
+		err = rdr.doReadValueLabelNames(33, false)
+	case 110, 111, 112, 113:
+		err = rdr.doReadValueLabelNames(33, false)
+	case 108:
+		err = rdr.doReadValueLabelNames(9, false)
 	default:
 		return fmt.Errorf("unknown format version %v", rdr.FormatVersion)
 	}
@@ -828,10 +1976,10 @@ func (rdr *StataReader) doReadValueLabelNames(bufsize int, seek bool) error {
 
 	rdr.ValueLabelNames = make([]string, rdr.Nvar)
 	for k := 0; k < int(rdr.Nvar); k++ {
-		if _, err := rdr.reader.Read(buf); err != nil {
+		if _, err := io.ReadFull(rdr.reader, buf); err != nil {
 			return err
 		}
-		rdr.ValueLabelNames[k] = string(partition(buf))
+		rdr.ValueLabelNames[k] = string(rdr.partition(buf))
 	}
 
 	return nil
@@ -841,6 +1989,8 @@ func (rdr *StataReader) readVariableLabels() error {
 
 	var err error
 	switch rdr.FormatVersion {
+	case 119:
+		err = rdr.doReadVariableLabels(321, true)
 	case 118:
 		err = rdr.doReadVariableLabels(321, true)
 	case 117:
@@ -849,6 +1999,10 @@ func (rdr *StataReader) readVariableLabels() error {
 		err = rdr.doReadVariableLabels(81, false)
 	case 114:
 		err = rdr.doReadVariableLabels(81, false)
+	case 110, 111, 112, 113:
+		err = rdr.doReadVariableLabels(81, false)
+	case 108:
+		err = rdr.doReadVariableLabels(32, false)
 	default:
 		err = fmt.Errorf("Unknown format version %d", rdr.FormatVersion)
 	}
@@ -871,88 +2025,71 @@ func (rdr *StataReader) doReadVariableLabels(bufsize int, seek bool) error {
 
 	rdr.ColumnNamesLong = make([]string, rdr.Nvar)
 	for k := 0; k < int(rdr.Nvar); k++ {
-		if _, err := rdr.reader.Read(buf); err != nil {
+		if _, err := io.ReadFull(rdr.reader, buf); err != nil {
 			logerr(err)
 			return err
 		}
-		rdr.ColumnNamesLong[k] = string(partition(buf))
+		rdr.ColumnNamesLong[k] = string(rdr.partition(buf))
 	}
 
 	return nil
 }
 
+// readValueLabels reads the value labels section (versions 117+),
+// which holds a <lbl> element for each label set, and is closed by
+// </value_labels>.  The section may be empty.
 func (rdr *StataReader) readValueLabels() error {
 
 	vl := make(map[string]map[int32]string)
 	buf := make([]byte, 321)
 
+	// The end of the file bounds the size of the label tables.
+	end, err := rdr.reader.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+
 	if _, err := rdr.reader.Seek(rdr.seekValueLabels+14, 0); err != nil {
 		return err
 	}
 
-	var n int32
-	var textlen int32
+	var lbllen uint32
 	vlw := valueLabelLength[rdr.FormatVersion]
 
 	for {
-		if _, err := rdr.reader.Read(buf[0:5]); err != nil {
-			return err
+		if _, err := io.ReadFull(rdr.reader, buf[0:5]); err != nil {
+			return fmt.Errorf("value labels section is not closed: %v", err)
 		}
 		if string(buf[0:5]) != "<lbl>" {
+			if string(buf[0:5]) != "</val" {
+				return fmt.Errorf("unexpected %q in value labels section", buf[0:5])
+			}
 			break
 		}
 
-		if _, err := rdr.reader.Seek(4, 1); err != nil {
+		if err := binary.Read(rdr.reader, rdr.ByteOrder, &lbllen); err != nil {
 			return err
 		}
-		if _, err := rdr.reader.Read(buf[0:vlw]); err != nil {
+		if _, err := io.ReadFull(rdr.reader, buf[0:vlw]); err != nil {
 			return err
 		}
-		labname := string(partition(buf[0:vlw]))
+		labname := rdr.decode(string(rdr.partition(buf[0:vlw])))
 		if _, err := rdr.reader.Seek(3, 1); err != nil {
 			return err
 		}
 
-		if err := binary.Read(rdr.reader, rdr.ByteOrder, &n); err != nil {
-			return err
-		}
-		if err := binary.Read(rdr.reader, rdr.ByteOrder, &textlen); err != nil {
-			return err
-		}
-
-		off := make([]int32, n)
-		val := make([]int32, n)
-
-		for j := int32(0); j < n; j++ {
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &off[j]); err != nil {
-				return err
-			}
-		}
-
-		for j := int32(0); j < n; j++ {
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &val[j]); err != nil {
-				return err
-			}
-		}
-
-		if cap(buf) < int(textlen) {
-			buf = make([]byte, 2*textlen)
-		}
-
-		if _, err := rdr.reader.Read(buf[0:textlen]); err != nil {
+		vk, err := rdr.readValueLabelTable(labname, lbllen, end)
+		if err != nil {
 			return err
 		}
-
-		vk := make(map[int32]string)
-		for j := int32(0); j < n; j++ {
-			vk[val[j]] = string(partition(buf[off[j]:]))
-		}
 		vl[labname] = vk
 
-		// </lbl>
-		if _, err := rdr.reader.Seek(6, 1); err != nil {
+		if _, err := io.ReadFull(rdr.reader, buf[0:6]); err != nil {
 			return err
 		}
+		if string(buf[0:6]) != "</lbl>" {
+			return fmt.Errorf("value label table %s is not closed by </lbl>", labname)
+		}
 	}
 
 	rdr.ValueLabels = vl
@@ -960,256 +2097,1566 @@ func (rdr *StataReader) readValueLabels() error {
 	return nil
 }
 
-func (rdr *StataReader) readStrls() error {
+// readOldValueLabels reads the value labels of files before version
+// 117, which follow the data and extend to the end of the file.  Each
+// label set is stored as its length, its name and 3 bytes of
+// padding, followed by a table with the same layout as in later
+// versions.  A file that ends before the value labels, such as a
+// truncated file, has no value labels.  The position of the reader is
+// not changed, so that the data can be read next.
+func (rdr *StataReader) readOldValueLabels() error {
 
-	if _, err := rdr.reader.Seek(rdr.seekStrls+7, 0); err != nil {
-		return err
-	}
+	vl := make(map[string]map[int32]string)
+	rdr.ValueLabels = vl
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	end, err := rdr.reader.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return err
+	}
+	start := rdr.seekData + int64(rdr.rowCount)*int64(reclen)
+	if start >= end {
+		_, err := rdr.reader.Seek(pos, 0)
+		return err
+	}
+	if _, err := rdr.reader.Seek(start, 0); err != nil {
+		return err
+	}
+
+	// The name of the label set is 9 bytes wide in version 108
+	namew := 33
+	if rdr.FormatVersion <= 108 {
+		namew = 9
+	}
+
+	buf := make([]byte, 40)
+	for {
+		if _, err := io.ReadFull(rdr.reader, buf[0:4]); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		lbllen := rdr.ByteOrder.Uint32(buf[0:4])
+
+		if _, err := io.ReadFull(rdr.reader, buf[0:namew+3]); err != nil {
+			return err
+		}
+		labname := rdr.decode(string(rdr.partition(buf[0:namew])))
+
+		vk, err := rdr.readValueLabelTable(labname, lbllen, end)
+		if err != nil {
+			return err
+		}
+		vl[labname] = vk
+	}
+
+	_, err = rdr.reader.Seek(pos, 0)
+	return err
+}
+
+// readValueLabelTable reads a value label table of length lbllen from
+// the current position, for the label set with the given name.  end
+// is the size of the file, which bounds the length of the table.
+func (rdr *StataReader) readValueLabelTable(labname string, lbllen uint32, end int64) (map[int32]string, error) {
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if int64(lbllen) > end-pos {
+		return nil, fmt.Errorf("value label table %s has length %d, which exceeds the file size", labname, lbllen)
+	}
+
+	// The lengths are stored as 4 byte integers, they are read
+	// as unsigned values and validated before allocating.
+	var n, textlen uint32
+	if err := binary.Read(rdr.reader, rdr.ByteOrder, &n); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(rdr.reader, rdr.ByteOrder, &textlen); err != nil {
+		return nil, err
+	}
+	if 8+8*int64(n)+int64(textlen) != int64(lbllen) {
+		return nil, fmt.Errorf("value label table %s has inconsistent lengths", labname)
+	}
+
+	// The offsets, followed by the values.  Both are 4 byte
+	// integers in all versions, which the length check above
+	// relies on.
+	tab := make([]byte, 8*int(n))
+	if _, err := io.ReadFull(rdr.reader, tab); err != nil {
+		return nil, err
+	}
+
+	off := make([]uint32, n)
+	val := make([]int32, n)
+	for j := range off {
+		off[j] = rdr.ByteOrder.Uint32(tab[4*j:])
+		if off[j] >= textlen {
+			return nil, fmt.Errorf("value label table %s has an offset beyond the end of the text", labname)
+		}
+		val[j] = int32(rdr.ByteOrder.Uint32(tab[4*(int(n)+j):]))
+	}
+
+	text := make([]byte, textlen)
+	if _, err := io.ReadFull(rdr.reader, text); err != nil {
+		return nil, err
+	}
+
+	vk := make(map[int32]string)
+	for j := range val {
+		vk[val[j]] = rdr.decode(string(rdr.partition(text[off[j]:])))
+	}
+
+	return vk, nil
+}
+
+// readStrls scans the strls section (versions 117+) and records the
+// position of each GSO, keyed by the value that refers to it from the
+// data section.  The values themselves are read by loadStrls, or by
+// StrlValue when LazyStrls is set.
+func (rdr *StataReader) readStrls() error {
+
+	pos := rdr.seekStrls + 7
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return err
+	}
+
+	rdr.strlOffsets = make(map[uint64]int64)
+
+	for {
+		ptr, length, ok, err := rdr.readGSOHeader()
+		if err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+		rdr.strlOffsets[ptr] = pos
+
+		if pos, err = rdr.reader.Seek(int64(length), 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readGSOHeader reads the header of the GSO at the current position,
+// and returns its key and the length of its contents.  If there is no
+// GSO at the current position, ok is false.  On return, the t byte has
+// been consumed, and the reader is positioned before the length.
+func (rdr *StataReader) readGSOHeader() (uint64, uint32, bool, error) {
+
+	buf3 := make([]byte, 3)
+	_, err := io.ReadFull(rdr.reader, buf3)
+	if err == io.EOF {
+		return 0, 0, false, nil
+	} else if err != nil {
+		return 0, 0, false, err
+	}
+	if string(buf3) != "GSO" {
+		return 0, 0, false, nil
+	}
+
+	vo := make([]byte, voLength[rdr.FormatVersion])
+	if _, err := io.ReadFull(rdr.reader, vo); err != nil {
+		return 0, 0, false, err
+	}
+
+	// The data section refers to a strl by an 8 byte (v, o)
+	// key.  In version 117 v and o are both 4 bytes wide, in
+	// the data section and here.  In version 118 the data
+	// section uses 2 bytes for v and 6 bytes for o (3 and 5
+	// bytes in version 119), but here v is 4 bytes and o is 8
+	// bytes, so the key is assembled from the low order bytes
+	// of each, which come last in big-endian files.
+	vo8 := make([]byte, 8)
+	if voLength[rdr.FormatVersion] == 12 {
+		vw := strlVLength[rdr.FormatVersion]
+		if rdr.ByteOrder == binary.BigEndian {
+			copy(vo8[0:vw], vo[4-vw:4])
+			copy(vo8[vw:8], vo[4+vw:12])
+		} else {
+			copy(vo8[0:vw], vo[0:vw])
+			copy(vo8[vw:8], vo[4:12-vw])
+		}
+	} else {
+		copy(vo8, vo)
+	}
+
+	// t, then the length of the contents
+	tl := make([]byte, 5)
+	if _, err := io.ReadFull(rdr.reader, tl); err != nil {
+		return 0, 0, false, err
+	}
+
+	return rdr.ByteOrder.Uint64(vo8), rdr.ByteOrder.Uint32(tl[1:5]), true, nil
+}
+
+// readGSO reads the GSO at the given position in the file.  Text
+// (type 130) is returned as a string, and binary data (type 129) as a
+// byte slice.  The position of the reader is not restored.
+func (rdr *StataReader) readGSO(pos int64) (string, []byte, error) {
+
+	// Position of t within the GSO
+	tpos := pos + 3 + int64(voLength[rdr.FormatVersion])
+	if _, err := rdr.reader.Seek(tpos, 0); err != nil {
+		return "", nil, err
+	}
+
+	tl := make([]byte, 5)
+	if _, err := io.ReadFull(rdr.reader, tl); err != nil {
+		return "", nil, err
+	}
+	length := rdr.ByteOrder.Uint32(tl[1:5])
+	if tpos+5+int64(length) > rdr.seekValueLabels {
+		return "", nil, fmt.Errorf("strl at position %d has invalid length %d", pos, length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(rdr.reader, buf); err != nil {
+		return "", nil, err
+	}
+
+	switch tl[0] {
+	case 130:
+		return rdr.decode(string(rdr.partition(buf))), nil, nil
+	case 129:
+		return "", buf, nil
+	default:
+		return "", nil, fmt.Errorf("unknown t value")
+	}
+}
+
+// loadStrls reads all the strl values into Strls and StrlsBytes.  The
+// position of the reader is not changed.
+func (rdr *StataReader) loadStrls() error {
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+
+	rdr.Strls = make(map[uint64]string)
+	rdr.StrlsBytes = make(map[uint64][]byte)
+	rdr.Strls[0] = ""
+
+	// Read the GSOs in file order
+	keys := make([]uint64, 0, len(rdr.strlOffsets))
+	for k := range rdr.strlOffsets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return rdr.strlOffsets[keys[i]] < rdr.strlOffsets[keys[j]] })
+
+	for _, k := range keys {
+		s, b, err := rdr.readGSO(rdr.strlOffsets[k])
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			rdr.StrlsBytes[k] = b
+		} else {
+			rdr.Strls[k] = s
+		}
+	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StrlValue reads the strl with the given key from the strls section
+// of the file.  Text values are returned as a string, and binary
+// values as a byte slice.  The key 0 refers to the empty string.  The
+// position of the reader is not changed.
+func (rdr *StataReader) StrlValue(key uint64) (string, []byte, error) {
+
+	if key == 0 {
+		return "", nil, nil
+	}
+
+	off, ok := rdr.strlOffsets[key]
+	if !ok {
+		return "", nil, fmt.Errorf("strl with key %d not found", key)
+	}
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return "", nil, err
+	}
+
+	s, b, err := rdr.readGSO(off)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return "", nil, err
+	}
+
+	return s, b, nil
+}
+
+// lazyStrl returns the strl with the given key using the resolver of
+// the reader, which caches up to StrlCacheSize values.
+func (rdr *StataReader) lazyStrl(key uint64) (string, []byte, error) {
+
+	if rdr.strlResolver == nil {
+		rdr.strlResolver = NewStrlResolver(rdr, rdr.StrlCacheSize)
+	}
+	rdr.strlResolver.MaxEntries = rdr.StrlCacheSize
+
+	return rdr.strlResolver.Value(key)
+}
+
+// varWidth returns the number of bytes occupied in each record by a
+// variable of the given type.
+func varWidth(t ColumnTypeT) int {
+
+	switch {
+	case t <= 2045:
+		return int(t)
+	case t == StataStrlType:
+		return 8
+	case t == StataFloat64Type:
+		return 8
+	case t == StataFloat32Type:
+		return 4
+	case t == StataInt32Type:
+		return 4
+	case t == StataInt16Type:
+		return 2
+	case t == StataInt8Type:
+		return 1
+	default:
+		panic(fmt.Sprintf("unknown variable type: %v", t))
+	}
+}
+
+// dataStart returns the position of the first observation in the
+// file.
+func (rdr *StataReader) dataStart() int64 {
+	if rdr.FormatVersion >= 117 {
+		// Skip <data>
+		return rdr.seekData + 6
+	}
+	return rdr.seekData
+}
+
+// RecordLength returns the number of bytes occupied by each
+// observation in the data section, which is the sum of the widths of
+// the variables.  A strl variable occupies 8 bytes, the size of a
+// reference to the strls section.  An error is returned if a
+// variable has a type of unknown width.
+func (rdr *StataReader) RecordLength() (int, error) {
+
+	var n int
+	for j, t := range rdr.varTypes {
+		switch {
+		case t <= 2045, t == StataStrlType, t == StataFloat64Type, t == StataFloat32Type,
+			t == StataInt32Type, t == StataInt16Type, t == StataInt8Type:
+			n += varWidth(t)
+		default:
+			return 0, fmt.Errorf("variable %s has unknown type %d", rdr.columnNames[j], t)
+		}
+	}
+
+	return n, nil
+}
+
+// Validate checks that the layout of the file is consistent with the
+// number of variables and observations, so that truncated or corrupt
+// files can be rejected before reading.  For versions 117 and later,
+// the section offsets in the map must be increasing and within the
+// file, and the data section must have the length implied by
+// RecordLength and RowCount.  For earlier versions, the file must be
+// long enough to hold the data.  The position of the reader is not
+// changed.
+func (rdr *StataReader) Validate() error {
+
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return err
+	}
+	if rdr.rowCount < 0 {
+		return fmt.Errorf("invalid number of observations %d", rdr.rowCount)
+	}
+	expected := int64(reclen) * int64(rdr.rowCount)
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+	size, err := rdr.reader.Seek(0, 2)
+	if err != nil {
+		return err
+	}
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return err
+	}
+
+	if rdr.FormatVersion < 117 {
+		if avail := size - rdr.seekData; avail < expected {
+			return fmt.Errorf("data section requires %d bytes for %d observations, but only %d bytes remain in the file",
+				expected, rdr.rowCount, avail)
+		}
+		return nil
+	}
+
+	offsets := []struct {
+		name string
+		pos  int64
+	}{
+		{"variable_types", rdr.seekVartypes},
+		{"varnames", rdr.seekVarnames},
+		{"sortlist", rdr.seekSortlist},
+		{"formats", rdr.seekFormats},
+		{"value_label_names", rdr.seekValueLabelNames},
+		{"variable_labels", rdr.seekVariableLabels},
+		{"characteristics", rdr.seekCharacteristics},
+		{"data", rdr.seekData},
+		{"strls", rdr.seekStrls},
+		{"value_labels", rdr.seekValueLabels},
+	}
+	for k, off := range offsets {
+		if off.pos < 0 || off.pos > size {
+			return fmt.Errorf("%s section offset %d is outside of the file of size %d", off.name, off.pos, size)
+		}
+		if k > 0 && off.pos < offsets[k-1].pos {
+			return fmt.Errorf("%s section offset %d precedes %s section offset %d",
+				off.name, off.pos, offsets[k-1].name, offsets[k-1].pos)
+		}
+	}
+
+	// <data> and </data>
+	if avail := rdr.seekStrls - rdr.seekData - 13; avail != expected {
+		return fmt.Errorf("data section has %d bytes, expected %d bytes for %d observations of length %d",
+			avail, expected, rdr.rowCount, reclen)
+	}
+
+	return nil
+}
+
+// VerifyLayout checks that each section offset in the map of a file of
+// version 117 or later points to the opening tag of its section, e.g.
+// "<variable_types>", so that a corrupt map is reported with the name
+// and offset of the section, rather than giving garbled metadata.
+// Files before version 117 have no map and are not checked.  The
+// position of the reader is not changed.
+func (rdr *StataReader) VerifyLayout() error {
+
+	if rdr.FormatVersion < 117 {
+		return nil
+	}
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return err
+	}
+
+	sections := []struct {
+		name string
+		pos  int64
+	}{
+		{"variable_types", rdr.seekVartypes},
+		{"varnames", rdr.seekVarnames},
+		{"sortlist", rdr.seekSortlist},
+		{"formats", rdr.seekFormats},
+		{"value_label_names", rdr.seekValueLabelNames},
+		{"variable_labels", rdr.seekVariableLabels},
+		{"characteristics", rdr.seekCharacteristics},
+		{"data", rdr.seekData},
+		{"strls", rdr.seekStrls},
+		{"value_labels", rdr.seekValueLabels},
+	}
+
+	var verr error
+	for _, sec := range sections {
+		tag := "<" + sec.name + ">"
+		buf := make([]byte, len(tag))
+		if sec.pos < 0 {
+			verr = fmt.Errorf("%s section offset %d is negative", sec.name, sec.pos)
+			break
+		}
+		if _, err := rdr.reader.Seek(sec.pos, 0); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(rdr.reader, buf); err != nil || string(buf) != tag {
+			verr = fmt.Errorf("%s section offset %d does not point to %s", sec.name, sec.pos, tag)
+			break
+		}
+	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return err
+	}
+
+	return verr
+}
+
+// InferTypes checks that the record length implied by the variable
+// types agrees with the size of the data section, and if not,
+// attempts to correct the widths of the str# variables so that they
+// do.  If there is a single str# variable, its width is adjusted by
+// the difference.  Otherwise the widths of the str# variables are
+// taken from their display formats (e.g. "%9s") if that accounts for
+// the size of the data section.  A description of each correction is
+// returned.  This is only possible for dta versions 117 and later, in
+// which the extent of the data section is known from the map.
+// InferTypes should be called before reading any data.
+func (rdr *StataReader) InferTypes() ([]string, error) {
+
+	if rdr.FormatVersion < 117 {
+		return nil, fmt.Errorf("InferTypes requires dta version 117 or later")
+	}
+	if rdr.rowCount == 0 {
+		return nil, nil
+	}
+
+	// Exclude the <data> and </data> tags
+	size := rdr.seekStrls - rdr.seekData - 13
+	if size%int64(rdr.rowCount) != 0 {
+		return nil, fmt.Errorf("data section size %d is not a multiple of the number of rows %d", size, rdr.rowCount)
+	}
+	stride := int(size / int64(rdr.rowCount))
+
+	var reclen int
+	var strf []int
+	for j, t := range rdr.varTypes {
+		reclen += varWidth(t)
+		if t <= 2045 {
+			strf = append(strf, j)
+		}
+	}
+	if reclen == stride {
+		return nil, nil
+	}
+
+	newWidth := make(map[int]int)
+	switch {
+	case len(strf) == 1:
+		newWidth[strf[0]] = int(rdr.varTypes[strf[0]]) + stride - reclen
+	case len(strf) > 1:
+		n := reclen
+		for _, j := range strf {
+			pf, err := ParseFormat(rdr.Formats[j])
+			if err != nil || pf.Type != "s" {
+				break
+			}
+			newWidth[j] = pf.Width
+			n += pf.Width - int(rdr.varTypes[j])
+		}
+		if len(newWidth) != len(strf) || n != stride {
+			newWidth = nil
+		}
+	}
+
+	var msg []string
+	for _, j := range strf {
+		w, ok := newWidth[j]
+		if !ok {
+			break
+		}
+		if w < 1 || w > 2045 {
+			return nil, fmt.Errorf("unable to infer the width of variable %s", rdr.columnNames[j])
+		}
+		if w != int(rdr.varTypes[j]) {
+			msg = append(msg, fmt.Sprintf("variable %s: type str%d changed to str%d", rdr.columnNames[j], rdr.varTypes[j], w))
+		}
+	}
+	if len(newWidth) == 0 {
+		return nil, fmt.Errorf("record length %d does not match data section stride %d, unable to infer the variable types", reclen, stride)
+	}
+
+	for j, w := range newWidth {
+		rdr.varTypes[j] = ColumnTypeT(w)
+	}
+
+	return msg, nil
+}
+
+// StrlReferencedCount scans the data section and returns the number
+// of distinct non-empty strl values that are referenced by the data,
+// and the number of strl values that are stored in the file.  The
+// position of the reader is not changed.
+func (rdr *StataReader) StrlReferencedCount() (int, int, error) {
+
+	if rdr.FormatVersion < 117 {
+		return 0, 0, nil
+	}
+
+	stored := len(rdr.strlOffsets)
+
+	var offsets []int
+	var reclen int
+	for _, t := range rdr.varTypes {
+		if t == StataStrlType {
+			offsets = append(offsets, reclen)
+		}
+		reclen += varWidth(t)
+	}
+	if len(offsets) == 0 {
+		return 0, stored, nil
+	}
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := rdr.reader.Seek(rdr.dataStart(), 0); err != nil {
+		return 0, 0, err
+	}
+
+	refs := make(map[uint64]bool)
+	buf := make([]byte, reclen)
+	for i := 0; i < rdr.rowCount; i++ {
+		if _, err := io.ReadFull(rdr.reader, buf); err != nil {
+			return 0, 0, err
+		}
+		for _, off := range offsets {
+			ptr := rdr.ByteOrder.Uint64(buf[off : off+8])
+			if ptr != 0 {
+				refs[ptr] = true
+			}
+		}
+	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return 0, 0, err
+	}
+
+	return len(refs), stored, nil
+}
+
+// allocateCols returns arrays to hold nval values of each variable.
+// If selected is not nil, the arrays of the variables that are not
+// selected are nil.
+func (rdr *StataReader) allocateCols(nval int, selected []bool) []interface{} {
+
+	data := make([]interface{}, rdr.Nvar)
+	for j, t := range rdr.varTypes {
+		if selected != nil && !selected[j] {
+			continue
+		}
+		switch {
+		case t <= 2045:
+			data[j] = make([]string, nval)
+		case t == StataStrlType:
+			if rdr.InsertStrls && rdr.BinaryStrls {
+				data[j] = make([][]byte, nval)
+			} else if rdr.InsertStrls {
+				data[j] = make([]string, nval)
+			} else {
+				data[j] = make([]uint64, nval)
+			}
+		case t == StataFloat64Type:
+			data[j] = make([]float64, nval)
+		case rdr.ForceFloat64 && !rdr.isDate[j]:
+			data[j] = make([]float64, nval)
+		case t == StataFloat32Type:
+			data[j] = make([]float32, nval)
+		case t == StataInt32Type:
+			data[j] = make([]int32, nval)
+		case t == StataInt16Type:
+			data[j] = make([]int16, nval)
+		case t == StataInt8Type:
+			data[j] = make([]int8, nval)
+		default:
+			panic(fmt.Sprintf("unknown variable type: %v", t))
+		}
+	}
+
+	return data
+}
+
+// doDetectBooleans converts the byte columns that are flagged in
+// bools to bool columns.
+func (rdr *StataReader) doDetectBooleans(data []interface{}, bools []bool) {
+
+	for j := range data {
+		x, ok := data[j].([]int8)
+		if !ok || !bools[j] {
+			continue
+		}
+		y := make([]bool, len(x))
+		for i, v := range x {
+			y[i] = v == 1
+		}
+		data[j] = y
+	}
+}
+
+// booleanColumns returns true for each byte variable whose
+// non-missing values are all 0 or 1, in all the rows of the file, and
+// whose value labels, if any, are attached to the codes 0 and 1 alone.
+// The data are scanned once, and the result is kept for later calls.
+// The position of the reader is not changed.
+func (rdr *StataReader) booleanColumns() ([]bool, error) {
+
+	if rdr.booleanCols != nil && rdr.booleanColsKeep == rdr.KeepExtremeValues {
+		return rdr.booleanCols, nil
+	}
+
+	bools := make([]bool, rdr.Nvar)
+	offsets := make([]int, rdr.Nvar)
+	var off, ncand int
+	for j, t := range rdr.varTypes {
+		offsets[j] = off
+		off += varWidth(t)
+		if t != StataInt8Type {
+			continue
+		}
+		bools[j] = true
+		if mp, ok := rdr.ValueLabels[rdr.ValueLabelNames[j]]; ok {
+			for k := range mp {
+				if k != 0 && k != 1 {
+					bools[j] = false
+					break
+				}
+			}
+		}
+		if bools[j] {
+			ncand++
+		}
+	}
+	if ncand == 0 {
+		rdr.booleanCols, rdr.booleanColsKeep = bools, rdr.KeepExtremeValues
+		return bools, nil
+	}
+
+	if err := rdr.checkRows(rdr.rowCount); err != nil {
+		return nil, err
+	}
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return nil, err
+	}
+
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rdr.reader.Seek(rdr.dataStart(), 0); err != nil {
+		return nil, err
+	}
+
+	// Decode the candidate variables a block of records at a time
+	chunk := 1000
+	buf := make([]byte, chunk*reclen)
+	x := make([]int8, chunk)
+	missing := make([]bool, chunk)
+	codes := make([]MissingCode, chunk)
+	for i := 0; i < rdr.rowCount; i += chunk {
+		n := chunk
+		if i+n > rdr.rowCount {
+			n = rdr.rowCount - i
+		}
+		if _, err := io.ReadFull(rdr.reader, buf[0:n*reclen]); err != nil {
+			return nil, err
+		}
+		for j := range bools {
+			if !bools[j] {
+				continue
+			}
+			for k := range missing {
+				missing[k] = false
+			}
+			if err := rdr.decodeColumn(buf[offsets[j]:n*reclen], n, reclen, StataInt8Type, x, 0, missing, codes); err != nil {
+				return nil, err
+			}
+			for k, v := range x[0:n] {
+				if !missing[k] && v != 0 && v != 1 {
+					bools[j] = false
+					break
+				}
+			}
+		}
+	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return nil, err
+	}
+
+	rdr.booleanCols, rdr.booleanColsKeep = bools, rdr.KeepExtremeValues
+	return bools, nil
+}
+
+// labeledColumns returns the value labels of each variable, or nil
+// for the variables that have no value label set, or whose label set
+// is empty or absent from the file.
+func (rdr *StataReader) labeledColumns() []map[int32]string {
+
+	labels := make([]map[int32]string, rdr.Nvar)
+	for j, labname := range rdr.ValueLabelNames {
+		if labname == "" {
+			continue
+		}
+		if mp := rdr.ValueLabels[labname]; len(mp) > 0 {
+			labels[j] = mp
+		}
+	}
+
+	return labels
+}
+
+// doInsertCategoryLabels replaces the codes of the variables that have
+// value labels with their labels.  The variables without labels are
+// left unchanged.  Codes outside the range of int32, which can occur
+// in long and double variables, have no label.
+func (rdr *StataReader) doInsertCategoryLabels(data []interface{}, missing [][]bool, nval int) {
+
+	for j, mp := range rdr.labeledColumns() {
+		if mp == nil {
+			continue
+		}
+		if _, ok := data[j].([]bool); ok || data[j] == nil {
+			// Already converted by doDetectBooleans, or not
+			// selected
+			continue
+		}
+
+		idat, err := castToInt(data[j])
+		if err != nil {
+			panic(fmt.Sprintf("non-integer value label indices: %v", err))
+		}
+
+		newdata := make([]string, nval)
+		for i := 0; i < nval; i++ {
+			if !missing[j][i] {
+				x := idat[i]
+				v, ok := mp[int32(x)]
+				if ok && x >= math.MinInt32 && x <= math.MaxInt32 {
+					newdata[i] = v
+				} else {
+					newdata[i] = strconv.FormatInt(idat[i], 10)
+				}
+			}
+		}
+		data[j] = newdata
+	}
+}
+
+// readRows reads nval observations, starting at the current position
+// of the reader.  The records are read in blocks of around 64KB, so
+// that there are few reads from the underlying reader, and are then
+// decoded from memory.  On return the reader is positioned after the
+// last observation that was read.  ctx is checked before each block
+// is read, and its error is returned if it is done.  If progress is
+// true, ProgressFunc is called after each block.
+func (rdr *StataReader) readRows(ctx context.Context, nval int, data []interface{}, missing [][]bool, codes [][]MissingCode, progress bool) error {
+
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return err
+	}
+
+	if rdr.InsertStrls && !rdr.LazyStrls && rdr.Strls == nil && rdr.strlOffsets != nil {
+		if err := rdr.loadStrls(); err != nil {
+			return err
+		}
+	}
+
+	// The position of each variable within a record
+	offsets := make([]int, rdr.Nvar)
+	var off int
+	for j, t := range rdr.varTypes {
+		offsets[j] = off
+		off += varWidth(t)
+	}
+
+	per := 1
+	if reclen > 0 && reclen < 1<<16 {
+		per = (1 << 16) / reclen
+	}
+	block := make([]byte, per*reclen)
+
+	for i := 0; i < nval; {
+		m := nval - i
+		if m > per {
+			m = per
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(rdr.reader, block[0:m*reclen]); err != nil {
+			return err
+		}
+		for j := range data {
+			if data[j] == nil {
+				continue
+			}
+			b := block[offsets[j] : m*reclen]
+			if err := rdr.decodeColumn(b, m, reclen, rdr.varTypes[j], data[j], i, missing[j], codes[j]); err != nil {
+				return err
+			}
+		}
+		i += m
+		if progress && rdr.ProgressFunc != nil {
+			rdr.ProgressFunc(rdr.rowsRead+i, rdr.rowCount)
+		}
+	}
+
+	return nil
+}
+
+// decodeColumn decodes the values of a variable of type t from m
+// records of length reclen, where b starts at the position of the
+// variable in the first record.  The values are stored in dst, which
+// is a slice of the type allocated by allocateCols, starting at
+// position first.  The concrete type of dst is resolved once, and the
+// values are decoded directly from b.  An error is returned if a strl
+// cannot be read from the file when LazyStrls is set.
+func (rdr *StataReader) decodeColumn(b []byte, m, reclen int, t ColumnTypeT, dst interface{}, first int, missing []bool, codes []MissingCode) error {
+
+	bo := rdr.ByteOrder
+
+	// Before version 113 there are no extended missing values, and
+	// the integer types use their largest value for missing.
+	old := rdr.FormatVersion < 113
+
+	// Values in the missing range are kept as data
+	keep := rdr.KeepExtremeValues
+
+	switch x := dst.(type) {
+	case []string:
+		if t <= 2045 {
+			// strf
+			w := int(t)
+			for k := 0; k < m; k++ {
+				p := b[k*reclen : k*reclen+w]
+				x[first+k] = rdr.decode(string(rdr.partition(p)))
+			}
+			return nil
+		}
+		// strl, the pointer is a 2 byte integer followed by a 6
+		// byte integer, or 4 + 4, depending on the version
+		for k := 0; k < m; k++ {
+			ptr := bo.Uint64(b[k*reclen:])
+			if _, ok := rdr.strlOffsets[ptr]; ok && rdr.LazyStrls {
+				s, v, err := rdr.lazyStrl(ptr)
+				if err != nil {
+					return err
+				}
+				if v != nil {
+					s = string(v)
+				}
+				x[first+k] = s
+			} else if v, ok := rdr.StrlsBytes[ptr]; ok {
+				x[first+k] = string(v)
+			} else {
+				x[first+k] = rdr.Strls[ptr]
+			}
+		}
+	case [][]byte:
+		// strl, with binary values kept as bytes
+		for k := 0; k < m; k++ {
+			ptr := bo.Uint64(b[k*reclen:])
+			if _, ok := rdr.strlOffsets[ptr]; ok && rdr.LazyStrls {
+				s, v, err := rdr.lazyStrl(ptr)
+				if err != nil {
+					return err
+				}
+				if v == nil {
+					v = []byte(s)
+				}
+				x[first+k] = v
+			} else if v, ok := rdr.StrlsBytes[ptr]; ok {
+				x[first+k] = v
+			} else {
+				x[first+k] = []byte(rdr.Strls[ptr])
+			}
+		}
+	case []uint64:
+		for k := 0; k < m; k++ {
+			x[first+k] = bo.Uint64(b[k*reclen:])
+		}
+	case []float32:
+		for k := 0; k < m; k++ {
+			v := math.Float32frombits(bo.Uint32(b[k*reclen:]))
+			x[first+k] = v
+			if c := float32Missing(v); c != 0 && !keep {
+				missing[first+k] = true
+				codes[first+k] = c
+			}
+		}
+	case []int32:
+		for k := 0; k < m; k++ {
+			v := int32(bo.Uint32(b[k*reclen:]))
+			x[first+k] = v
+			if c := int32Missing(v, old); c != 0 && !keep {
+				missing[first+k] = true
+				codes[first+k] = c
+			}
+		}
+	case []int16:
+		for k := 0; k < m; k++ {
+			v := int16(bo.Uint16(b[k*reclen:]))
+			x[first+k] = v
+			if c := int16Missing(v, old); c != 0 && !keep {
+				missing[first+k] = true
+				codes[first+k] = c
+			}
+		}
+	case []int8:
+		for k := 0; k < m; k++ {
+			v := int8(b[k*reclen])
+			x[first+k] = v
+			if c := int8Missing(v, old); c != 0 && !keep {
+				missing[first+k] = true
+				codes[first+k] = c
+			}
+		}
+	case []float64:
+		// Doubles, and the other numeric types when ForceFloat64
+		// is set.
+		for k := 0; k < m; k++ {
+			p := b[k*reclen:]
+			var v float64
+			var c MissingCode
+			switch t {
+			case StataFloat64Type:
+				v = math.Float64frombits(bo.Uint64(p))
+				c = float64Missing(v)
+			case StataFloat32Type:
+				u := math.Float32frombits(bo.Uint32(p))
+				v, c = float64(u), float32Missing(u)
+			case StataInt32Type:
+				u := int32(bo.Uint32(p))
+				v, c = float64(u), int32Missing(u, old)
+			case StataInt16Type:
+				u := int16(bo.Uint16(p))
+				v, c = float64(u), int16Missing(u, old)
+			case StataInt8Type:
+				u := int8(p[0])
+				v, c = float64(u), int8Missing(u, old)
+			default:
+				panic(fmt.Sprintf("unknown variable type: %v", t))
+			}
+			if c != 0 && !keep {
+				missing[first+k] = true
+				codes[first+k] = c
+				if rdr.ForceFloat64 {
+					v = math.NaN()
+				}
+			}
+			x[first+k] = v
+		}
+	default:
+		panic(fmt.Sprintf("unknown variable type: %v", t))
+	}
+
+	return nil
+}
+
+// float64Missing returns the kind of missing value that x represents,
+// or zero if x is not missing.  The lower bound in the dta
+// specification is out of range.
+func float64Missing(x float64) MissingCode {
+
+	if !(x > 8.988e307 || x < -8.988e307) {
+		return 0
+	}
+	c := MissingSystem
+	if k := (math.Float64bits(x) - stataMissingFloat64) >> 40; x > 0 && k <= 26 {
+		c += MissingCode(k)
+	}
+
+	return c
+}
+
+// float32Missing returns the kind of missing value that x represents,
+// or zero if x is not missing.
+func float32Missing(x float32) MissingCode {
+
+	if !(x > 1.701e38 || x < -1.701e38) {
+		return 0
+	}
+	c := MissingSystem
+	if k := (math.Float32bits(x) - stataMissingFloat32) >> 11; x > 0 && k <= 26 {
+		c += MissingCode(k)
+	}
+
+	return c
+}
+
+// int32Missing returns the kind of missing value that x represents,
+// or zero if x is not missing.  If old is true, the file predates the
+// extended missing values.
+func int32Missing(x int32, old bool) MissingCode {
+
+	switch {
+	case old && x == math.MaxInt32:
+		return MissingSystem
+	case old:
+		return 0
+	case x > 2147483620:
+		return MissingSystem + MissingCode(x-stataMissingInt32)
+	case x < -2147483647:
+		return MissingSystem
+	default:
+		return 0
+	}
+}
+
+// int16Missing returns the kind of missing value that x represents,
+// or zero if x is not missing.  If old is true, the file predates the
+// extended missing values.
+func int16Missing(x int16, old bool) MissingCode {
+
+	switch {
+	case old && x == math.MaxInt16:
+		return MissingSystem
+	case old:
+		return 0
+	case x > 32740:
+		return MissingSystem + MissingCode(x-stataMissingInt16)
+	case x < -32767:
+		return MissingSystem
+	default:
+		return 0
+	}
+}
+
+// int8Missing returns the kind of missing value that x represents,
+// or zero if x is not missing.  If old is true, the file predates the
+// extended missing values.
+func int8Missing(x int8, old bool) MissingCode {
+
+	switch {
+	case old && x == math.MaxInt8:
+		return MissingSystem
+	case old:
+		return 0
+	case x > 100:
+		return MissingSystem + MissingCode(x-stataMissingInt8)
+	case x < -127:
+		return MissingSystem
+	default:
+		return 0
+	}
+}
+
+// Read returns the given number of rows of data from the Stata data
+// file.  The data are returned as an array of Series objects.  If
+// rows is negative, the remainder of the file is read.  When all rows
+// have been read, Read returns nil.  For a file with no observations,
+// the first call to Read returns Series of length zero.
+func (rdr *StataReader) Read(rows int) ([]*Series, error) {
+	return rdr.read(context.Background(), rows, nil)
+}
+
+// ReadOptions holds the conversions applied by ReadWithOptions, which
+// otherwise are taken from the fields of the same names in
+// StataReader.
+type ReadOptions struct {
+	InsertStrls          bool
+	InsertCategoryLabels bool
+	ConvertDates         bool
+}
+
+// DefaultReadOptions returns the conversions that Read applies, as set
+// in the fields of the reader.
+func (rdr *StataReader) DefaultReadOptions() ReadOptions {
+	return ReadOptions{
+		InsertStrls:          rdr.InsertStrls,
+		InsertCategoryLabels: rdr.InsertCategoryLabels,
+		ConvertDates:         rdr.ConvertDates,
+	}
+}
+
+// ReadWithOptions behaves as Read, but applies the conversions in opts
+// rather than those set in the fields of the reader, which are not
+// changed.  This allows, for example, one block of rows to be read
+// with category labels and the next with the numeric codes.
+// ReadWithOptions shares its position in the file with Read.
+func (rdr *StataReader) ReadWithOptions(rows int, opts ReadOptions) ([]*Series, error) {
+
+	defaults := rdr.DefaultReadOptions()
+	defer func() {
+		rdr.InsertStrls = defaults.InsertStrls
+		rdr.InsertCategoryLabels = defaults.InsertCategoryLabels
+		rdr.ConvertDates = defaults.ConvertDates
+	}()
+
+	rdr.InsertStrls = opts.InsertStrls
+	rdr.InsertCategoryLabels = opts.InsertCategoryLabels
+	rdr.ConvertDates = opts.ConvertDates
+
+	return rdr.read(context.Background(), rows, nil)
+}
+
+// RowsRead returns the number of rows that have been read by Read and
+// the methods that share its position in the file.
+func (rdr *StataReader) RowsRead() int {
+	return rdr.rowsRead
+}
+
+// Rewind returns to the first row of the data, so that the next call
+// to Read starts from the beginning of the file.
+func (rdr *StataReader) Rewind() error {
+
+	if _, err := rdr.reader.Seek(rdr.dataStart(), 0); err != nil {
+		return err
+	}
+	rdr.rowsRead = 0
+	rdr.emptyReturned = false
+
+	return nil
+}
+
+// ReadContext behaves as Read, but stops reading and returns the
+// error of ctx if ctx is done before all the rows have been read.
+// The context is checked every few thousand rows.  When reading is
+// stopped, no data are returned and the position in the file is
+// unchanged.
+func (rdr *StataReader) ReadContext(ctx context.Context, rows int) ([]*Series, error) {
+	return rdr.read(ctx, rows, nil)
+}
+
+// ReadColumns reads the given number of rows of the named variables,
+// and returns the data in the order of names.  The other variables
+// are skipped over rather than decoded.  The names are matched
+// against ColumnNames.  ReadColumns shares its position in the file
+// with Read, and otherwise behaves as Read does.
+func (rdr *StataReader) ReadColumns(names []string, rows int) ([]*Series, error) {
+
+	var ix []int
+	var unknown []string
+	selected := make([]bool, rdr.Nvar)
+	for _, na := range names {
+		j, ok := rdr.ColumnIndex(na)
+		if !ok {
+			unknown = append(unknown, na)
+			continue
+		}
+		ix = append(ix, j)
+		selected[j] = true
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown variables: %s", strings.Join(unknown, ", "))
+	}
+
+	ds, err := rdr.read(context.Background(), rows, selected)
+	if ds == nil || err != nil {
+		return nil, err
+	}
+
+	rslt := make([]*Series, len(ix))
+	for k, j := range ix {
+		rslt[k] = ds[j]
+	}
+
+	return rslt, nil
+}
+
+// read reads the given number of rows, for the variables that are
+// selected, or for all variables if selected is nil.  The Series of
+// the variables that are not selected are nil.
+func (rdr *StataReader) read(ctx context.Context, rows int, selected []bool) ([]*Series, error) {
+
+	// Compute number of values to read
+	nval := int(rdr.rowCount) - rdr.rowsRead
+	if rows >= 0 && rows < nval {
+		nval = rows
+	} else if nval <= 0 {
+		if rdr.rowCount != 0 || rdr.emptyReturned {
+			return nil, nil
+		}
+		// A file with no observations yields empty Series on
+		// the first read, so that the column types are available.
+		rdr.emptyReturned = true
+		nval = 0
+	}
+
+	if err := rdr.checkRows(rdr.rowsRead + nval); err != nil {
+		return nil, err
+	}
+
+	data := rdr.allocateCols(nval, selected)
+	missing := make([][]bool, rdr.Nvar)
+
+	codes := make([][]MissingCode, rdr.Nvar)
+	for j := 0; j < int(rdr.Nvar); j++ {
+		if data[j] == nil {
+			continue
+		}
+		missing[j] = make([]bool, nval)
+		codes[j] = make([]MissingCode, nval)
+	}
+
+	if rdr.FormatVersion >= 117 && rdr.rowsRead == 0 {
+		if _, err := rdr.reader.Seek(rdr.dataStart(), 0); err != nil {
+			return nil, err
+		}
+	}
 
-	vo := make([]byte, voLength[rdr.FormatVersion])
-	vo8 := make([]byte, 8)
-	var t uint8
-	var length uint32
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := rdr.readRows(ctx, nval, data, missing, codes, true); err != nil {
+		if ctx.Err() != nil {
+			// Allow reading to be resumed
+			if _, err := rdr.reader.Seek(pos, 0); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+	rdr.rowsRead += nval
 
-	rdr.Strls = make(map[uint64]string)
-	rdr.StrlsBytes = make(map[uint64][]byte)
+	return rdr.makeSeries(data, missing, codes, nval)
+}
 
-	rdr.Strls[0] = ""
+// ReadRange returns the rows with indices start, start+1, ...,
+// stop-1, as an array of Series objects.  The rows are located by
+// seeking, so the preceding rows are not read.  ReadRange does not
+// affect the position used by Read.
+func (rdr *StataReader) ReadRange(start, stop int) ([]*Series, error) {
 
-	buf := make([]byte, 100)
-	buf3 := make([]byte, 3)
+	if start < 0 || stop < start || stop > rdr.rowCount {
+		return nil, fmt.Errorf("invalid range [%d, %d) for %d rows", start, stop, rdr.rowCount)
+	}
 
-	for {
-		n, err := rdr.reader.Read(buf3)
-		if n <= 0 || err == io.EOF {
-			break
-		} else if err != nil {
-			return err
-		}
-		if string(buf3) != "GSO" {
-			break
-		}
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return nil, err
+	}
 
-		if err := binary.Read(rdr.reader, rdr.ByteOrder, vo); err != nil {
-			return err
-		}
-		if err := binary.Read(rdr.reader, rdr.ByteOrder, &t); err != nil {
-			return err
-		}
-		if err := binary.Read(rdr.reader, rdr.ByteOrder, &length); err != nil {
-			return err
-		}
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rdr.reader.Seek(rdr.dataStart()+int64(start)*int64(reclen), 0); err != nil {
+		return nil, err
+	}
 
-		if voLength[rdr.FormatVersion] == 12 {
-			copy(vo8[0:2], vo[0:2])
-			copy(vo8[2:8], vo[4:10])
-		} else {
-			copy(vo8, vo)
-		}
+	if err := rdr.checkRows(stop); err != nil {
+		return nil, err
+	}
 
-		var ptr uint64
-		if err := binary.Read(bytes.NewReader(vo8), rdr.ByteOrder, &ptr); err != nil {
-			return err
-		}
+	nval := stop - start
+	data := rdr.allocateCols(nval, nil)
+	missing := make([][]bool, rdr.Nvar)
+	codes := make([][]MissingCode, rdr.Nvar)
+	for j := range missing {
+		missing[j] = make([]bool, nval)
+		codes[j] = make([]MissingCode, nval)
+	}
 
-		if len(buf) < int(length) {
-			buf = make([]byte, 2*length)
-		}
-		if _, err := rdr.reader.Read(buf[0:length]); err != nil {
-			return err
-		}
+	if err := rdr.readRows(context.Background(), nval, data, missing, codes, false); err != nil {
+		return nil, err
+	}
 
-		switch t {
-		case 130:
-			buf = partition(buf[0:length])
-			rdr.Strls[ptr] = string(buf)
-		case 129:
-			rdr.StrlsBytes[ptr] = make([]byte, length)
-			copy(rdr.StrlsBytes[ptr], buf[0:length])
-		default:
-			return fmt.Errorf("unknown t value")
-		}
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return rdr.makeSeries(data, missing, codes, nval)
 }
 
-func (rdr *StataReader) allocateCols(nval int) []interface{} {
+// SeekRow positions the reader at observation n, so that the next
+// call to Read (or the methods that share its position) starts with
+// that row.  n may equal RowCount, in which case no rows remain.  The
+// strl values are located by the keys stored in each record, so they
+// are found regardless of the rows that have been skipped.
+func (rdr *StataReader) SeekRow(n int) error {
 
-	data := make([]interface{}, rdr.Nvar)
-	for j, t := range rdr.varTypes {
-		switch {
-		case t <= 2045:
-			data[j] = make([]string, nval)
-		case t == StataStrlType:
-			if rdr.InsertStrls {
-				data[j] = make([]string, nval)
-			} else {
-				data[j] = make([]uint64, nval)
-			}
-		case t == StataFloat64Type:
-			data[j] = make([]float64, nval)
-		case t == StataFloat32Type:
-			data[j] = make([]float32, nval)
-		case t == StataInt32Type:
-			data[j] = make([]int32, nval)
-		case t == StataInt16Type:
-			data[j] = make([]int16, nval)
-		case t == StataInt8Type:
-			data[j] = make([]int8, nval)
-		default:
-			panic(fmt.Sprintf("unknown variable type: %v", t))
-		}
+	if n < 0 || n > rdr.rowCount {
+		return fmt.Errorf("row %d is out of range for %d rows", n, rdr.rowCount)
 	}
 
-	return data
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return err
+	}
+	if _, err := rdr.reader.Seek(rdr.dataStart()+int64(n)*int64(reclen), 0); err != nil {
+		return err
+	}
+	rdr.rowsRead = n
+
+	return nil
 }
 
-func (rdr *StataReader) doInsertCategoryLabels(data []interface{}, missing [][]bool, nval int) {
+// ReadSample returns a random sample of n rows, in the order that
+// they appear in the file.  The rows are chosen without replacement,
+// using a generator seeded with seed, so the same rows are returned
+// for a given seed.  If n is at least RowCount, all the rows are
+// returned.  Each sampled record is located by seeking, so the other
+// rows are not read.  ReadSample does not affect the position used by
+// Read.  Files with strl variables are not supported.
+func (rdr *StataReader) ReadSample(n int, seed int64) ([]*Series, error) {
 
-	for j := 0; j < rdr.Nvar; j++ {
-		labname := rdr.ValueLabelNames[j]
-		mp, ok := rdr.ValueLabels[labname]
-		if !ok {
-			continue
+	if n < 0 {
+		return nil, fmt.Errorf("invalid sample size %d", n)
+	}
+	for j, t := range rdr.varTypes {
+		if t == StataStrlType {
+			return nil, fmt.Errorf("ReadSample does not support strl variables, found %s", rdr.columnNames[j])
 		}
+	}
+	if n > rdr.rowCount {
+		n = rdr.rowCount
+	}
+	if err := rdr.checkRows(n); err != nil {
+		return nil, err
+	}
 
-		idat, err := castToInt(data[j])
-		if err != nil {
-			panic(fmt.Sprintf("non-integer value label indices: %v", err))
-		}
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return nil, err
+	}
 
-		newdata := make([]string, nval)
-		for i := 0; i < nval; i++ {
-			if !missing[j][i] {
-				v, ok := mp[int32(idat[i])]
-				if ok {
-					newdata[i] = v
-				} else {
-					newdata[i] = fmt.Sprintf("%v", idat[i])
-				}
-			}
+	// Choose the rows using Floyd's algorithm, which only needs
+	// space for the sample.
+	rng := rand.New(rand.NewSource(seed))
+	chosen := make(map[int]bool, n)
+	for j := rdr.rowCount - n; j < rdr.rowCount; j++ {
+		k := rng.Intn(j + 1)
+		if chosen[k] {
+			k = j
+		}
+		chosen[k] = true
+	}
+	rows := make([]int, 0, n)
+	for k := range chosen {
+		rows = append(rows, k)
+	}
+	sort.Ints(rows)
+	if n > 0 {
+		if err := rdr.checkRows(rows[n-1] + 1); err != nil {
+			return nil, err
 		}
-		data[j] = newdata
 	}
-}
 
-func (rdr *StataReader) readRow(i int, buf, buf8 []byte, data []interface{}, missing [][]bool) {
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
 
-	for j := 0; j < rdr.Nvar; j++ {
-		switch t := rdr.varTypes[j]; {
-		case t <= 2045:
-			// strf
-			if _, err := rdr.reader.Read(buf[0:t]); err != nil {
-				panic(err)
-			}
-			data[j].([]string)[i] = string(partition(buf[0:t]))
-		case t == StataStrlType:
-			if rdr.InsertStrls {
-				// The STRL pointer is 2 byte integer followed by 6 byte integer
-				// or 4 + 4 depending on the version
-				if err := binary.Read(rdr.reader, rdr.ByteOrder, buf8); err != nil {
-					panic(err)
-				}
-				var ptr uint64
-				if err := binary.Read(bytes.NewReader(buf8), rdr.ByteOrder, &ptr); err != nil {
-					panic(err)
-				}
-				data[j].([]string)[i] = rdr.Strls[ptr]
-			} else {
-				if err := binary.Read(rdr.reader, rdr.ByteOrder, &(data[j].([]uint64)[i])); err != nil {
-					panic(err)
-				}
-			}
-		case t == StataFloat64Type:
-			var x float64
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &x); err != nil {
-				panic(err)
-			}
-			data[j].([]float64)[i] = x
-			// Lower bound in dta spec is out of range.
-			if x > 8.988e307 || x < -8.988e307 {
-				missing[j][i] = true
-			}
-		case t == StataFloat32Type:
-			var x float32
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &x); err != nil {
-				panic(err)
-			}
-			data[j].([]float32)[i] = x
-			if x > 1.701e38 || x < -1.701e38 {
-				missing[j][i] = true
-			}
-		case t == StataInt32Type:
-			var x int32
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &x); err != nil {
-				panic(err)
-			}
-			data[j].([]int32)[i] = x
-			if x > 2147483620 || x < -2147483647 {
-				missing[j][i] = true
-			}
-		case t == StataInt16Type:
-			var x int16
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &x); err != nil {
-				panic(err)
-			}
-			data[j].([]int16)[i] = x
-			if x > 32740 || x < -32767 {
-				missing[j][i] = true
-			}
-		case t == StataInt8Type:
-			var x int8
-			if err := binary.Read(rdr.reader, rdr.ByteOrder, &x); err != nil {
-				panic(err)
-			}
-			if x < -127 || x > 100 {
-				missing[j][i] = true
+	data := rdr.allocateCols(n, nil)
+	missing := make([][]bool, rdr.Nvar)
+	codes := make([][]MissingCode, rdr.Nvar)
+	for j := range missing {
+		missing[j] = make([]bool, n)
+		codes[j] = make([]MissingCode, n)
+	}
+
+	// The position of each variable within a record
+	offsets := make([]int, rdr.Nvar)
+	var off int
+	for j, t := range rdr.varTypes {
+		offsets[j] = off
+		off += varWidth(t)
+	}
+
+	rec := make([]byte, reclen)
+	for i, k := range rows {
+		if _, err := rdr.reader.Seek(rdr.dataStart()+int64(k)*int64(reclen), 0); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(rdr.reader, rec); err != nil {
+			return nil, err
+		}
+		for j := range data {
+			if err := rdr.decodeColumn(rec[offsets[j]:], 1, reclen, rdr.varTypes[j], data[j], i, missing[j], codes[j]); err != nil {
+				return nil, err
 			}
-			data[j].([]int8)[i] = x
-		default:
-			msg := fmt.Sprintf("Unknown variable type")
-			panic(msg)
 		}
 	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return nil, err
+	}
+
+	return rdr.makeSeries(data, missing, codes, n)
 }
 
-// Read returns the given number of rows of data from the Stata data
-// file.  The data are returned as an array of Series objects.  If
-// rows is negative, the remainder of the file is read.
-func (rdr *StataReader) Read(rows int) ([]*Series, error) {
+// RawColumn returns the bytes stored in the file for variable col in
+// the first rows observations (or all the observations, if rows is
+// negative), without decoding them.  The values of consecutive
+// observations are concatenated, so the result has rows times the
+// width of the variable bytes, in the byte order of the file.  Strl
+// variables, which hold references to the strls rather than their
+// values, are not supported.  RawColumn does not affect the position
+// used by Read.
+func (rdr *StataReader) RawColumn(col int, rows int) ([]byte, error) {
 
-	// Compute number of values to read
-	nval := int(rdr.rowCount) - rdr.rowsRead
-	if rows >= 0 && rows < nval {
-		nval = rows
-	} else if nval <= 0 {
-		return nil, nil
+	if col < 0 || col >= rdr.Nvar {
+		return nil, fmt.Errorf("column %d is out of range", col)
+	}
+	if rdr.varTypes[col] == StataStrlType {
+		return nil, fmt.Errorf("RawColumn does not support strl variables, found %s", rdr.columnNames[col])
+	}
+	if rows < 0 || rows > rdr.rowCount {
+		rows = rdr.rowCount
+	}
+	if err := rdr.checkRows(rows); err != nil {
+		return nil, err
 	}
 
-	data := rdr.allocateCols(nval)
-	missing := make([][]bool, rdr.Nvar)
+	reclen, err := rdr.RecordLength()
+	if err != nil {
+		return nil, err
+	}
+	var off int
+	for _, t := range rdr.varTypes[0:col] {
+		off += varWidth(t)
+	}
+	w := varWidth(rdr.varTypes[col])
 
-	for j := 0; j < int(rdr.Nvar); j++ {
-		missing[j] = make([]bool, nval)
+	pos, err := rdr.reader.Seek(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rdr.reader.Seek(rdr.dataStart(), 0); err != nil {
+		return nil, err
 	}
 
-	if rdr.FormatVersion >= 117 && rdr.rowsRead == 0 {
-		if _, err := rdr.reader.Seek(rdr.seekData+6, 0); err != nil {
+	// Read blocks of records and keep the bytes of the variable
+	raw := make([]byte, rows*w)
+	chunk := 1000
+	buf := make([]byte, chunk*reclen)
+	for i := 0; i < rows; i += chunk {
+		n := chunk
+		if i+n > rows {
+			n = rows - i
+		}
+		if _, err := io.ReadFull(rdr.reader, buf[0:n*reclen]); err != nil {
 			return nil, err
 		}
+		for k := 0; k < n; k++ {
+			copy(raw[(i+k)*w:(i+k+1)*w], buf[k*reclen+off:])
+		}
+	}
+
+	if _, err := rdr.reader.Seek(pos, 0); err != nil {
+		return nil, err
 	}
 
-	buf := make([]byte, 2045)
-	buf8 := make([]byte, 8)
-	for i := 0; i < nval; i++ {
+	return raw, nil
+}
 
-		rdr.rowsRead += 1
-		if rdr.rowsRead > int(rdr.rowCount) {
-			break
-		}
+// makeSeries applies the requested conversions to the raw data and
+// returns it as an array of Series objects.
+func (rdr *StataReader) makeSeries(data []interface{}, missing [][]bool, codes [][]MissingCode, nval int) ([]*Series, error) {
 
-		rdr.readRow(i, buf, buf8, data, missing)
+	if rdr.DetectBooleans {
+		bools, err := rdr.booleanColumns()
+		if err != nil {
+			return nil, err
+		}
+		rdr.doDetectBooleans(data, bools)
 	}
 
 	if rdr.InsertCategoryLabels {
@@ -1218,48 +3665,219 @@ func (rdr *StataReader) Read(rows int) ([]*Series, error) {
 
 	if rdr.ConvertDates {
 		for j := range data {
-			if rdr.isDate[j] {
-				data[j] = rdr.doConvertDates(data[j], rdr.Formats[j])
+			if rdr.isDate[j] && data[j] != nil {
+				data[j] = rdr.doConvertDates(data[j], missing[j], rdr.Formats[j])
 			}
 		}
 	}
 
 	// Now that we have the raw data, convert it to a series.
 	rdata := make([]*Series, len(data))
+	names := rdr.ColumnNames()
 	var err error
 	for j, v := range data {
-		rdata[j], err = NewSeries(rdr.columnNames[j], v, missing[j])
+		if v == nil {
+			// Not selected
+			continue
+		}
+		rdata[j], err = NewSeries(names[j], v, missing[j])
 		if err != nil {
 			return nil, err
 		}
+		rdata[j].missingCodes = codes[j]
 	}
 
 	return rdata, nil
 }
 
-func (rdr *StataReader) doConvertDates(v interface{}, format string) interface{} {
+// TypedData holds the data for a set of named variables.
+type TypedData struct {
+
+	// The variable names, in the order that they appear in the file.
+	Names []string
+
+	// The data for each variable, keyed by variable name.  Each
+	// value is a slice of a concrete type, e.g. []float64.
+	Columns map[string]interface{}
+
+	// The missing value indicators for each variable, keyed by
+	// variable name.
+	Missing map[string][]bool
+}
+
+// ReadTyped reads the remaining rows of the file and returns the data
+// keyed by variable name, along with the variable names in their
+// original order.  An error is returned if the variable names are not
+// unique.
+func (rdr *StataReader) ReadTyped() (*TypedData, error) {
+
+	names := rdr.ColumnNames()
+	td := &TypedData{
+		Names:   make([]string, len(names)),
+		Columns: make(map[string]interface{}, len(names)),
+		Missing: make(map[string][]bool, len(names)),
+	}
+	copy(td.Names, names)
+
+	for _, na := range names {
+		if _, ok := td.Columns[na]; ok {
+			return nil, fmt.Errorf("duplicate variable name %s", na)
+		}
+		td.Columns[na] = nil
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		return nil, err
+	}
+	for j, s := range ds {
+		td.Columns[names[j]] = s.Data()
+		td.Missing[names[j]] = s.Missing()
+	}
+
+	return td, nil
+}
+
+// ReadAll reads the remaining rows of the file, as Read(-1) does, and
+// returns the Series keyed by variable name.  The variable names are
+// used even if UseLongNames is set.  An error is returned if the
+// variable names are not unique, unless NormalizeNames is set.
+func (rdr *StataReader) ReadAll() (map[string]*Series, error) {
+
+	names := rdr.variableNames()
+	cols := make(map[string]*Series, rdr.Nvar)
+	for _, na := range names {
+		if _, ok := cols[na]; ok {
+			return nil, fmt.Errorf("duplicate variable name %q, consider setting NormalizeNames", na)
+		}
+		cols[na] = nil
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		return nil, err
+	}
+	if ds == nil {
+		// No rows remain
+		return make(map[string]*Series), nil
+	}
+	for j, s := range ds {
+		cols[names[j]] = s
+	}
+
+	return cols, nil
+}
+
+// fromLeapClock converts a %tC value, the number of milliseconds
+// since 1960 including leap seconds, to a time.Time value.  A time
+// within a leap second, which time.Time cannot represent, is
+// converted to the midnight that follows it.
+func fromLeapClock(x float64) time.Time {
+
+	bt := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var n int
+	for k, ls := range leapSeconds {
+		// The position of the midnight following the leap second
+		// on the %tC scale
+		m := float64(ls.Sub(bt)/time.Millisecond) + 1000*float64(k+1)
+		if x >= m {
+			n = k + 1
+		} else if x >= m-1000 {
+			return ls
+		} else {
+			break
+		}
+	}
+
+	return bt.Add(time.Duration(x-1000*float64(n)) * time.Millisecond)
+}
+
+// leapSecondsBefore returns the number of leap seconds that were
+// inserted before t.
+func leapSecondsBefore(t time.Time) int {
+
+	var n int
+	for _, ls := range leapSeconds {
+		if t.Before(ls) {
+			break
+		}
+		n++
+	}
+
+	return n
+}
+
+// doConvertDates converts Stata dates, which are stored as the number
+// of periods since DateEpoch (or the year, for %ty), to time.Time
+// values.  Missing values are converted to the zero time.
+func (rdr *StataReader) doConvertDates(v interface{}, missing []bool, format string) interface{} {
 
 	vec, err := upcastNumeric(v)
 	if err != nil {
 		panic(fmt.Sprintf("unable to handle type %T in date vector", v))
 	}
 
-	bt := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	bt := rdr.DateEpoch
+	if bt.IsZero() {
+		bt = stataEpoch
+	}
+
+	// Moves a date computed from the Stata epoch to the same
+	// position relative to bt.
+	shift := func(t time.Time) time.Time {
+		if bt.Equal(stataEpoch) {
+			return t
+		}
+		clock := bt.Sub(time.Date(bt.Year(), bt.Month(), bt.Day(), 0, 0, 0, 0, bt.Location()))
+		return t.AddDate(bt.Year()-1960, int(bt.Month())-1, bt.Day()-1).Add(clock)
+	}
 
 	rvec := make([]time.Time, len(vec))
 
-	var tq time.Duration
-	if strings.Index(format, "%td") == 0 {
-		tq = time.Hour * 24
-	} else if strings.Index(format, "%tc") == 0 {
-		tq = time.Millisecond
-	} else {
-		panic("unable to handle format in date vector")
+	// Converts a number of periods since 1960 into a year and the
+	// period within the year.
+	split := func(x float64, per int) (int, int) {
+		n := int(math.Floor(x))
+		y := n / per
+		p := n % per
+		if p < 0 {
+			y--
+			p += per
+		}
+		return 1960 + y, p
 	}
 
-	for j, v := range vec {
-		d := time.Duration(v) * tq
-		rvec[j] = bt.Add(d)
+	for j, x := range vec {
+		if missing[j] {
+			continue
+		}
+		switch {
+		case strings.Index(format, "%tc") == 0:
+			rvec[j] = bt.Add(time.Duration(x) * time.Millisecond)
+		case strings.Index(format, "%tC") == 0:
+			rvec[j] = fromLeapClock(x).Add(bt.Sub(stataEpoch))
+		case strings.Index(format, "%td") == 0:
+			rvec[j] = bt.Add(time.Duration(x) * time.Hour * 24)
+		case strings.Index(format, "%tw") == 0:
+			// There are 52 weeks per year, the last of which
+			// has 8 or 9 days.
+			y, w := split(x, 52)
+			rvec[j] = shift(time.Date(y, 1, 1+7*w, 0, 0, 0, 0, time.UTC))
+		case strings.Index(format, "%tm") == 0:
+			y, m := split(x, 12)
+			rvec[j] = shift(time.Date(y, time.Month(1+m), 1, 0, 0, 0, 0, time.UTC))
+		case strings.Index(format, "%tq") == 0:
+			y, q := split(x, 4)
+			rvec[j] = shift(time.Date(y, time.Month(1+3*q), 1, 0, 0, 0, 0, time.UTC))
+		case strings.Index(format, "%th") == 0:
+			y, h := split(x, 2)
+			rvec[j] = shift(time.Date(y, time.Month(1+6*h), 1, 0, 0, 0, 0, time.UTC))
+		case strings.Index(format, "%ty") == 0:
+			rvec[j] = time.Date(int(x), 1, 1, 0, 0, 0, 0, time.UTC)
+		default:
+			panic("unable to handle format in date vector")
+		}
 	}
 
 	return rvec
@@ -1,12 +1,22 @@
 package datareader
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
 )
 
 func stataBaseTest(fnameCsv, fnameStata string) bool {
@@ -121,3 +131,3447 @@ func TestStata2(t *testing.T) {
 		}
 	}
 }
+
+func TestDetectBooleans(t *testing.T) {
+
+	// Column a has a value of 2 in its last chunk, and column d has
+	// labels for codes other than 0 and 1.
+	n := 2500
+	a := make([]int8, n)
+	b := make([]int8, n)
+	miss := make([]bool, n)
+	for i := range a {
+		a[i] = int8(i % 2)
+		b[i] = int8((i / 3) % 2)
+		miss[i] = i%7 == 0
+	}
+	a[n-10] = 2
+	sa, _ := NewSeries("a", a, nil)
+	sb, _ := NewSeries("b", b, miss)
+	sc, _ := NewSeries("c", b, nil)
+	sd, _ := NewSeries("d", b, nil)
+
+	rdr, cleanup := writeAndRead(t, []*Series{sa, sb, sc, sd}, func(w *StataWriter) {
+		w.ValueLabels = map[string]map[int32]string{
+			"yesno": {0: "No", 1: "Yes"},
+			"other": {0: "No", 1: "Yes", 2: "Maybe"},
+		}
+		w.ValueLabelNames = []string{"", "", "yesno", "other"}
+	})
+	defer cleanup()
+	rdr.DetectBooleans = true
+	rdr.InsertCategoryLabels = false
+
+	// Every chunk has the same types
+	for k := 0; ; k++ {
+		ds, err := rdr.Read(1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ds == nil {
+			break
+		}
+		for j, isBool := range []bool{false, true, true, false} {
+			if _, ok := ds[j].Data().([]bool); ok != isBool {
+				t.Errorf("chunk %d: column %s has type %T", k, ds[j].Name, ds[j].Data())
+			}
+		}
+		if x := ds[1].Data().([]bool); x[3] != (b[1000*k+3] == 1) {
+			t.Errorf("chunk %d: unexpected values", k)
+		}
+	}
+
+	// Each reads the file in chunks as well
+	if err := rdr.SeekRow(0); err != nil {
+		t.Fatal(err)
+	}
+	var rec struct {
+		B bool `stata:"b"`
+	}
+	var m int
+	err := rdr.Each(&rec, func() error {
+		if !miss[m] && rec.B != (b[m] == 1) {
+			return fmt.Errorf("unexpected value in row %d", m)
+		}
+		m++
+		return nil
+	})
+	if err != nil || m != n {
+		t.Errorf("read %d rows: %v", m, err)
+	}
+}
+
+func TestTimings(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "test1_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm := stata.Timings(); tm != nil {
+		t.Errorf("timings were recorded by default")
+	}
+
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stata, err = NewStataReaderWithOptions(r, StataReaderOptions{RecordTimings: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := stata.Timings()
+	for _, k := range []string{"header", "vartypes", "varnames", "formats", "strls", "valuelabels"} {
+		if _, ok := tm[k]; !ok {
+			t.Errorf("no timing recorded for %s", k)
+		}
+	}
+}
+
+// TestStrls checks strl substitution separately for versions 117
+// and 118, which pack the strl keys differently.
+func TestStrls(t *testing.T) {
+
+	for _, tc := range []struct {
+		fname  string
+		col    int
+		values []string
+	}{
+		{"stata12_117.dta", 2, []string{"abcdefghi", "qwertywertyqwerty", "strl"}},
+		{"stata14_118.dta", 2, []string{"Bogotá", "Uzunköprü", "Tromsø", "Elâzığ", ""}},
+	} {
+		r, err := os.Open(filepath.Join("test_files", "data", tc.fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stata.ColumnTypes()[tc.col] != StataStrlType {
+			t.Fatalf("%s: column %d is not a strl", tc.fname, tc.col)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, _, err := ds[tc.col].AsStringSlice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(x) != len(tc.values) {
+			t.Fatalf("%s: read %d values, expected %d", tc.fname, len(x), len(tc.values))
+		}
+		for i := range x {
+			if x[i] != tc.values[i] {
+				t.Errorf("%s: value %d is %q, expected %q", tc.fname, i, x[i], tc.values[i])
+			}
+		}
+		r.Close()
+	}
+
+	// The same data saved as 117 and as 118 must agree.
+	var cols [][]*Series
+	for _, fname := range []string{"test1_117.dta", "test1_118.dta"} {
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, ds)
+	}
+	if f, j, i := SeriesArray(cols[0]).AllEqual(cols[1]); !f {
+		t.Errorf("117 and 118 files differ at column %d, row %d", j, i)
+	}
+}
+
+// TestLazyStrls checks that reading strls on demand gives the same
+// values as reading them all up front.
+func TestLazyStrls(t *testing.T) {
+
+	for _, fname := range []string{"stata12_117.dta", "stata14_118.dta"} {
+		var cols [][]*Series
+		for _, lazy := range []bool{false, true} {
+			r, err := os.Open(filepath.Join("test_files", "data", fname))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			stata, err := NewStataReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stata.LazyStrls = lazy
+			ds, err := stata.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lazy && stata.Strls != nil {
+				t.Errorf("%s: strls were loaded in lazy mode", fname)
+			}
+			cols = append(cols, ds)
+		}
+		if f, j, i := SeriesArray(cols[0]).AllEqual(cols[1]); !f {
+			t.Errorf("%s: lazy strls differ at column %d, row %d", fname, j, i)
+		}
+	}
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.InsertStrls = false
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := ds[2].Data().([]uint64)
+	for i, want := range []string{"abcdefghi", "qwertywertyqwerty", "strl"} {
+		s, b, err := stata.StrlValue(keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != want || b != nil {
+			t.Errorf("strl %d is %q, expected %q", keys[i], s, want)
+		}
+	}
+	if _, _, err := stata.StrlValue(1 << 40); err == nil {
+		t.Errorf("expected an error for an unknown strl key")
+	}
+}
+
+func TestBinaryStrls(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Mark the second strl as binary (type 129)
+	i := bytes.Index(b, []byte("<strls>"))
+	i += bytes.Index(b[i:], []byte("qwerty"))
+	if b[i-5] != 130 {
+		t.Fatalf("unexpected GSO type %d", b[i-5])
+	}
+	b[i-5] = 129
+
+	for _, lazy := range []bool{false, true} {
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata.LazyStrls = lazy
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x := ds[2].Data().([]string)
+		if x[0] != "abcdefghi" || x[1] != "qwertywertyqwerty\x00" || x[2] != "strl" {
+			t.Errorf("lazy=%t: unexpected strls %q", lazy, x)
+		}
+
+		stata, err = NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata.LazyStrls = lazy
+		stata.BinaryStrls = true
+		ds, err = stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y := ds[2].Data().([][]byte)
+		if string(y[0]) != "abcdefghi" || string(y[1]) != "qwertywertyqwerty\x00" || string(y[2]) != "strl" {
+			t.Errorf("lazy=%t: unexpected binary strls %q", lazy, y)
+		}
+	}
+}
+
+func TestStrlReferencedCount(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := stata.Read(1); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, stored, err := stata.StrlReferencedCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs != 3 || stored != 3 {
+		t.Errorf("got %d referenced and %d stored strls, expected 3 and 3", refs, stored)
+	}
+
+	// Reading continues where it left off before the scan.
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _, _ := ds[2].AsStringSlice()
+	if len(x) != 2 || x[0] != "qwertywertyqwerty" || x[1] != "strl" {
+		t.Errorf("unexpected values %v after scan", x)
+	}
+}
+
+// zeroRows returns the contents of the given dta file, modified so
+// that the header reports zero observations.  Before version 117 the
+// value labels directly follow the data, so the data are removed.
+func zeroRows(fname string) ([]byte, error) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+	if err != nil {
+		return nil, err
+	}
+
+	if b[0] == '<' {
+		i := bytes.Index(b, []byte("<N>")) + 3
+		j := bytes.Index(b, []byte("</N>"))
+		for k := i; k < j; k++ {
+			b[k] = 0
+		}
+	} else {
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		reclen, err := stata.RecordLength()
+		if err != nil {
+			return nil, err
+		}
+		start := stata.dataStart()
+		end := start + int64(reclen*stata.RowCount())
+		b = append(b[0:start:start], b[end:]...)
+		for k := 6; k < 10; k++ {
+			b[k] = 0
+		}
+	}
+
+	return b, nil
+}
+
+func TestZeroRows(t *testing.T) {
+
+	for _, fname := range []string{"test1_115.dta", "test1_117.dta", "test1_118.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := zeroRows(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err = NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stata.RowCount() != 0 {
+			t.Fatalf("%s: RowCount is %d", fname, stata.RowCount())
+		}
+
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ds) != stata.Nvar {
+			t.Fatalf("%s: read %d columns, expected %d", fname, len(ds), stata.Nvar)
+		}
+		for j := range ds {
+			if ds[j].Length() != 0 {
+				t.Errorf("%s: column %d has length %d", fname, j, ds[j].Length())
+			}
+			if fmt.Sprintf("%T", ds[j].Data()) != fmt.Sprintf("%T", full[j].Data()) {
+				t.Errorf("%s: column %d has type %T", fname, j, ds[j].Data())
+			}
+		}
+		if stata.rowsRead != 0 {
+			t.Errorf("%s: rowsRead is %d", fname, stata.rowsRead)
+		}
+
+		// Subsequent reads indicate that the data are exhausted.
+		ds, err = stata.Read(-1)
+		if ds != nil || err != nil {
+			t.Errorf("%s: expected nil on second read", fname)
+		}
+	}
+}
+
+func TestForceFloat64(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.ForceFloat64 = true
+	stata.InsertCategoryLabels = false
+
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := ds[0].Data().([]string); !ok {
+		t.Errorf("string column has type %T", ds[0].Data())
+	}
+
+	// Ints, Floats, Bytes, Longs
+	for j := 3; j < 7; j++ {
+		x, miss, err := ds[j].AsFloat64Slice()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !miss[1] || !math.IsNaN(x[1]) {
+			t.Errorf("column %d: missing value is %v", j, x[1])
+		}
+		if x[0] != 1 {
+			t.Errorf("column %d: first value is %v", j, x[0])
+		}
+	}
+}
+
+func TestInferTypes(t *testing.T) {
+
+	fname := filepath.Join("test_files", "data", "stata12_117.dta")
+	b, err := ioutil.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := stata.InferTypes(); err != nil || msg != nil {
+		t.Fatalf("unexpected correction of a valid file: %v %v", msg, err)
+	}
+
+	// Corrupt the type of variable y (str6) to str9.
+	off := stata.seekVartypes + 16 + 2
+	stata.ByteOrder.PutUint16(b[off:off+2], 9)
+
+	stata, err = NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := stata.InferTypes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg) != 1 || stata.ColumnTypes()[1] != 6 {
+		t.Fatalf("unexpected correction: %v", msg)
+	}
+
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _, _ := ds[2].AsStringSlice()
+	if x[2] != "strl" {
+		t.Errorf("unexpected value %q after correction", x[2])
+	}
+}
+
+func TestCheckSections(t *testing.T) {
+
+	// The variable labels offset is zero in the map of this file.
+	r, err := os.Open(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, v := range stata.ColumnNamesLong {
+		if v != "" {
+			t.Errorf("variable label %d is %q", j, v)
+		}
+	}
+
+	// Understate the number of variables in the header.
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "test1_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b, []byte("<K>")) + 3
+	b[i] = 99
+	if _, err := NewStataReader(bytes.NewReader(b)); err == nil {
+		t.Errorf("expected an error for inconsistent Nvar")
+	}
+}
+
+func TestCorruptValueLabels(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The text length follows the <lbl> tag, the table length,
+	// the label name, padding, and the number of entries.
+	i := bytes.Index(b, []byte("<lbl>")) + 5 + 4 + 129 + 3 + 4
+	binary.LittleEndian.PutUint32(b[i:i+4], 0xfffffff0)
+
+	if _, err := NewStataReader(bytes.NewReader(b)); err == nil {
+		t.Errorf("expected an error for a corrupt text length")
+	}
+}
+
+func TestValueLabelsSection(t *testing.T) {
+
+	write := func(setup func(*StataWriter)) []byte {
+		a, _ := NewSeries("a", []int8{1, 2, 3}, nil)
+		f, err := ioutil.TempFile("", "datareader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		wtr, err := NewStataWriter(f, []*Series{a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if setup != nil {
+			setup(wtr)
+		}
+		if err := wtr.Write(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// An empty section gives an empty map
+	b := write(nil)
+	if !bytes.Contains(b, []byte("<value_labels></value_labels>")) {
+		t.Fatalf("expected an empty value labels section")
+	}
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stata.ValueLabels == nil || len(stata.ValueLabels) != 0 {
+		t.Errorf("unexpected value labels %v", stata.ValueLabels)
+	}
+
+	// A label set without any labels
+	b = write(func(w *StataWriter) {
+		w.ValueLabels = map[string]map[int32]string{"empty": {}}
+		w.ValueLabelNames = []string{"empty"}
+	})
+	stata, err = NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if labels, ok := stata.ValueLabels["empty"]; !ok || len(labels) != 0 || len(stata.ValueLabels) != 1 {
+		t.Errorf("unexpected value labels %v", stata.ValueLabels)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, ok := ds[0].Data().([]int8); !ok || x[2] != 3 {
+		t.Errorf("unexpected values %v", ds[0].Data())
+	}
+
+	// Reading stops at the closing tag, a section that is not
+	// closed is an error
+	b, err = ioutil.ReadFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.LastIndex(b, []byte("</value_labels>"))
+	if _, err := NewStataReader(bytes.NewReader(b[0:i])); err == nil {
+		t.Errorf("expected an error for a truncated value labels section")
+	}
+	c := append([]byte{}, b...)
+	copy(c[i:], "<junk>")
+	if _, err := NewStataReader(bytes.NewReader(c)); err == nil {
+		t.Errorf("expected an error for a value labels section that is not closed")
+	}
+	j := bytes.LastIndex(b, []byte("</lbl>"))
+	c = append([]byte{}, b...)
+	copy(c[j:], "</lbX>")
+	if _, err := NewStataReader(bytes.NewReader(c)); err == nil {
+		t.Errorf("expected an error for a label table that is not closed")
+	}
+}
+
+func TestReadTyped(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	td, err := stata.ReadTyped()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(td.Names) != 7 || td.Names[3] != "Ints" {
+		t.Fatalf("unexpected names %v", td.Names)
+	}
+	x, ok := td.Columns["Ints"].([]int16)
+	if !ok {
+		t.Fatalf("Ints has type %T", td.Columns["Ints"])
+	}
+	if x[3] != -4 || !td.Missing["Ints"][1] {
+		t.Errorf("unexpected values in Ints")
+	}
+}
+
+func TestReadAll(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.UseLongNames = true
+
+	if _, err := stata.Read(1); err != nil {
+		t.Fatal(err)
+	}
+	cols, err := stata.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 7 {
+		t.Fatalf("got %d columns", len(cols))
+	}
+	s, ok := cols["Ints"]
+	if !ok {
+		t.Fatalf("no column Ints")
+	}
+	if x := s.Data().([]int16); len(x) != stata.RowCount()-1 || x[2] != -4 || !s.IsMissing(0) {
+		t.Errorf("unexpected values in Ints")
+	}
+
+	// No rows remain
+	cols, err = stata.ReadAll()
+	if err != nil || len(cols) != 0 {
+		t.Errorf("got %d columns after reading all rows", len(cols))
+	}
+}
+
+// TestVersion114 checks that files of version 114, which have the same
+// layout as version 115, can be read.
+func TestVersion114(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata4_115.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b[0] = 114
+	stata, err = NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stata.FormatVersion != 114 || stata.ValueLabelNames[0] == "" {
+		t.Errorf("unexpected metadata for version 114")
+	}
+	ds114, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq, j, i := SeriesArray(ds).AllEqual(ds114); !eq {
+		t.Errorf("column %d differs at row %d", j, i)
+	}
+
+	b[0] = 116
+	_, err = NewStataReader(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "116") {
+		t.Errorf("expected an error for version 116, got %v", err)
+	}
+}
+
+// TestVersion108 reads a small version 108 file, which has narrower
+// data and variable labels than later versions.
+func TestVersion108(t *testing.T) {
+
+	field := func(s string, w int) []byte {
+		b := make([]byte, w)
+		copy(b, s)
+		return b
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{108, 2, 1, 0})
+	binary.Write(&buf, binary.LittleEndian, int16(1))
+	binary.Write(&buf, binary.LittleEndian, int32(3))
+	buf.Write(field("old data", 32))
+	buf.Write(field("01 Jan 1999 10:00", 18))
+	buf.Write([]byte{'b'})
+	buf.Write(field("x", 9))
+	buf.Write(make([]byte, 4))
+	buf.Write(field("%8.0g", 12))
+	buf.Write(field("xl", 9))
+	buf.Write(field("the x", 32))
+	buf.Write(make([]byte, 3))
+	buf.Write([]byte{1, 2, 3})
+
+	// A value label table with a 9 byte name
+	binary.Write(&buf, binary.LittleEndian, int32(20))
+	buf.Write(field("xl", 12))
+	for _, v := range []int32{1, 4, 0, 1} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	buf.Write(field("one", 4))
+
+	stata, err := NewStataReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stata.DatasetLabel != "old data" || stata.TimeStamp != "01 Jan 1999 10:00" || stata.ColumnNamesLong[0] != "the x" {
+		t.Errorf("unexpected metadata %q %q %q", stata.DatasetLabel, stata.TimeStamp, stata.ColumnNamesLong[0])
+	}
+	if stata.ValueLabels["xl"][1] != "one" {
+		t.Errorf("unexpected value labels %v", stata.ValueLabels)
+	}
+	stata.InsertCategoryLabels = false
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]int8); len(x) != 3 || x[0] != 1 || x[2] != 3 {
+		t.Errorf("unexpected values %v", x)
+	}
+}
+
+func TestDateEpoch(t *testing.T) {
+
+	read := func(epoch time.Time) []*Series {
+		r, err := os.Open(filepath.Join("test_files", "data", "stata2_117.dta"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !stata.DateEpoch.Equal(time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("unexpected default epoch %v", stata.DateEpoch)
+		}
+		stata.DateEpoch = epoch
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ds
+	}
+
+	ds0 := read(time.Time{})
+	ds1 := read(time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC))
+	if eq, j, i := SeriesArray(ds0).AllEqual(read(time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC))); !eq {
+		t.Errorf("column %d differs at row %d with the default epoch", j, i)
+	}
+
+	// %tc, %tC, %td, %tw and %tm
+	for j := 0; j < 5; j++ {
+		x0, x1 := ds0[j].Data().([]time.Time), ds1[j].Data().([]time.Time)
+		for i := range x0 {
+			if ds0[j].IsMissing(i) {
+				continue
+			}
+			if j < 3 && x1[i].Sub(x0[i]) != 3653*24*time.Hour {
+				t.Errorf("column %d, row %d: %v with epoch 1970, %v with epoch 1960", j, i, x1[i], x0[i])
+			}
+			if j >= 3 && !x1[i].Equal(x0[i].AddDate(10, 0, 0)) {
+				t.Errorf("column %d, row %d: %v with epoch 1970, %v with epoch 1960", j, i, x1[i], x0[i])
+			}
+		}
+	}
+}
+
+func TestDateEpochCharacteristic(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata2_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(epoch string) (time.Time, []*Series) {
+		c := addCharacteristics(b, [][3]string{{"_dta", "epoch", epoch}})
+		stata, err := NewStataReader(bytes.NewReader(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata.DateEpoch, ds
+	}
+
+	epoch1960 := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	epoch1970 := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, v := range []string{"1970-01-01", "1970-01-01 00:00:00", "01jan1970", " 01JAN1970 "} {
+		if epoch, _ := read(v); !epoch.Equal(epoch1970) {
+			t.Errorf("epoch %q: got %v", v, epoch)
+		}
+	}
+	if epoch, _ := read("not a date"); !epoch.Equal(epoch1960) {
+		t.Errorf("unparsable epoch: got %v", epoch)
+	}
+
+	_, ds0 := read("01jan1960")
+	_, ds1 := read("01jan1970")
+	x0, x1 := ds0[2].Data().([]time.Time), ds1[2].Data().([]time.Time)
+	for i := range x0 {
+		if !ds0[2].IsMissing(i) && x1[i].Sub(x0[i]) != 3653*24*time.Hour {
+			t.Errorf("row %d: %v with epoch 1970, %v with epoch 1960", i, x1[i], x0[i])
+		}
+	}
+}
+
+func TestGoType(t *testing.T) {
+
+	long := strings.Repeat("x", 3000)
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	a, _ := NewSeries("a", []int8{1, 2, 3}, nil)
+	b, _ := NewSeries("b", []string{long, "", "x"}, nil)
+	c, _ := NewSeries("c", []time.Time{day, day, day}, nil)
+	d, _ := NewSeries("d", []float32{1, 2, 3}, nil)
+	e, _ := NewSeries("e", []string{"a", "b", "c"}, nil)
+	f, _ := NewSeries("f", []int16{1, 2, 3}, nil)
+	rdr, cleanup := writeAndRead(t, []*Series{a, b, c, d, e, f}, func(w *StataWriter) {
+		w.ValueLabels = map[string]map[int32]string{"ab": {1: "a", 2: "b"}}
+		w.ValueLabelNames = []string{"ab", "", "", "", "", "ab"}
+	})
+	defer cleanup()
+
+	for _, setup := range []func(){
+		func() {},
+		func() { rdr.InsertCategoryLabels = false },
+		func() { rdr.ConvertDates = false },
+		func() { rdr.InsertStrls = false },
+		func() { rdr.BinaryStrls = true; rdr.InsertStrls = true },
+		func() { rdr.ForceFloat64 = true },
+	} {
+		setup()
+		if err := rdr.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+		ds, err := rdr.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, s := range ds {
+			if gt, et := rdr.GoType(j), reflect.TypeOf(s.Data()).Elem(); gt != et {
+				t.Errorf("column %d: GoType is %v, Read returns %v", j, gt, et)
+			}
+		}
+	}
+
+	if rdr.GoType(-1) != nil || rdr.GoType(rdr.Nvar) != nil {
+		t.Errorf("expected nil for columns that are out of range")
+	}
+}
+
+// TestWideLabelCodes checks that codes outside the range of int32 are
+// not given the label of their truncated value.
+func TestWideLabelCodes(t *testing.T) {
+
+	stata := &StataReader{
+		Nvar:            2,
+		ValueLabelNames: []string{"lab", "lab"},
+		ValueLabels:     map[string]map[int32]string{"lab": {1: "one", -2: "minus two"}},
+	}
+	data := []interface{}{[]float64{1, 1<<32 + 1, -2, 1<<33 - 2}, []int32{1, -2, 3, math.MaxInt32}}
+	missing := [][]bool{make([]bool, 4), make([]bool, 4)}
+	stata.doInsertCategoryLabels(data, missing, 4)
+
+	for j, expected := range [][]string{{"one", "4294967297", "minus two", "8589934590"}, {"one", "minus two", "3", "2147483647"}} {
+		x := data[j].([]string)
+		for i := range x {
+			if x[i] != expected[i] {
+				t.Errorf("column %d: got %v, expected %v", j, x, expected)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalizeNames(t *testing.T) {
+
+	names := normalizeNames([]string{"a", "", "a", "b", "a_2", "", "v2", "a"})
+	expected := []string{"a", "v2_2", "a_3", "b", "a_2", "v6", "v2", "a_4"}
+	for j := range names {
+		if names[j] != expected[j] {
+			t.Errorf("got names %v, expected %v", names, expected)
+			break
+		}
+	}
+
+	// Rename Cities to Things, and empty the name of Ints
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b, []byte("<varnames>")) + 10
+	copy(b[i+129:], "Things")
+	b[i+3*129] = 0
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stata.ReadAll(); err == nil {
+		t.Errorf("expected an error for duplicate names")
+	}
+
+	stata.NormalizeNames = true
+	if n := stata.ColumnNames(); n[0] != "Things" || n[1] != "Things_2" || n[3] != "v4" || n[4] != "Floats" {
+		t.Errorf("unexpected names %v", n)
+	}
+	if n := stata.OriginalColumnNames(); n[1] != "Things" || n[3] != "" {
+		t.Errorf("unexpected original names %v", n)
+	}
+	cols, err := stata.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != stata.Nvar || cols["Things_2"] == nil || cols["v4"] == nil {
+		t.Errorf("unexpected columns")
+	}
+}
+
+// TestStrlChunks checks that reading a file containing strls in
+// small chunks gives the same result as reading it all at once.
+func TestStrlChunks(t *testing.T) {
+
+	for _, fname := range []string{"test1_117.dta", "test1_118.dta", "stata12_117.dta", "stata14_118.dta"} {
+		for _, chunksize := range []int{1, 2, 3} {
+
+			r, err := os.Open(filepath.Join("test_files", "data", fname))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stata, err := NewStataReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			full, err := stata.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := r.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+			stata, err = NewStataReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pos := 0
+			for {
+				chunk, err := stata.Read(chunksize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if chunk == nil {
+					break
+				}
+				n := chunk[0].Length()
+				ix := make([]int, n)
+				for i := range ix {
+					ix[i] = pos + i
+				}
+				for j := range chunk {
+					if f, i := chunk[j].AllEqual(full[j].selectRows(ix)); !f {
+						t.Errorf("%s: chunk at row %d differs in column %d, row %d", fname, pos, j, i)
+					}
+				}
+				pos += n
+			}
+			if pos != stata.RowCount() {
+				t.Errorf("%s: read %d rows in chunks, expected %d", fname, pos, stata.RowCount())
+			}
+			r.Close()
+		}
+	}
+}
+
+// toV119 converts the contents of a little-endian version 118 file
+// to version 119, which differs in the width of the number of
+// variables and of the sortlist entries, and in the layout of the
+// strl keys in the data section.
+func toV119(b []byte) ([]byte, error) {
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if stata.FormatVersion != 118 || stata.ByteOrder != binary.LittleEndian {
+		return nil, fmt.Errorf("not a little-endian version 118 file")
+	}
+
+	i := bytes.Index(b, []byte("<release>")) + 9
+	copy(b[i:i+3], "119")
+
+	// Widen K from 2 to 4 bytes
+	i = bytes.Index(b, []byte("<K>")) + 5
+	c := append([]byte(nil), b[0:i]...)
+	c = append(c, 0, 0)
+	c = append(c, b[i:]...)
+
+	// Widen the sortlist entries from 2 to 4 bytes
+	i = bytes.Index(c, []byte("<sortlist>")) + 10
+	n := stata.Nvar + 1
+	sl := make([]byte, 4*n)
+	for j := 0; j < n; j++ {
+		binary.LittleEndian.PutUint32(sl[4*j:4*j+4], uint32(binary.LittleEndian.Uint16(c[i+2*j:i+2*j+2])))
+	}
+	c = append(c[0:i:i], append(sl, c[i+2*n:]...)...)
+
+	// The sections after the sortlist move by the widening of the
+	// sortlist as well as of K
+	i = bytes.Index(c, []byte("<map>")) + 5
+	for k := 1; k < 14; k++ {
+		shift := uint64(2)
+		if k > 4 {
+			shift += uint64(2 * n)
+		}
+		p := c[i+8*k : i+8*k+8]
+		binary.LittleEndian.PutUint64(p, binary.LittleEndian.Uint64(p)+shift)
+	}
+
+	p := int(stata.seekData) + 2 + 2*n + 6
+	for r := 0; r < stata.RowCount(); r++ {
+		for _, t := range stata.ColumnTypes() {
+			if t == StataStrlType {
+				key := binary.LittleEndian.Uint64(c[p : p+8])
+				v, o := key&0xffff, key>>16
+				binary.LittleEndian.PutUint64(c[p:p+8], v|o<<24)
+			}
+			p += varWidth(t)
+		}
+	}
+
+	return c, nil
+}
+
+func TestVersion119(t *testing.T) {
+
+	for _, fname := range []string{"stata14_118.dta", "test1_118.dta", "test2_118.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := toV119(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata2, err := NewStataReader(bytes.NewReader(c))
+		if err != nil {
+			t.Fatalf("%s: %v", fname, err)
+		}
+		if stata2.FormatVersion != 119 || stata2.Nvar != stata.Nvar {
+			t.Errorf("%s: version %d with %d variables", fname, stata2.FormatVersion, stata2.Nvar)
+		}
+		ds2, err := stata2.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, j, i := SeriesArray(ds).AllEqual(ds2); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+	}
+}
+
+// toOldVersion converts the contents of a little-endian version 115
+// file to the given earlier version.  The variable names must fit in
+// the narrower fields of the earlier version, and the data must not
+// contain missing values.  The value labels are dropped.
+func toOldVersion(b []byte, version int) ([]byte, error) {
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if stata.FormatVersion != 115 || stata.ByteOrder != binary.LittleEndian {
+		return nil, fmt.Errorf("not a little-endian version 115 file")
+	}
+
+	namew, labw := 33, 81
+	if version <= 108 {
+		namew, labw = 9, 32
+	}
+
+	// Copies the fields of width w from b, truncated or padded to
+	// width nw.
+	p := 109
+	c := append([]byte(nil), b[0:10]...)
+	c[0] = byte(version)
+	label := make([]byte, labw)
+	copy(label[0:labw-1], partition(b[10:91]))
+	c = append(c, label...)
+	c = append(c, b[91:109]...)
+	fields := func(w, nw int) {
+		for j := 0; j < stata.Nvar; j++ {
+			f := make([]byte, nw)
+			copy(f[0:nw-1], partition(b[p:p+w]))
+			c = append(c, f...)
+			p += w
+		}
+	}
+
+	for j, t := range stata.ColumnTypes() {
+		v := b[p+j]
+		if version <= 108 {
+			switch t {
+			case StataInt8Type:
+				v = 'b'
+			case StataInt16Type:
+				v = 'i'
+			case StataInt32Type:
+				v = 'l'
+			case StataFloat32Type:
+				v = 'f'
+			case StataFloat64Type:
+				v = 'd'
+			default:
+				v += 0x7f
+			}
+		}
+		c = append(c, v)
+	}
+	p += stata.Nvar
+
+	fields(33, namew)
+	c = append(c, b[p:p+2*(stata.Nvar+1)]...)
+	p += 2 * (stata.Nvar + 1)
+	fields(49, 12)
+	fields(33, namew)
+	fields(81, labw)
+
+	// Only an empty set of expansion fields is supported.
+	if !bytes.Equal(b[p:p+5], make([]byte, 5)) {
+		return nil, fmt.Errorf("expansion fields are not empty")
+	}
+	p += 5
+	if version <= 108 {
+		c = append(c, 0, 0, 0)
+	} else {
+		c = append(c, 0, 0, 0, 0, 0)
+	}
+
+	reclen, err := stata.RecordLength()
+	if err != nil {
+		return nil, err
+	}
+	c = append(c, b[p:p+reclen*stata.RowCount()]...)
+
+	return c, nil
+}
+
+// toBigEndian converts the contents of a little-endian version 115
+// file to big-endian, by reversing the bytes of the numeric header
+// fields, the sort list and the numeric data values.  The expansion
+// fields must be empty.  The value labels are dropped.
+func toBigEndian(b []byte) ([]byte, error) {
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if stata.FormatVersion != 115 || stata.ByteOrder != binary.LittleEndian {
+		return nil, fmt.Errorf("not a little-endian version 115 file")
+	}
+
+	reverse := func(x []byte) {
+		for i, j := 0, len(x)-1; i < j; i, j = i+1, j-1 {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	nvar := stata.Nvar
+	sortlist := 109 + nvar + 33*nvar
+	data := sortlist + 2*(nvar+1) + (49+33+81)*nvar + 5
+	if !bytes.Equal(b[data-5:data], make([]byte, 5)) {
+		return nil, fmt.Errorf("expansion fields are not empty")
+	}
+	reclen, err := stata.RecordLength()
+	if err != nil {
+		return nil, err
+	}
+
+	c := append([]byte(nil), b[0:data+reclen*stata.RowCount()]...)
+	c[1] = 1
+	reverse(c[4:6])
+	reverse(c[6:10])
+	for p := sortlist; p < sortlist+2*(nvar+1); p += 2 {
+		reverse(c[p : p+2])
+	}
+
+	for p := data; p < len(c); {
+		for _, t := range stata.ColumnTypes() {
+			w := varWidth(t)
+			if t > 2045 {
+				reverse(c[p : p+w])
+			}
+			p += w
+		}
+	}
+
+	return c, nil
+}
+
+// TestBigEndian checks that big-endian versions of some little-endian
+// files are read in the same way.
+func TestBigEndian(t *testing.T) {
+
+	for _, fname := range []string{"stata3_115.dta", "stata7_115.dta", "stata8_115.dta", "stata2_115.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := toBigEndian(b)
+		if err != nil {
+			t.Fatalf("%s: %v", fname, err)
+		}
+
+		var ds [][]*Series
+		for _, x := range [][]byte{b, c} {
+			stata, err := NewStataReader(bytes.NewReader(x))
+			if err != nil {
+				t.Fatal(err)
+			}
+			y, err := stata.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ds = append(ds, y)
+		}
+		if eq, j, i := SeriesArray(ds[0]).AllEqual(ds[1]); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+		for j := range ds[0] {
+			c0, c1 := ds[0][j].MissingCodes(), ds[1][j].MissingCodes()
+			for i := range c0 {
+				if c0[i] != c1[i] {
+					t.Errorf("%s: missing code of column %d differs at row %d", fname, j, i)
+				}
+			}
+		}
+	}
+}
+
+func TestOldVersions(t *testing.T) {
+
+	for _, fname := range []string{"stata3_115.dta", "stata7_115.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, version := range []int{108, 110, 111, 112, 113} {
+			c, err := toOldVersion(b, version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stata2, err := NewStataReader(bytes.NewReader(c))
+			if err != nil {
+				t.Fatalf("%s, version %d: %v", fname, version, err)
+			}
+			if stata2.FormatVersion != version || stata2.Nvar != stata.Nvar || stata2.RowCount() != stata.RowCount() {
+				t.Errorf("%s: version %d with %d variables and %d rows", fname, stata2.FormatVersion, stata2.Nvar, stata2.RowCount())
+			}
+			for j, na := range stata2.ColumnNames() {
+				if na != stata.ColumnNames()[j] || stata2.Formats[j] != stata.Formats[j] {
+					t.Errorf("%s, version %d: column %d is %s with format %s", fname, version, j, na, stata2.Formats[j])
+				}
+			}
+			ds2, err := stata2.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if eq, j, i := SeriesArray(ds).AllEqual(ds2); !eq {
+				t.Errorf("%s, version %d: column %d differs at row %d", fname, version, j, i)
+			}
+		}
+	}
+
+	// Before version 113, only the largest value of an integer type
+	// is missing.
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata7_115.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := toOldVersion(b, 112)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		x       int32
+		missing bool
+	}{
+		{2147483621, false},
+		{math.MaxInt32, true},
+	} {
+		binary.LittleEndian.PutUint32(c[len(c)-9:], uint32(tc.x))
+		stata, err := NewStataReader(bytes.NewReader(c))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ds[0].Missing()[0] != tc.missing {
+			t.Errorf("%d: expected missing to be %t", tc.x, tc.missing)
+		}
+	}
+}
+
+func TestReadWithOptions(t *testing.T) {
+
+	for _, fname := range []string{"stata14_118.dta", "stata2_117.dta"} {
+
+		open := func() *StataReader {
+			r, err := os.Open(filepath.Join("test_files", "data", fname))
+			if err != nil {
+				t.Fatal(err)
+			}
+			stata, err := NewStataReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return stata
+		}
+
+		// The expected values, from readers with the fields set
+		raw := open()
+		raw.InsertStrls = false
+		raw.InsertCategoryLabels = false
+		raw.ConvertDates = false
+		x, err := raw.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := open().Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stata := open()
+		defaults := stata.DefaultReadOptions()
+		if defaults != (ReadOptions{true, true, true}) {
+			t.Errorf("%s: unexpected default options %+v", fname, defaults)
+		}
+		n := stata.RowCount() / 2
+		ds1, err := stata.ReadWithOptions(n, ReadOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stata.DefaultReadOptions() != defaults {
+			t.Errorf("%s: the fields of the reader were changed", fname)
+		}
+		ds2, err := stata.ReadWithOptions(-1, defaults)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ix1 := make([]int, n)
+		for i := range ix1 {
+			ix1[i] = i
+		}
+		ix2 := make([]int, stata.RowCount()-n)
+		for i := range ix2 {
+			ix2[i] = n + i
+		}
+		for j := range x {
+			if eq, i := x[j].selectRows(ix1).AllEqual(ds1[j]); !eq {
+				t.Errorf("%s: column %d differs at row %d without conversions", fname, j, i)
+			}
+			if eq, i := y[j].selectRows(ix2).AllEqual(ds2[j]); !eq {
+				t.Errorf("%s: column %d differs at row %d with conversions", fname, j, n+i)
+			}
+		}
+	}
+}
+
+func TestReadContext(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata3_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ds, err := stata.ReadContext(ctx, 10)
+	if err != nil || ds[0].Length() != 10 {
+		t.Fatalf("unexpected result before cancellation: %v", err)
+	}
+
+	cancel()
+	if _, err := stata.ReadContext(ctx, -1); err != context.Canceled {
+		t.Fatalf("got error %v, expected %v", err, context.Canceled)
+	}
+
+	// Reading resumes where it was stopped.
+	ds, err = stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := stata.ReadRange(10, stata.RowCount())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq, j, i := SeriesArray(ds).AllEqual(all); !eq {
+		t.Errorf("column %d differs at row %d", j, i)
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata3_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls [][2]int
+	stata.ProgressFunc = func(rowsRead, totalRows int) {
+		calls = append(calls, [2]int{rowsRead, totalRows})
+	}
+
+	for {
+		ds, err := stata.Read(50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ds == nil {
+			break
+		}
+	}
+
+	n := stata.RowCount()
+	if len(calls) == 0 || len(calls) > n/50+1 {
+		t.Fatalf("ProgressFunc was called %d times", len(calls))
+	}
+	for k, c := range calls {
+		if c[1] != n || c[0] > n || (k > 0 && c[0] <= calls[k-1][0]) {
+			t.Errorf("call %d reported %d of %d rows", k, c[0], c[1])
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != n {
+		t.Errorf("last call reported %d of %d rows", last[0], n)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	for _, fname := range []string{"stata2_115.dta", "test1_115.dta", "stata3_117.dta", "stata12_117.dta", "stata14_118.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stata.Validate(); err != nil {
+			t.Errorf("%s: %v", fname, err)
+		}
+
+		// Claim more observations than are present
+		stata.rowCount++
+		if err := stata.Validate(); err == nil {
+			t.Errorf("%s: expected an error for an extra observation", fname)
+		}
+		stata.rowCount--
+
+		if stata.FormatVersion >= 117 {
+			stata.seekStrls, stata.seekValueLabels = stata.seekValueLabels, stata.seekStrls
+			if err := stata.Validate(); err == nil {
+				t.Errorf("%s: expected an error for sections out of order", fname)
+			}
+		}
+	}
+
+	// A truncated file
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata3_115.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata, err := NewStataReader(bytes.NewReader(b[0 : len(b)/2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stata.Validate(); err == nil {
+		t.Errorf("expected an error for a truncated file")
+	}
+}
+
+func TestVerifyLayout(t *testing.T) {
+
+	open := func(fname string) *StataReader {
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stata
+	}
+
+	for _, fname := range []string{"stata12_117.dta", "stata14_118.dta", "stata3_115.dta"} {
+		if err := open(fname).VerifyLayout(); err != nil {
+			t.Errorf("%s: %v", fname, err)
+		}
+	}
+
+	// The map points to the wrong places
+	stata := open("stata14_118.dta")
+	stata.seekFormats = stata.seekVarnames
+	err := stata.VerifyLayout()
+	if err == nil || !strings.Contains(err.Error(), "formats section offset") {
+		t.Errorf("expected an error for the formats section, got %v", err)
+	}
+
+	stata = open("stata14_118.dta")
+	stata.seekValueLabels += 1 << 20
+	if err := stata.VerifyLayout(); err == nil {
+		t.Errorf("expected an error for an offset beyond the end of the file")
+	}
+
+	// The reader is still positioned at the data
+	if _, err := stata.Read(-1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestColumnType(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata12_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for j, ct := range stata.ColumnTypes() {
+		st, w := stata.ColumnType(j)
+		switch {
+		case ct <= 2045:
+			if st != StataStr || w != int(ct) {
+				t.Errorf("column %d: got %s with width %d for type %d", j, st, w, ct)
+			}
+		case ct == StataStrlType:
+			if st != StataStrL || w != 0 || st.String() != "strL" {
+				t.Errorf("column %d: got %s with width %d for type %d", j, st, w, ct)
+			}
+		default:
+			if st == StataUnknown || st == StataStr || st == StataStrL || w != 0 {
+				t.Errorf("column %d: got %s with width %d for type %d", j, st, w, ct)
+			}
+		}
+	}
+
+	stata.varTypes[0] = 3000
+	if st, _ := stata.ColumnType(0); st != StataUnknown {
+		t.Errorf("got %s for an invalid type", st)
+	}
+
+	for _, j := range []int{-1, stata.Nvar} {
+		if st, w := stata.ColumnType(j); st != StataUnknown || w != 0 {
+			t.Errorf("column %d: got %s with width %d out of range", j, st, w)
+		}
+	}
+}
+
+func TestIsDate(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata2_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.ConvertDates = false
+
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	isDate := stata.IsDate()
+	for j, s := range ds {
+		if _, ok := s.Data().([]time.Time); ok {
+			t.Errorf("%s was converted to dates", s.Name)
+		}
+		if !isDate[j] {
+			t.Errorf("%s with format %s has IsDate %t", s.Name, stata.Formats[j], isDate[j])
+		}
+	}
+
+	// The result is a copy
+	isDate[0] = !isDate[0]
+	if stata.IsDate()[0] == isDate[0] {
+		t.Errorf("IsDate does not return a copy")
+	}
+}
+
+func TestLeapClock(t *testing.T) {
+
+	bt := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(t time.Time) float64 {
+		return float64(t.Sub(bt) / time.Millisecond)
+	}
+
+	// 27 leap seconds were inserted by the end of 2016.
+	t1 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2016, 12, 31, 23, 59, 59, 0, time.UTC)
+	t3 := time.Date(1970, 6, 1, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		x float64
+		t time.Time
+	}{
+		{ms(t1) + 27000, t1},
+		{ms(t2) + 26000, t2},
+		{ms(t2) + 27000, t1}, // 23:59:60
+		{ms(t3), t3},
+		{-1000, bt.Add(-time.Second)},
+	} {
+		if u := fromLeapClock(tc.x); !u.Equal(tc.t) {
+			t.Errorf("%f: got %v, expected %v", tc.x, u, tc.t)
+		}
+	}
+
+	v := []time.Time{t1, t2, t3}
+	x, err := timeToStata(v, "%tC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range v {
+		if u := fromLeapClock(x[i]); !u.Equal(v[i]) {
+			t.Errorf("round trip of %v gives %v", v[i], u)
+		}
+	}
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata2_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := ds[1].Data().([]time.Time)
+	if e := time.Date(2006, 11, 19, 22, 56, 40, 0, time.UTC); !u[0].Equal(e) {
+		t.Errorf("got %v, expected %v", u[0], e)
+	}
+}
+
+func TestOldValueLabels(t *testing.T) {
+
+	for _, fname := range []string{"stata4", "stata11"} {
+		var readers []*StataReader
+		for _, ver := range []string{"_115.dta", "_117.dta"} {
+			r, err := os.Open(filepath.Join("test_files", "data", fname+ver))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			stata, err := NewStataReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			readers = append(readers, stata)
+		}
+
+		old, cur := readers[0], readers[1]
+		if len(old.ValueLabels) == 0 || len(old.ValueLabels) != len(cur.ValueLabels) {
+			t.Errorf("%s: %d label sets, expected %d", fname, len(old.ValueLabels), len(cur.ValueLabels))
+		}
+		for na, labels := range cur.ValueLabels {
+			if len(old.ValueLabels[na]) != len(labels) {
+				t.Errorf("%s: label set %s has %d labels, expected %d", fname, na, len(old.ValueLabels[na]), len(labels))
+			}
+			for k, v := range labels {
+				if old.ValueLabels[na][k] != v {
+					t.Errorf("%s: label %d of %s is %q, expected %q", fname, k, na, old.ValueLabels[na][k], v)
+				}
+			}
+		}
+
+		// The labels are inserted, and the data are read from the
+		// right position
+		x, err := old.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		y, err := cur.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, j, i := SeriesArray(x).AllEqual(y); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+	}
+}
+
+func TestVariableValueLabels(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata4_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for j, na := range stata.ValueLabelNames {
+		labels, ok := stata.VariableValueLabels(j)
+		if !ok || len(labels) == 0 || len(labels) != len(stata.ValueLabels[na]) {
+			t.Errorf("column %d: got %v for label set %s", j, labels, na)
+		}
+	}
+
+	stata.ValueLabelNames[0] = ""
+	if _, ok := stata.VariableValueLabels(0); ok {
+		t.Errorf("expected no labels for a column without a label set")
+	}
+	stata.ValueLabelNames[0] = "undefined"
+	if _, ok := stata.VariableValueLabels(0); ok {
+		t.Errorf("expected no labels for an undefined label set")
+	}
+	if _, ok := stata.VariableValueLabels(stata.Nvar); ok {
+		t.Errorf("expected no labels for a column out of range")
+	}
+}
+
+func TestSeekRow(t *testing.T) {
+
+	n := 500
+	id := make([]int32, n)
+	for i := range id {
+		id[i] = int32(i)
+	}
+	a, _ := NewSeries("id", id, nil)
+	stata, cleanup := writeAndRead(t, []*Series{a}, nil)
+	defer cleanup()
+
+	for _, start := range []int{300, 5, 0} {
+		if err := stata.SeekRow(start); err != nil {
+			t.Fatal(err)
+		}
+		if stata.RowsRead() != start {
+			t.Errorf("RowsRead is %d after seeking to row %d", stata.RowsRead(), start)
+		}
+		for k := 0; k < 2; k++ {
+			ds, err := stata.Read(10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if x := ds[0].Data().([]int32); len(x) != 10 || x[0] != int32(start+10*k) {
+				t.Errorf("unexpected values %v after seeking to row %d", x, start)
+			}
+		}
+	}
+
+	if err := stata.SeekRow(n); err != nil {
+		t.Fatal(err)
+	}
+	if ds, err := stata.Read(10); err != nil || ds != nil {
+		t.Errorf("expected no rows after seeking to the end")
+	}
+
+	for _, k := range []int{-1, n + 1} {
+		if err := stata.SeekRow(k); err == nil {
+			t.Errorf("expected an error for row %d", k)
+		}
+	}
+
+	// Files with strls, which are found from the keys in the
+	// records that are read
+	for _, fname := range []string{"stata12_117.dta", "stata14_118.dta"} {
+		for _, lazy := range []bool{false, true} {
+			r, err := os.Open(filepath.Join("test_files", "data", fname))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			stata, err = NewStataReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stata.LazyStrls = lazy
+			all, err := stata.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var ix []int
+			for i := 2; i < stata.RowCount(); i++ {
+				ix = append(ix, i)
+			}
+			if err := stata.SeekRow(2); err != nil {
+				t.Fatal(err)
+			}
+			ds, err := stata.Read(-1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j := range all {
+				if eq, i := all[j].selectRows(ix).AllEqual(ds[j]); !eq {
+					t.Errorf("%s (lazy=%v): column %d differs at row %d after seeking", fname, lazy, j, i+2)
+				}
+			}
+		}
+	}
+}
+
+func TestReadRange(t *testing.T) {
+
+	for _, fname := range []string{"test1_115.dta", "test1_117.dta", "stata12_117.dta", "stata14_118.dta", "stata2_115.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The range reads should not affect the sequential reads.
+		first, err := stata.Read(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := stata.RowCount()
+		ranges := [][2]int{{0, n}, {1, 3}, {n - 1, n}, {2, 2}}
+		var parts [][]*Series
+		for _, rg := range ranges {
+			ds, err := stata.ReadRange(rg[0], rg[1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			parts = append(parts, ds)
+		}
+
+		rest, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r2, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r2.Close()
+		stata2, err := NewStataReader(r2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := stata2.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ranges = append(ranges, [2]int{0, 1}, [2]int{1, n})
+		parts = append(parts, first, rest)
+
+		for k, rg := range ranges {
+			var ix []int
+			for i := rg[0]; i < rg[1]; i++ {
+				ix = append(ix, i)
+			}
+			for j := range full {
+				if eq, i := full[j].selectRows(ix).AllEqual(parts[k][j]); !eq {
+					t.Errorf("%s: rows [%d, %d) of column %d differ at %d", fname, rg[0], rg[1], j, i)
+				}
+			}
+		}
+
+		if _, err := stata.ReadRange(3, n+1); err == nil {
+			t.Errorf("expected an error for an invalid range")
+		}
+	}
+}
+
+func TestUnknownVartype(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata1_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := bytes.Index(b, []byte("<variable_types>")) + 16
+	b[i+2] = 0xb8
+	b[i+3] = 0x0b
+	_, err = NewStataReader(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "variable 1 has unknown type code 3000") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	// The types follow the 109 byte header in older versions
+	b, err = ioutil.ReadFile(filepath.Join("test_files", "data", "test1_115.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[109] = 250
+	_, err = NewStataReader(bytes.NewReader(b))
+	if err == nil || !strings.Contains(err.Error(), "variable 0 has unknown type code 250") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestUnlabeledColumns(t *testing.T) {
+
+	a, _ := NewSeries("a", []int8{1, 2, 3}, nil)
+	b, _ := NewSeries("b", []int8{1, 2, 3}, nil)
+	c, _ := NewSeries("c", []int8{1, 2, 3}, nil)
+	d, _ := NewSeries("d", []int8{1, 2, 3}, nil)
+	e, _ := NewSeries("e", []int8{1, 2, 3}, nil)
+	rdr, cleanup := writeAndRead(t, []*Series{a, b, c, d, e}, func(w *StataWriter) {
+		w.ValueLabels = map[string]map[int32]string{"ab": {1: "a", 2: "b"}, "empty": {}}
+		w.ValueLabelNames = []string{"ab", "", "missing", "empty", "ab"}
+	})
+	defer cleanup()
+
+	if cols := rdr.LabeledColumns(); len(cols) != 2 || cols[0] != 0 || cols[1] != 4 {
+		t.Errorf("unexpected labeled columns %v", cols)
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x, ok := ds[0].Data().([]string); !ok || x[0] != "a" || x[2] != "3" {
+		t.Errorf("unexpected labeled values %v", ds[0].Data())
+	}
+	for _, j := range []int{1, 2, 3} {
+		if _, ok := ds[j].Data().([]int8); !ok {
+			t.Errorf("column %d has type %T, expected []int8", j, ds[j].Data())
+		}
+	}
+}
+
+func TestTimeStampTime(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata1_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := stata.TimeStampTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Format("02 Jan 2006 15:04") != strings.Join(strings.Fields(stata.TimeStamp), " ") {
+		t.Errorf("time stamp %q parsed as %v", stata.TimeStamp, ts)
+	}
+
+	for _, c := range []struct {
+		stamp string
+		want  time.Time
+	}{
+		{"17 Jan 2020 14:30", time.Date(2020, 1, 17, 14, 30, 0, 0, time.UTC)},
+		{" 5 FEB 1999 09:05", time.Date(1999, 2, 5, 9, 5, 0, 0, time.UTC)},
+		{"", time.Time{}},
+	} {
+		stata.TimeStamp = c.stamp
+		ts, err := stata.TimeStampTime()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ts.Equal(c.want) {
+			t.Errorf("time stamp %q parsed as %v", c.stamp, ts)
+		}
+	}
+
+	stata.TimeStamp = "yesterday"
+	if _, err := stata.TimeStampTime(); err == nil {
+		t.Errorf("expected an error for an invalid time stamp")
+	}
+}
+
+func TestTrimPartialRune(t *testing.T) {
+
+	for _, c := range [][2]string{
+		{"abc", "abc"},
+		{"abé", "abé"},
+		{"ab\xc3", "ab"},
+		{"a€", "a€"},
+		{"a\xe2\x82", "a"},
+		{"\xf0\x9f\x98", ""},
+		{"", ""},
+	} {
+		if x := string(trimPartialRune([]byte(c[0]))); x != c[1] {
+			t.Errorf("trimPartialRune(%q) = %q, expected %q", c[0], x, c[1])
+		}
+	}
+}
+
+func TestMultibyteNames(t *testing.T) {
+
+	a, _ := NewSeries("nameé", []string{"abé", "x"}, nil)
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	wtr, err := NewStataWriter(f, []*Series{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wtr.Write(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The name and the value are read intact with their padding
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds[0].Name != "nameé" || ds[0].Data().([]string)[0] != "abé" {
+		t.Errorf("unexpected name %q or value %q", ds[0].Name, ds[0].Data().([]string)[0])
+	}
+
+	// Fill the fields so that they end within a multibyte sequence
+	i := bytes.Index(b, []byte("<varnames>")) + 10
+	copy(b[i:i+129], bytes.Repeat([]byte("x"), 128))
+	b[i+128] = 0xc3
+	i = bytes.Index(b, []byte("ab\xc3\xa9"))
+	copy(b[i:i+4], "abc\xc3")
+
+	stata, err = NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err = stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds[0].Name != strings.Repeat("x", 128) || ds[0].Data().([]string)[0] != "abc" {
+		t.Errorf("unexpected name %q or value %q", ds[0].Name, ds[0].Data().([]string)[0])
+	}
+}
+
+func TestRewind(t *testing.T) {
+
+	for _, fname := range []string{"stata2_115.dta", "stata12_117.dta", "stata14_118.dta"} {
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := stata.Read(2); err != nil {
+			t.Fatal(err)
+		}
+		if stata.RowsRead() != 2 {
+			t.Errorf("%s: %d rows read, expected 2", fname, stata.RowsRead())
+		}
+		first, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stata.RowsRead() != stata.RowCount() {
+			t.Errorf("%s: %d rows read, expected %d", fname, stata.RowsRead(), stata.RowCount())
+		}
+
+		if err := stata.Rewind(); err != nil {
+			t.Fatal(err)
+		}
+		if stata.RowsRead() != 0 {
+			t.Errorf("%s: %d rows read after Rewind", fname, stata.RowsRead())
+		}
+		if _, err := stata.Read(2); err != nil {
+			t.Fatal(err)
+		}
+		second, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, j, i := SeriesArray(first).AllEqual(second); !eq {
+			t.Errorf("%s: column %d differs at row %d after Rewind", fname, j, i)
+		}
+	}
+}
+
+func TestUnconvertedDates(t *testing.T) {
+
+	a, _ := NewSeries("a", []int32{100, 200}, nil)
+	b, _ := NewSeries("b", []int32{100, 200}, nil)
+	c, _ := NewSeries("c", []int32{100, 200}, nil)
+	rdr, cleanup := writeAndRead(t, []*Series{a, b, c}, func(w *StataWriter) {
+		w.Formats = []string{"%tbmycal", "%tg", "%td"}
+	})
+	defer cleanup()
+
+	if names := rdr.UnconvertedDates(); len(names) != 2 || names[0] != "a" || names[1] != "b" {
+		t.Errorf("unexpected unconverted dates %v", names)
+	}
+	if d := rdr.IsDate(); d[0] || d[1] || !d[2] {
+		t.Errorf("unexpected date columns %v", d)
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j := 0; j < 2; j++ {
+		if x, ok := ds[j].Data().([]int32); !ok || x[1] != 200 {
+			t.Errorf("column %d has values %v", j, ds[j].Data())
+		}
+	}
+	if _, ok := ds[2].Data().([]time.Time); !ok {
+		t.Errorf("column 2 was not converted to dates")
+	}
+}
+
+func TestReadSample(t *testing.T) {
+
+	n := 500
+	id := make([]int32, n)
+	sq := make([]float64, n)
+	for i := range id {
+		id[i] = int32(i)
+		sq[i] = float64(i * i)
+	}
+	a, _ := NewSeries("id", id, nil)
+	b, _ := NewSeries("sq", sq, nil)
+	stata, cleanup := writeAndRead(t, []*Series{a, b}, nil)
+	defer cleanup()
+
+	// The samples should not affect the sequential reads
+	if _, err := stata.Read(10); err != nil {
+		t.Fatal(err)
+	}
+
+	ds, err := stata.ReadSample(20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := ds[0].Data().([]int32)
+	y := ds[1].Data().([]float64)
+	if len(x) != 20 {
+		t.Fatalf("got %d rows, expected 20", len(x))
+	}
+	for i := range x {
+		if i > 0 && x[i] <= x[i-1] {
+			t.Errorf("rows are not distinct and in order: %v", x)
+		}
+		if y[i] != float64(x[i])*float64(x[i]) {
+			t.Errorf("row %d has values %d, %f", i, x[i], y[i])
+		}
+	}
+
+	// The same seed gives the same sample
+	ds2, err := stata.ReadSample(20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eq, i := ds[0].AllEqual(ds2[0]); !eq {
+		t.Errorf("samples differ at %d", i)
+	}
+
+	ds, err = stata.ReadSample(2*n, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ds[0].Length() != n {
+		t.Errorf("got %d rows, expected %d", ds[0].Length(), n)
+	}
+
+	rest, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := rest[0].Data().([]int32); len(x) != n-10 || x[0] != 10 {
+		t.Errorf("sequential reads were affected by ReadSample")
+	}
+
+	// Strls are not supported
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stata.ReadSample(2, 1); err == nil {
+		t.Errorf("expected an error for a file with strls")
+	}
+}
+
+func TestRawColumn(t *testing.T) {
+
+	n := 2500
+	id := make([]int32, n)
+	str := make([]string, n)
+	for i := range id {
+		id[i] = int32(i)
+		str[i] = fmt.Sprintf("%d", i%100)
+	}
+	a, _ := NewSeries("id", id, nil)
+	b, _ := NewSeries("str", str, nil)
+	stata, cleanup := writeAndRead(t, []*Series{a, b}, nil)
+	defer cleanup()
+
+	if _, err := stata.Read(10); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := stata.RawColumn(0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 4*n {
+		t.Fatalf("got %d bytes, expected %d", len(raw), 4*n)
+	}
+	for i := range id {
+		if x := int32(stata.ByteOrder.Uint32(raw[4*i:])); x != id[i] {
+			t.Errorf("row %d: got %d, expected %d", i, x, id[i])
+		}
+	}
+
+	raw, err = stata.RawColumn(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) != "0\x00"+"1\x00"+"2\x00" {
+		t.Errorf("unexpected bytes %q", raw)
+	}
+
+	// The position used by Read is not affected
+	ds, err := stata.Read(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]int32); x[0] != 10 {
+		t.Errorf("unexpected value %d after RawColumn", x[0])
+	}
+
+	if _, err := stata.RawColumn(2, -1); err == nil {
+		t.Errorf("expected an error for a column out of range")
+	}
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stata.RawColumn(2, -1); err == nil {
+		t.Errorf("expected an error for a strl column")
+	}
+}
+
+func TestRecordLength(t *testing.T) {
+
+	for _, fname := range []string{"test1_117.dta", "test1_118.dta", "stata2_117.dta", "stata12_117.dta", "stata14_118.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := stata.RecordLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Exclude the <data> and </data> tags
+		size := int(stata.seekStrls - stata.seekData - 13)
+		if n*stata.RowCount() != size {
+			t.Errorf("%s: record length %d with %d rows, data section has %d bytes", fname, n, stata.RowCount(), size)
+		}
+	}
+
+	r, err := os.Open(filepath.Join("test_files", "data", "test1_115.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.varTypes[0] = 3000
+	if _, err := stata.RecordLength(); err == nil {
+		t.Errorf("expected an error for an unknown variable type")
+	}
+}
+
+func TestConvertDates(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata9_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each variable holds 2000-01-01 in the second row
+	y2k := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, s := range ds {
+		x, ok := s.Data().([]time.Time)
+		if !ok {
+			t.Errorf("%s has type %T", s.Name, s.Data())
+			continue
+		}
+		if !x[1].Equal(y2k) {
+			t.Errorf("%s: got %v, expected %v", s.Name, x[1], y2k)
+		}
+	}
+
+	date := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		format string
+		value  float64
+		date   time.Time
+	}{
+		{"%tm", 12, date(1961, 1, 1)},
+		{"%tm", -1, date(1959, 12, 1)},
+		{"%tq", -1, date(1959, 10, 1)},
+		{"%tq", 5, date(1961, 4, 1)},
+		{"%th", 3, date(1961, 7, 1)},
+		{"%tw", 1, date(1960, 1, 8)},
+		{"%tw", -1, date(1959, 12, 24)},
+		{"%ty", 1999, date(1999, 1, 1)},
+		{"%tdCCYY", 366, date(1961, 1, 1)},
+	} {
+		x := stata.doConvertDates([]float64{c.value}, []bool{false}, c.format).([]time.Time)
+		if !x[0].Equal(c.date) {
+			t.Errorf("%s %v: got %v, expected %v", c.format, c.value, x[0], c.date)
+		}
+	}
+}
+
+func TestMissingCodes(t *testing.T) {
+
+	for _, fname := range []string{"stata8_115.dta", "stata8_117.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Each variable holds ., .a, ..., .z
+		for _, s := range ds {
+			codes := s.MissingCodes()
+			if len(codes) != 27 {
+				t.Fatalf("%s: %d missing codes for %s", fname, len(codes), s.Name)
+			}
+			for i, c := range codes {
+				if c != MissingSystem+MissingCode(i) {
+					t.Errorf("%s: %s has code %s in row %d", fname, s.Name, c, i)
+				}
+			}
+		}
+
+		// The codes follow the selected rows
+		ix := []int{26, 1}
+		if c := ds[3].selectRows(ix).MissingCodes(); c[0].String() != ".z" || c[1].String() != ".a" {
+			t.Errorf("%s: unexpected codes %v after selecting rows", fname, c)
+		}
+
+		// The codes are preserved by the writer
+		rdr2, cleanup := writeAndRead(t, ds, nil)
+		ds2, err := rdr2.Read(-1)
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j, s := range ds2 {
+			for i, c := range s.MissingCodes() {
+				if c != ds[j].MissingCodes()[i] {
+					t.Errorf("%s: code %s in row %d of %s after writing", fname, c, i, s.Name)
+				}
+			}
+		}
+	}
+}
+
+func TestKeepExtremeValues(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata8_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.KeepExtremeValues = true
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The values are the raw values, none of which are missing
+	for j, s := range ds {
+		raw, err := stata.RawColumn(j, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := len(raw) / s.Length()
+		for i := 0; i < s.Length(); i++ {
+			if s.IsMissing(i) {
+				t.Errorf("%s: row %d is missing", s.Name, i)
+			}
+			p := raw[i*w:]
+			switch x := s.Data().(type) {
+			case []float64:
+				if v := math.Float64frombits(stata.ByteOrder.Uint64(p)); x[i] != v {
+					t.Errorf("%s: row %d is %v, expected %v", s.Name, i, x[i], v)
+				}
+			case []float32:
+				if v := math.Float32frombits(stata.ByteOrder.Uint32(p)); x[i] != v {
+					t.Errorf("%s: row %d is %v, expected %v", s.Name, i, x[i], v)
+				}
+			case []int32:
+				if x[i] != int32(stata.ByteOrder.Uint32(p)) {
+					t.Errorf("%s: unexpected value %d in row %d", s.Name, x[i], i)
+				}
+			case []int16:
+				if x[i] != int16(stata.ByteOrder.Uint16(p)) {
+					t.Errorf("%s: unexpected value %d in row %d", s.Name, x[i], i)
+				}
+			case []int8:
+				if x[i] != int8(p[0]) {
+					t.Errorf("%s: unexpected value %d in row %d", s.Name, x[i], i)
+				}
+			}
+		}
+	}
+
+	// ForceFloat64 does not replace the values with NaN
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.KeepExtremeValues = true
+	stata.ForceFloat64 = true
+	ds, err = stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range ds {
+		for i, v := range s.Data().([]float64) {
+			if math.IsNaN(v) || s.IsMissing(i) {
+				t.Errorf("%s: row %d is missing", s.Name, i)
+			}
+		}
+	}
+}
+
+// addCharacteristics inserts the given characteristics (variable
+// name, characteristic name, contents) into the characteristics
+// section of a little-endian file in version 117 or later, and
+// updates the map.  For earlier versions, the characteristics are
+// inserted as expansion fields, following a field of another type.
+func addCharacteristics(b []byte, chars [][3]string) []byte {
+
+	if b[0] != '<' {
+		return addExpansionFields(b, chars)
+	}
+
+	namew := 129
+	if bytes.Contains(b[0:40], []byte("<release>117")) {
+		namew = 33
+	}
+
+	var ch bytes.Buffer
+	for _, c := range chars {
+		ch.WriteString("<ch>")
+		binary.Write(&ch, binary.LittleEndian, uint32(2*namew+len(c[2])+1))
+		for _, na := range c[0:2] {
+			field := make([]byte, namew)
+			copy(field, na)
+			ch.Write(field)
+		}
+		ch.WriteString(c[2])
+		ch.WriteByte(0)
+		ch.WriteString("</ch>")
+	}
+
+	i := bytes.Index(b, []byte("<characteristics>")) + 17
+	c := append([]byte(nil), b[0:i]...)
+	c = append(c, ch.Bytes()...)
+	c = append(c, b[i:]...)
+
+	m := bytes.Index(c, []byte("<map>")) + 5
+	for k := 0; k < 14; k++ {
+		p := c[m+8*k : m+8*k+8]
+		if x := binary.LittleEndian.Uint64(p); x >= uint64(i) {
+			binary.LittleEndian.PutUint64(p, x+uint64(ch.Len()))
+		}
+	}
+
+	return c
+}
+
+// addExpansionFields inserts the given characteristics as expansion
+// fields of a little-endian file before version 117.
+func addExpansionFields(b []byte, chars [][3]string) []byte {
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		panic(err)
+	}
+
+	var ef bytes.Buffer
+	ef.WriteByte(2)
+	binary.Write(&ef, binary.LittleEndian, int32(3))
+	ef.WriteString("abc")
+	for _, c := range chars {
+		ef.WriteByte(1)
+		binary.Write(&ef, binary.LittleEndian, int32(66+len(c[2])+1))
+		for _, na := range c[0:2] {
+			field := make([]byte, 33)
+			copy(field, na)
+			ef.Write(field)
+		}
+		ef.WriteString(c[2])
+		ef.WriteByte(0)
+	}
+
+	// Before the terminating field, which precedes the data
+	i := int(stata.seekData) - 5
+	c := append([]byte(nil), b[0:i]...)
+	c = append(c, ef.Bytes()...)
+	c = append(c, b[i:]...)
+
+	return c
+}
+
+func TestCharacteristics(t *testing.T) {
+
+	chars := [][3]string{
+		{"_dta", "note0", "1"},
+		{"_dta", "note1", "Collected in 2019"},
+		{"var1", "destring", "Characters removed were: $"},
+	}
+
+	for _, fname := range []string{"test1_117.dta", "stata14_118.dta", "test1_115.dta", "stata4_115.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stata, err := NewStataReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stata.Characteristics == nil || len(stata.Characteristics) != 0 {
+			t.Errorf("%s: unexpected characteristics %v", fname, stata.Characteristics)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		stata2, err := NewStataReader(bytes.NewReader(addCharacteristics(b, chars)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range chars {
+			if v := stata2.Characteristics[c[0]][c[1]]; v != c[2] {
+				t.Errorf("%s: characteristic %s[%s] is %q", fname, c[0], c[1], v)
+			}
+		}
+		ds2, err := stata2.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if eq, j, i := SeriesArray(ds).AllEqual(ds2); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+	}
+}
+
+func TestNotes(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stored out of order, with a gap and a spurious note0
+	b = addCharacteristics(b, [][3]string{
+		{"_dta", "note0", "3"},
+		{"_dta", "note10", "third"},
+		{"_dta", "note2", "second"},
+		{"_dta", "note1", "first"},
+		{"Ints", "note3", "ints 2"},
+		{"Ints", "note1", "ints 1"},
+		{"Ints", "notes", "not a note"},
+		{"Ints", "destring", "not a note"},
+		{"Floats", "note0", "0"},
+	})
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	notes := stata.Notes()
+
+	if len(notes) != 2 {
+		t.Errorf("notes for %d variables", len(notes))
+	}
+	if x := strings.Join(notes[""], ","); x != "first,second,third" {
+		t.Errorf("unexpected dataset notes %s", x)
+	}
+	if x := strings.Join(notes["Ints"], ","); x != "ints 1,ints 2" {
+		t.Errorf("unexpected variable notes %s", x)
+	}
+
+	// Notes in the expansion fields of an older file
+	b, err = ioutil.ReadFile(filepath.Join("test_files", "data", "stata4_115.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := addCharacteristics(b, [][3]string{
+		{"_dta", "note0", "1"},
+		{"_dta", "note1", "old note"},
+	})
+	stata, err = NewStataReader(bytes.NewReader(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := strings.Join(stata.Notes()[""], ","); x != "old note" {
+		t.Errorf("unexpected dataset notes %s", x)
+	}
+
+	// An expansion field that extends beyond the end of the file
+	i := int(stata.seekData) - 5 - 2*(66+len("old note")+1+5)
+	binary.LittleEndian.PutUint32(c[i+1:i+5], 0x7ffffff0)
+	if _, err := NewStataReader(bytes.NewReader(c)); err == nil {
+		t.Errorf("expected an error for an invalid expansion field length")
+	}
+}
+
+func TestCategoryLabelTypes(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata4_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Labeled byte, float, long and int variables
+	expected := map[string]ColumnTypeT{
+		"fully_labeled":         StataInt8Type,
+		"fully_labeled2":        StataFloat32Type,
+		"incompletely_labeled":  StataInt32Type,
+		"labeled_with_missings": StataInt16Type,
+	}
+	for j, na := range stata.ColumnNames() {
+		if t0, ok := expected[na]; ok && stata.ColumnTypes()[j] != t0 {
+			t.Fatalf("%s has type %d, expected %d", na, stata.ColumnTypes()[j], t0)
+		}
+	}
+
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range ds {
+		if _, ok := expected[s.Name]; !ok {
+			continue
+		}
+		if _, ok := s.Data().([]string); !ok {
+			t.Errorf("%s has type %T", s.Name, s.Data())
+		}
+	}
+
+	x := ds[2].Data().([]string)
+	if ds[2].Name != "incompletely_labeled" || x[2] != "three" || x[3] != "4" || x[9] != "ten" {
+		t.Errorf("unexpected values %v in %s", x, ds[2].Name)
+	}
+	x = ds[3].Data().([]string)
+	if m := ds[3].Missing(); x[3] != "four" || !m[4] || x[4] != "" {
+		t.Errorf("unexpected values %v in %s", x, ds[3].Name)
+	}
+}
+
+func TestReadColumns(t *testing.T) {
+
+	for _, fname := range []string{"test1_115.dta", "stata2_117.dta", "stata4_117.dta", "stata14_118.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		full, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		stata, err = NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The last and first columns, in two chunks
+		names := stata.ColumnNames()
+		sel := []string{names[len(names)-1], names[0]}
+		first, err := stata.ReadColumns(sel, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rest, err := stata.ReadColumns(sel, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(first) != 2 || len(rest) != 2 {
+			t.Fatalf("%s: read %d and %d columns", fname, len(first), len(rest))
+		}
+
+		n := stata.RowCount()
+		for k, j := range []int{len(names) - 1, 0} {
+			var ix0, ix1 []int
+			for i := 0; i < n; i++ {
+				if i < 2 {
+					ix0 = append(ix0, i)
+				} else {
+					ix1 = append(ix1, i)
+				}
+			}
+			if first[k].Name != names[j] {
+				t.Errorf("%s: column %d is %s, expected %s", fname, k, first[k].Name, names[j])
+			}
+			if eq, i := full[j].selectRows(ix0).AllEqual(first[k]); !eq {
+				t.Errorf("%s: %s differs in row %d", fname, names[j], i)
+			}
+			if eq, i := full[j].selectRows(ix1).AllEqual(rest[k]); !eq {
+				t.Errorf("%s: %s differs in row %d", fname, names[j], i+2)
+			}
+		}
+
+		if ds, err := stata.ReadColumns(sel, -1); ds != nil || err != nil {
+			t.Errorf("%s: expected nil after the last row", fname)
+		}
+		_, err = stata.ReadColumns([]string{names[0], "nosuchvar", "other"}, 1)
+		if err == nil || !strings.Contains(err.Error(), "nosuchvar, other") {
+			t.Errorf("%s: unexpected error %v", fname, err)
+		}
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for j, na := range stata.ColumnNames() {
+		if k, ok := stata.ColumnIndex(na); !ok || k != j {
+			t.Errorf("%s: got position %d, expected %d", na, k, j)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		fold bool
+		pos  int
+		ok   bool
+	}{
+		{"Ints", false, 3, true},
+		{"ints", false, -1, false},
+		{"ints", true, 3, true},
+		{"LONGS", true, 6, true},
+		{"nosuchvar", true, -1, false},
+	} {
+		var j int
+		var ok bool
+		if tc.fold {
+			j, ok = stata.ColumnIndexFold(tc.name)
+		} else {
+			j, ok = stata.ColumnIndex(tc.name)
+		}
+		if ok != tc.ok || (ok && j != tc.pos) {
+			t.Errorf("%s: got %d, %t", tc.name, j, ok)
+		}
+	}
+
+	// The variable labels are matched when UseLongNames is set
+	stata.UseLongNames = true
+	if j, ok := stata.ColumnIndex("int data"); !ok || j != 3 {
+		t.Errorf("got %d, %t for a variable label", j, ok)
+	}
+	if j, ok := stata.ColumnIndexFold("INT DATA"); !ok || j != 3 {
+		t.Errorf("got %d, %t for a variable label", j, ok)
+	}
+	if _, ok := stata.ColumnIndex("Ints"); ok {
+		t.Errorf("matched a variable name with UseLongNames set")
+	}
+}
+
+// TestByteOrders checks that numeric values, including missing
+// values, are decoded in the same way from files in either byte
+// order.
+func TestByteOrders(t *testing.T) {
+
+	var cols []*Series
+	for _, c := range []struct {
+		name string
+		data interface{}
+	}{
+		{"f64", []float64{1.5, -2e300, 0, 3}},
+		{"f32", []float32{1.5, -2e30, 0, 3}},
+		{"i32", []int32{1, -2000000000, 0, 3}},
+		{"i16", []int16{1, -30000, 0, 3}},
+		{"i8", []int8{1, -100, 0, 3}},
+		{"s", []string{"a", "bc", "", "def"}},
+	} {
+		s, err := NewSeries(c.name, c.data, []bool{false, false, true, true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.missingCodes = []MissingCode{0, 0, MissingSystem, MissingSystem + 3}
+		cols = append(cols, s)
+	}
+
+	var ds [][]*Series
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		rdr, cleanup := writeAndRead(t, cols, func(w *StataWriter) {
+			w.ByteOrder = bo
+		})
+		defer cleanup()
+		if rdr.ByteOrder != bo {
+			t.Errorf("file has byte order %v, expected %v", rdr.ByteOrder, bo)
+		}
+		x, err := rdr.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, x)
+	}
+
+	if eq, j, i := SeriesArray(ds[0]).AllEqual(ds[1]); !eq {
+		t.Errorf("column %d differs at row %d", j, i)
+	}
+	for j := range cols {
+		if j == 5 {
+			// Missing strings are written as empty strings
+			continue
+		}
+		for k := range ds {
+			codes := ds[k][j].MissingCodes()
+			if codes[2] != MissingSystem || codes[3] != MissingSystem+3 || codes[0] != 0 {
+				t.Errorf("column %s: got missing codes %v", cols[j].Name, codes)
+			}
+		}
+	}
+}
+
+// benchFile writes a file with the given number of rows, and 200
+// numeric and string variables, and returns its name.  If numeric is
+// true, there are no string variables.  The numbers are written in
+// the given byte order.
+func benchFile(b *testing.B, nrow int, numeric bool, bo binary.ByteOrder) string {
+
+	kinds := 4
+	if numeric {
+		kinds = 3
+	}
+
+	var cols []*Series
+	for j := 0; j < 200; j++ {
+		var data interface{}
+		switch j % kinds {
+		case 0:
+			x := make([]float64, nrow)
+			for i := range x {
+				x[i] = float64(i) / 3
+			}
+			data = x
+		case 1:
+			x := make([]int32, nrow)
+			for i := range x {
+				x[i] = int32(i)
+			}
+			data = x
+		case 2:
+			x := make([]int8, nrow)
+			for i := range x {
+				x[i] = int8(i % 100)
+			}
+			data = x
+		case 3:
+			x := make([]string, nrow)
+			for i := range x {
+				x[i] = fmt.Sprintf("value %d", i)
+			}
+			data = x
+		}
+		s, err := NewSeries(fmt.Sprintf("v%d", j), data, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		cols = append(cols, s)
+	}
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	wtr, err := NewStataWriter(f, cols)
+	if err != nil {
+		b.Fatal(err)
+	}
+	wtr.ByteOrder = bo
+	if err := wtr.Write(); err != nil {
+		b.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, false, binary.LittleEndian)
+}
+
+func BenchmarkReadNumeric(b *testing.B) {
+	benchmarkRead(b, true, binary.LittleEndian)
+}
+
+func BenchmarkReadNumericBigEndian(b *testing.B) {
+	benchmarkRead(b, true, binary.BigEndian)
+}
+
+func benchmarkRead(b *testing.B, numeric bool, bo binary.ByteOrder) {
+
+	fname := benchFile(b, 10000, numeric, bo)
+	defer os.Remove(fname)
+
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		f, err := os.Open(fname)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stata, err := NewStataReader(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for {
+			ds, err := stata.Read(1000)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if ds == nil {
+				break
+			}
+		}
+		f.Close()
+	}
+}
+
+// readRecorder records the positions of the reads from a
+// ReadSeeker.
+type readRecorder struct {
+	r     io.ReadSeeker
+	pos   int64
+	reads [][2]int64
+}
+
+func (rr *readRecorder) Read(p []byte) (int, error) {
+	n, err := rr.r.Read(p)
+	if n > 0 {
+		rr.reads = append(rr.reads, [2]int64{rr.pos, rr.pos + int64(n)})
+	}
+	rr.pos += int64(n)
+	return n, err
+}
+
+func (rr *readRecorder) Seek(offset int64, whence int) (int64, error) {
+	pos, err := rr.r.Seek(offset, whence)
+	rr.pos = pos
+	return pos, err
+}
+
+// shortReader returns at most a few bytes from each call to Read, as a
+// network or pipe backed reader may.
+type shortReader struct {
+	r io.ReadSeeker
+}
+
+func (sr *shortReader) Read(p []byte) (int, error) {
+	if len(p) > 7 {
+		p = p[0:7]
+	}
+	return sr.r.Read(p)
+}
+
+func (sr *shortReader) Seek(offset int64, whence int) (int64, error) {
+	return sr.r.Seek(offset, whence)
+}
+
+func TestShortReads(t *testing.T) {
+
+	for _, fname := range []string{"test1_115.dta", "stata4_115.dta", "stata2_117.dta", "stata14_118.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var cols [][]*Series
+		var readers []*StataReader
+		for _, r := range []io.ReadSeeker{bytes.NewReader(b), &shortReader{r: bytes.NewReader(b)}} {
+			stata, err := NewStataReader(r)
+			if err != nil {
+				t.Fatalf("%s: %v", fname, err)
+			}
+			ds, err := stata.Read(-1)
+			if err != nil {
+				t.Fatalf("%s: %v", fname, err)
+			}
+			cols = append(cols, ds)
+			readers = append(readers, stata)
+		}
+
+		if eq, j, i := SeriesArray(cols[0]).AllEqual(cols[1]); !eq {
+			t.Errorf("%s: column %d differs at row %d", fname, j, i)
+		}
+		if !reflect.DeepEqual(readers[0].ValueLabels, readers[1].ValueLabels) {
+			t.Errorf("%s: value labels differ", fname)
+		}
+		if readers[0].DatasetLabel != readers[1].DatasetLabel || readers[0].TimeStamp != readers[1].TimeStamp {
+			t.Errorf("%s: header differs", fname)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+
+	for _, fname := range []string{"test1_115.dta", "stata4_117.dta", "stata9_117.dta", "stata14_118.dta"} {
+
+		b, err := ioutil.ReadFile(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := &readRecorder{r: bytes.NewReader(b)}
+		stata, err := NewStataReader(rr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The constructor should not read any data
+		reclen, err := stata.RecordLength()
+		if err != nil {
+			t.Fatal(err)
+		}
+		start := stata.dataStart()
+		end := start + int64(reclen*stata.RowCount())
+		for _, rd := range rr.reads {
+			if rd[0] < end && rd[1] > start {
+				t.Errorf("%s: read [%d, %d) overlaps the data [%d, %d)", fname, rd[0], rd[1], start, end)
+			}
+		}
+
+		schema := stata.Schema()
+		if len(schema) != stata.Nvar {
+			t.Fatalf("%s: schema has %d variables", fname, len(schema))
+		}
+		for j, ci := range schema {
+			if ci.Name != stata.ColumnNames()[j] || ci.LongName != stata.ColumnNamesLong[j] ||
+				ci.StataType != stata.ColumnTypes()[j] || ci.Format != stata.Formats[j] ||
+				ci.ValueLabelName != stata.ValueLabelNames[j] {
+				t.Errorf("%s: unexpected schema %+v for variable %d", fname, ci, j)
+			}
+			if ci.IsDate != strings.HasPrefix(ci.Format, "%t") {
+				t.Errorf("%s: unexpected IsDate for format %s", fname, ci.Format)
+			}
+		}
+	}
+}
+
+func TestFileInfo(t *testing.T) {
+
+	for _, tc := range []struct {
+		fname                string
+		version, nvar, nrow  int
+		strls, labels, dates bool
+		label                string
+	}{
+		{"stata14_118.dta", 118, 7, 5, true, true, false, "This is a  Ünicode data label"},
+		{"stata4_117.dta", 117, 5, 10, false, true, false, ""},
+		{"stata2_115.dta", 115, 8, 3, false, false, true, ""},
+	} {
+		r, err := os.Open(filepath.Join("test_files", "data", tc.fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs := stata.FileInfo()
+		if fs.FormatVersion != tc.version || fs.Nvar != tc.nvar || fs.RowCount != tc.nrow || fs.ByteOrder != "LittleEndian" {
+			t.Errorf("%s: unexpected summary %+v", tc.fname, fs)
+		}
+		if fs.HasStrls != tc.strls || fs.HasValueLabels != tc.labels || fs.HasDates != tc.dates {
+			t.Errorf("%s: unexpected features %+v", tc.fname, fs)
+		}
+		if fs.DatasetLabel != tc.label || fs.TimeStamp != stata.TimeStamp {
+			t.Errorf("%s: unexpected label %q or time stamp %q", tc.fname, fs.DatasetLabel, fs.TimeStamp)
+		}
+		if s := fs.String(); !strings.HasPrefix(s, fmt.Sprintf("version %d, LittleEndian, %d variables", tc.version, tc.nvar)) {
+			t.Errorf("%s: unexpected description %s", tc.fname, s)
+		}
+	}
+}
+
+func TestStringEncoding(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "test1_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Windows-1252 text in a variable name, a str# value, and a
+	// strl value.
+	i := bytes.Index(b, []byte("<varnames>")) + 16
+	b[i] = 0xe9
+	i = bytes.Index(b, []byte("<data>"))
+	i += bytes.Index(b[i:], []byte("apple"))
+	b[i+4] = 0xe9
+	i = bytes.Index(b, []byte("<strls>"))
+	i += bytes.Index(b[i:], []byte("pear"))
+	b[i+1] = 0xe9
+
+	// Without an encoding the text is not converted
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stata.ColumnNames()[0] != "column\xe9" {
+		t.Errorf("unexpected name %q", stata.ColumnNames()[0])
+	}
+	if stata.Encoding != DefaultStataEncoding {
+		t.Errorf("unexpected encoding %s", stata.Encoding)
+	}
+
+	if err := stata.SetStringEncoding(charmap.Windows1252); err != nil {
+		t.Fatal(err)
+	}
+	if err := stata.SetStringEncoding(charmap.Windows1252); err == nil {
+		t.Errorf("expected an error when setting the encoding twice")
+	}
+	if stata.Encoding != "windows-1252" {
+		t.Errorf("unexpected encoding %s", stata.Encoding)
+	}
+	if stata.ColumnNames()[0] != "columné" {
+		t.Errorf("unexpected name %q", stata.ColumnNames()[0])
+	}
+	if j, ok := stata.ColumnIndex("columné"); !ok || j != 0 {
+		t.Errorf("ColumnIndex of the decoded name: %d, %v", j, ok)
+	}
+	if j, ok := stata.ColumnIndexFold("COLUMNÉ"); !ok || j != 0 {
+		t.Errorf("ColumnIndexFold of the decoded name: %d, %v", j, ok)
+	}
+	if _, ok := stata.ColumnIndex("column\xe9"); ok {
+		t.Errorf("the undecoded name should not be found")
+	}
+
+	// ReadColumns looks up the decoded name
+	stata2, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stata2.SetStringEncoding(charmap.Windows1252); err != nil {
+		t.Fatal(err)
+	}
+	cols, err := stata2.ReadColumns([]string{"columné"}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 1 || cols[0].Name != "columné" {
+		t.Errorf("unexpected columns from ReadColumns")
+	}
+
+	ds, err := stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, s := range ds {
+		if x, ok := s.Data().([]string); ok {
+			for _, v := range x {
+				if !utf8.ValidString(v) {
+					t.Errorf("invalid UTF-8 %q in %s", v, s.Name)
+				}
+				found[v] = true
+			}
+		}
+	}
+	if !found["applé"] || !found["péar"] {
+		t.Errorf("decoded values not found")
+	}
+
+	// Value labels
+	b, err = ioutil.ReadFile(filepath.Join("test_files", "data", "stata4_117.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i = bytes.Index(b, []byte("<value_labels>"))
+	i += bytes.Index(b[i:], []byte("three"))
+	b[i+3] = 0xe9
+	stata, err = NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stata.SetStringEncoding(charmap.ISO8859_1); err != nil {
+		t.Fatal(err)
+	}
+	ds, err = stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]string); x[2] != "thrée" {
+		t.Errorf("unexpected label %q", x[2])
+	}
+
+	// Version 118 is not affected
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stata.SetStringEncoding(charmap.Windows1252); err != nil {
+		t.Fatal(err)
+	}
+	ds, err = stata.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[2].Data().([]string); x[1] != "Uzunköprü" {
+		t.Errorf("unexpected value %q", x[1])
+	}
+	if stata.Encoding != "utf-8" {
+		t.Errorf("unexpected encoding %s", stata.Encoding)
+	}
+}
+
+func TestCorruptCounts(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bo := stata.ByteOrder
+	i := bytes.Index(b, []byte("<N>")) + 3
+
+	// A negative number of observations is rejected when opening
+	bo.PutUint64(b[i:i+8], math.MaxUint64)
+	if _, err := NewStataReader(bytes.NewReader(b)); err == nil {
+		t.Errorf("expected an error for a negative number of observations")
+	}
+
+	// A number of observations that the file cannot hold is rejected
+	// before the data are allocated
+	bo.PutUint64(b[i:i+8], 1<<40)
+	stata, err = NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stata.Read(-1); err == nil {
+		t.Errorf("expected an error for too many observations")
+	}
+	var buf ReadBuffer
+	if err := stata.ReadInto(&buf, -1); err == nil {
+		t.Errorf("expected an error for too many observations")
+	}
+}
@@ -0,0 +1,122 @@
+package datareader
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// rowChunkSize is the number of rows that are decoded at a time when
+// iterating over rows.
+const rowChunkSize = 1000
+
+// RowIterator yields the observations of a Stata file one at a time.
+// The rows are decoded in small chunks, so memory use does not grow
+// with the size of the file.
+type RowIterator struct {
+	rdr *StataReader
+
+	// The current chunk, and the position in it
+	chunk []*Series
+	pos   int
+
+	// The struct type last passed to Decode, and the column of
+	// each of its fields
+	structType reflect.Type
+	structCols []int
+}
+
+// RowReader returns an iterator over the remaining rows of the file.
+// The iterator shares its position in the file with Read, so the two
+// should not be mixed.
+func (rdr *StataReader) RowReader() *RowIterator {
+	return &RowIterator{rdr: rdr}
+}
+
+// Next returns the next observation, with one value per variable in
+// the order of ColumnNames.  Missing values are nil, and the other
+// values are converted as they would be by Read.  After the last row
+// has been returned, Next returns io.EOF.
+func (it *RowIterator) Next() ([]interface{}, error) {
+
+	if err := it.fill(); err != nil {
+		return nil, err
+	}
+
+	row := make([]interface{}, len(it.chunk))
+	for j, s := range it.chunk {
+		if s.missing == nil || !s.missing[it.pos] {
+			row[j] = s.value(it.pos)
+		}
+	}
+	it.pos++
+
+	return row, nil
+}
+
+// fill reads the next chunk of rows if the current chunk has been
+// used up.  io.EOF is returned if no rows remain.
+func (it *RowIterator) fill() error {
+
+	if it.chunk != nil && it.pos < it.chunk[0].Length() {
+		return nil
+	}
+
+	chunk, err := it.rdr.Read(rowChunkSize)
+	if err != nil {
+		return err
+	}
+	if chunk == nil || len(chunk) == 0 || chunk[0].Length() == 0 {
+		return io.EOF
+	}
+	it.chunk = chunk
+	it.pos = 0
+
+	return nil
+}
+
+// Decode stores the next observation in the struct pointed to by dest,
+// in the manner of Scan in database/sql.  Struct fields are matched
+// to variables by a `dta:"name"` tag (or a `stata:"name"` tag), or by
+// the field name if there is no tag, and fields without a variable
+// are left unchanged.  The values are converted as they would be by
+// Read, and numbers may be stored in fields of any numeric type that
+// holds them exactly.  Missing values set pointer fields to nil and
+// other fields to their zero value.  After the last row has been
+// returned, Decode returns io.EOF.  Decode and Next share the
+// position of the iterator.
+func (it *RowIterator) Decode(dest interface{}) error {
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Decode requires a pointer to a struct, not %T", dest)
+	}
+	dv = dv.Elem()
+
+	if dv.Type() != it.structType {
+		cols, err := structFields(dv.Type(), it.rdr.ColumnNames(), structTags...)
+		if err != nil {
+			return err
+		}
+		it.structType = dv.Type()
+		it.structCols = cols
+	}
+
+	if err := it.fill(); err != nil {
+		return err
+	}
+
+	for k, j := range it.structCols {
+		if j < 0 {
+			continue
+		}
+		s := it.chunk[j]
+		miss := s.missing != nil && s.missing[it.pos]
+		if err := setField(dv.Field(k), s.value(it.pos), miss); err != nil {
+			return fmt.Errorf("field %s, column %s: %v", dv.Type().Field(k).Name, s.Name, err)
+		}
+	}
+	it.pos++
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package datareader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRowReader(t *testing.T) {
+
+	for _, fname := range []string{"test1_115.dta", "stata2_117.dta", "stata12_117.dta", "stata14_118.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		stata, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := r.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		stata, err = NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it := stata.RowReader()
+
+		var n int
+		for {
+			row, err := it.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(row) != len(ds) {
+				t.Fatalf("%s: row has %d values", fname, len(row))
+			}
+			for j, s := range ds {
+				if s.Missing()[n] {
+					if row[j] != nil {
+						t.Errorf("%s: missing value in row %d of %s is %v", fname, n, s.Name, row[j])
+					}
+					continue
+				}
+				if tm, ok := row[j].(time.Time); ok {
+					if !tm.Equal(s.value(n).(time.Time)) {
+						t.Errorf("%s: row %d of %s is %v", fname, n, s.Name, row[j])
+					}
+				} else if row[j] != s.value(n) {
+					t.Errorf("%s: row %d of %s is %v, expected %v", fname, n, s.Name, row[j], s.value(n))
+				}
+			}
+			n++
+		}
+
+		if n != stata.RowCount() {
+			t.Errorf("%s: read %d rows, expected %d", fname, n, stata.RowCount())
+		}
+		if _, err := it.Next(); err != io.EOF {
+			t.Errorf("%s: expected io.EOF after the last row", fname)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type obs struct {
+		Things string
+		City   string   `dta:"Cities"`
+		Ints   *int64   `dta:"Ints"`
+		Floats *float64 `stata:"Floats"`
+		Label  string   `dta:"Bytes"`
+		Longs  float64  `dta:"-"`
+		Other  int
+	}
+
+	it := stata.RowReader()
+	var rows []obs
+	for {
+		var o obs
+		o.Other = 7
+		err := it.Decode(&o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, o)
+	}
+
+	if len(rows) != 5 {
+		t.Fatalf("decoded %d rows", len(rows))
+	}
+	if rows[0].Things != "Cat" || rows[3].City != "Tokyo" || rows[0].Label != "option b Ünicode" || rows[0].Other != 7 {
+		t.Errorf("unexpected values %+v", rows[0])
+	}
+	if rows[1].Ints != nil || rows[1].Floats != nil {
+		t.Errorf("missing values are not nil")
+	}
+	if *rows[3].Ints != -4 || *rows[3].Floats != 4 {
+		t.Errorf("unexpected values %d %v", *rows[3].Ints, *rows[3].Floats)
+	}
+
+	// Errors name the field and column
+	if _, err := r.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	stata, err = NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it = stata.RowReader()
+	var bad struct {
+		F int8 `dta:"Floats"`
+	}
+	for err == nil {
+		err = it.Decode(&bad)
+	}
+	if err == io.EOF || !strings.Contains(err.Error(), "field F, column Floats") {
+		t.Errorf("unexpected error %v", err)
+	}
+	var unknown struct {
+		X string `dta:"nosuchvar"`
+	}
+	if err := it.Decode(&unknown); err == nil {
+		t.Errorf("expected an error for an unknown column")
+	}
+	if err := it.Decode(bad); err == nil {
+		t.Errorf("expected an error for a non-pointer")
+	}
+}
@@ -0,0 +1,317 @@
+package datareader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// StreamingStataWriter writes Stata dta files in format version 118
+// one row at a time, so that data sets larger than memory can be
+// written.  The variables are fixed when the writer is created.  The
+// number of observations and the map of the file are filled in by
+// Finish.  The values of strl variables are held in memory until
+// Finish is called.  The exported fields may be set after calling
+// NewStreamingStataWriter and before the first call to AppendRow.
+type StreamingStataWriter struct {
+
+	// A short text label for the data set.
+	DatasetLabel string
+
+	// The time stamp for the data set.  If zero, the time at which
+	// the first row is written is used.
+	TimeStamp time.Time
+
+	// String labels for categorical variables, keyed by the name of
+	// the label set and then by value.  Variables refer to a label
+	// set through the ValueLabelName of their ColumnInfo.
+	ValueLabels map[string]map[int32]string
+
+	// The byte order of the file, either binary.LittleEndian (the
+	// default) or binary.BigEndian.
+	ByteOrder binary.ByteOrder
+
+	// The variables to be written
+	schema []ColumnInfo
+
+	// The display format of each variable
+	formats []string
+
+	// Writes the sections of the file
+	wtr *StataWriter
+
+	// The strl values that have been written
+	strls strlTable
+
+	// The positions of the sections of the file
+	seek [14]int64
+
+	// The position of the beginning of the file in the underlying
+	// writer
+	start int64
+
+	// The encoded values of the current row
+	row bytes.Buffer
+
+	started  bool
+	finished bool
+}
+
+// NewStreamingStataWriter returns a StreamingStataWriter that writes
+// variables described by schema to w.  The Name and StataType of each
+// variable must be set, and the LongName, Format and ValueLabelName
+// are used if they are not empty.  Variables without a format are
+// given the default format of their type, or %tc if IsDate is set.
+// Nothing is written until AppendRow or Finish is called.
+func NewStreamingStataWriter(w io.WriteSeeker, schema []ColumnInfo) (*StreamingStataWriter, error) {
+
+	if len(schema) > 32767 {
+		return nil, fmt.Errorf("too many columns (%d)", len(schema))
+	}
+
+	sw := &StreamingStataWriter{
+		ByteOrder: binary.LittleEndian,
+		schema:    schema,
+		formats:   make([]string, len(schema)),
+		wtr:       &StataWriter{writer: w},
+	}
+
+	names := make(map[string]bool)
+	for j, c := range schema {
+		if len(c.Name) == 0 || len(c.Name) > 128 {
+			return nil, fmt.Errorf("column %d has invalid name %q", j, c.Name)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate column name %s", c.Name)
+		}
+		names[c.Name] = true
+
+		switch t := c.StataType; {
+		case t >= 1 && t <= 2045, t == StataStrlType, t == StataFloat64Type, t == StataFloat32Type,
+			t == StataInt32Type, t == StataInt16Type, t == StataInt8Type:
+		default:
+			return nil, fmt.Errorf("column %s has invalid type %d", c.Name, t)
+		}
+
+		if len(c.LongName) > 320 {
+			return nil, fmt.Errorf("label of column %s is too long", c.Name)
+		}
+		if len(c.ValueLabelName) > 128 {
+			return nil, fmt.Errorf("value label name %s of column %s is too long", c.ValueLabelName, c.Name)
+		}
+
+		f := c.Format
+		if f == "" && c.IsDate {
+			f = "%tc"
+		} else if f == "" {
+			f = defaultFormat(c.StataType, nil)
+		}
+		if len(f) > 56 {
+			return nil, fmt.Errorf("format %s of column %s is too long", f, c.Name)
+		}
+		sw.formats[j] = f
+	}
+
+	return sw, nil
+}
+
+// begin writes the sections of the file that precede the data.
+func (sw *StreamingStataWriter) begin() error {
+
+	if sw.ByteOrder != binary.LittleEndian && sw.ByteOrder != binary.BigEndian {
+		return fmt.Errorf("unsupported byte order %v", sw.ByteOrder)
+	}
+	if len(sw.DatasetLabel) > 320 {
+		return fmt.Errorf("dataset label is too long")
+	}
+	for na, labels := range sw.ValueLabels {
+		if na == "" || len(na) > 128 {
+			return fmt.Errorf("invalid value label name %q", na)
+		}
+		for _, v := range labels {
+			if len(v) > 32000 {
+				return fmt.Errorf("a label in value labels %s is too long", na)
+			}
+		}
+	}
+
+	wtr := sw.wtr
+	wtr.DatasetLabel = sw.DatasetLabel
+	wtr.TimeStamp = sw.TimeStamp
+	wtr.ValueLabels = sw.ValueLabels
+	wtr.ByteOrder = sw.ByteOrder
+
+	names := make([]string, len(sw.schema))
+	vartypes := make([]ColumnTypeT, len(sw.schema))
+	labels := make([]string, len(sw.schema))
+	wtr.ValueLabelNames = make([]string, len(sw.schema))
+	for j, c := range sw.schema {
+		names[j] = c.Name
+		vartypes[j] = c.StataType
+		labels[j] = c.LongName
+		wtr.ValueLabelNames[j] = c.ValueLabelName
+	}
+
+	var err error
+	sw.start, err = wtr.writer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	wtr.buf = bufio.NewWriter(wtr.writer)
+	wtr.pos = 0
+
+	if err := wtr.writeMetadata(names, vartypes, sw.formats, labels, &sw.seek); err != nil {
+		return err
+	}
+
+	sw.seek[9] = wtr.pos
+	sw.started = true
+
+	return wtr.emit([]byte("<data>"))
+}
+
+// AppendRow writes one observation, with one value for each variable.
+// String variables take string values.  Numeric variables take
+// values of any Go numeric type or bool, and date variables also take
+// time.Time values, which are converted according to the format of
+// the variable.  A nil value or a NaN is written as the system
+// missing value, and a MissingCode is written as the corresponding
+// missing value.  If an error is returned, the row is not written.
+func (sw *StreamingStataWriter) AppendRow(vals []interface{}) error {
+
+	if sw.finished {
+		return fmt.Errorf("AppendRow called after Finish")
+	}
+	if len(vals) != len(sw.schema) {
+		return fmt.Errorf("%d values for %d columns", len(vals), len(sw.schema))
+	}
+	if !sw.started {
+		if err := sw.begin(); err != nil {
+			return err
+		}
+	}
+
+	sw.row.Reset()
+	i := sw.wtr.rowCount
+	keys := make(map[int]string)
+
+	for j, v := range vals {
+		c := sw.schema[j]
+		t := c.StataType
+
+		if t <= 2045 || t == StataStrlType {
+			var s string
+			switch v := v.(type) {
+			case nil, MissingCode:
+			case string:
+				s = v
+			default:
+				return fmt.Errorf("column %s: cannot write %T value to a string variable", c.Name, v)
+			}
+			if t <= 2045 {
+				if len(s) > int(t) {
+					return fmt.Errorf("column %s: value %q is longer than %d bytes", c.Name, s, t)
+				}
+				field := make([]byte, t)
+				copy(field, s)
+				sw.row.Write(field)
+			} else {
+				// The key is assigned once the row is known to be
+				// valid.
+				keys[j] = s
+				sw.row.Write(make([]byte, 8))
+			}
+			continue
+		}
+
+		var x float64
+		var miss bool
+		var ext int
+		switch v := v.(type) {
+		case nil:
+			miss = true
+		case MissingCode:
+			miss = true
+			if v > MissingSystem && v <= MissingSystem+26 {
+				ext = int(v - MissingSystem)
+			}
+		case float64:
+			x = v
+		case float32:
+			x = float64(v)
+		case int:
+			x = float64(v)
+		case int64:
+			x = float64(v)
+		case int32:
+			x = float64(v)
+		case int16:
+			x = float64(v)
+		case int8:
+			x = float64(v)
+		case bool:
+			if v {
+				x = 1
+			}
+		case time.Time:
+			y, err := timeToStata([]time.Time{v}, sw.formats[j])
+			if err != nil {
+				return fmt.Errorf("column %s: %v", c.Name, err)
+			}
+			x = y[0]
+		default:
+			return fmt.Errorf("column %s: cannot write %T value to a numeric variable", c.Name, v)
+		}
+		if math.IsNaN(x) {
+			miss = true
+		}
+		if !miss && !numericFits(t, []float64{x}, []bool{false}) {
+			return fmt.Errorf("column %s: value %v cannot be stored as type %d", c.Name, v, t)
+		}
+		sw.wtr.putNumeric(&sw.row, t, x, miss, ext)
+	}
+
+	// Fill in the strl keys
+	b := sw.row.Bytes()
+	var off int
+	for j, c := range sw.schema {
+		if s, ok := keys[j]; ok {
+			sw.wtr.putStrlKey(b[off:], sw.strls.key(s, j, i))
+		}
+		off += varWidth(c.StataType)
+	}
+
+	if err := sw.wtr.emit(b); err != nil {
+		return err
+	}
+	sw.wtr.rowCount++
+
+	return nil
+}
+
+// Finish writes the sections of the file that follow the data, and
+// fills in the number of observations and the positions of the
+// sections.  On return, the underlying writer is positioned at the end
+// of the file.  No rows can be appended after Finish is called.
+func (sw *StreamingStataWriter) Finish() error {
+
+	if sw.finished {
+		return fmt.Errorf("Finish has already been called")
+	}
+	if !sw.started {
+		if err := sw.begin(); err != nil {
+			return err
+		}
+	}
+	sw.finished = true
+
+	if err := sw.wtr.emit([]byte("</data>")); err != nil {
+		return err
+	}
+
+	return sw.wtr.writeTrailer(sw.strls.gsos, &sw.seek, sw.start)
+}
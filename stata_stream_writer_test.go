@@ -0,0 +1,192 @@
+package datareader
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamingWriter(t *testing.T) {
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	schema := []ColumnInfo{
+		{Name: "a", StataType: StataInt8Type, ValueLabelName: "yesno"},
+		{Name: "b", StataType: StataInt16Type},
+		{Name: "c", StataType: StataInt32Type, LongName: "A long variable"},
+		{Name: "d", StataType: StataFloat32Type},
+		{Name: "e", StataType: StataFloat64Type},
+		{Name: "f", StataType: 5},
+		{Name: "g", StataType: StataStrlType},
+		{Name: "h", StataType: StataFloat64Type, Format: "%td", IsDate: true},
+	}
+	sw, err := NewStreamingStataWriter(f, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sw.DatasetLabel = "streamed"
+	sw.ValueLabels = map[string]map[int32]string{"yesno": {1: "yes", 2: "no"}}
+
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	n := 5000
+	long := strings.Repeat("x", 3000)
+	for i := 0; i < n; i++ {
+		row := []interface{}{int8(1 + i%2), i % 1000, int32(i), float32(i) / 2, float64(i) / 4, "abc", long, day}
+		if i == 1 {
+			row = []interface{}{nil, MissingSystem + 1, nil, nil, MissingSystem + 26, nil, nil, nil}
+		}
+		if err := sw.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Rows that cannot be written are rejected
+	for _, row := range [][]interface{}{
+		{1, 2, 3, 4, 5, "abcdef", "", day},
+		{1000, 2, 3, 4, 5, "", "", day},
+		{"1", 2, 3, 4, 5, "", "", day},
+		{1, 2, 3},
+	} {
+		if err := sw.AppendRow(row); err == nil {
+			t.Errorf("expected an error for row %v", row)
+		}
+	}
+
+	if err := sw.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.AppendRow(make([]interface{}, len(schema))); err == nil {
+		t.Errorf("expected an error for a row appended after Finish")
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	rdr, err := NewStataReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rdr.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if rdr.RowCount() != n || rdr.DatasetLabel != "streamed" || rdr.ColumnNamesLong[2] != "A long variable" {
+		t.Errorf("unexpected metadata: %d rows, label %q", rdr.RowCount(), rdr.DatasetLabel)
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]string); x[0] != "yes" || x[2] != "yes" || x[3] != "no" {
+		t.Errorf("unexpected labels %v", x[0:4])
+	}
+	if x := ds[2].Data().([]int32); x[n-1] != int32(n-1) {
+		t.Errorf("unexpected value %d", x[n-1])
+	}
+	if x := ds[4].Data().([]float64); x[n-1] != float64(n-1)/4 {
+		t.Errorf("unexpected value %f", x[n-1])
+	}
+	if x := ds[6].Data().([]string); x[0] != long || x[n-1] != long || x[1] != "" {
+		t.Errorf("unexpected strl values")
+	}
+	if x := ds[7].Data().([]time.Time); !x[0].Equal(day) {
+		t.Errorf("unexpected date %v", x[0])
+	}
+	for j := range ds {
+		if !ds[j].IsMissing(1) && j != 5 && j != 6 {
+			t.Errorf("column %d: row 1 is not missing", j)
+		}
+	}
+	if c := ds[1].MissingCodes(); c[1] != MissingSystem+1 {
+		t.Errorf("unexpected missing code %v", c[1])
+	}
+	if c := ds[4].MissingCodes(); c[1] != MissingSystem+26 {
+		t.Errorf("unexpected missing code %v", c[1])
+	}
+}
+
+func TestStreamingWriterByteOrder(t *testing.T) {
+
+	schema := []ColumnInfo{
+		{Name: "a", StataType: StataInt16Type},
+		{Name: "b", StataType: StataStrlType},
+		{Name: "c", StataType: StataFloat64Type, Format: "%td"},
+	}
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	long := strings.Repeat("y", 3000)
+
+	var ds [][]*Series
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		f, err := ioutil.TempFile("", "datareader")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		sw, err := NewStreamingStataWriter(f, schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sw.ByteOrder = bo
+		for i := 0; i < 100; i++ {
+			row := []interface{}{i - 50, long[0 : 30*i], day.AddDate(0, 0, i)}
+			if i == 7 {
+				row = []interface{}{MissingSystem + 3, nil, nil}
+			}
+			if err := sw.AppendRow(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sw.Finish(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		rdr, err := NewStataReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rdr.ByteOrder != bo {
+			t.Errorf("file has byte order %v, expected %v", rdr.ByteOrder, bo)
+		}
+		x, err := rdr.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, x)
+	}
+
+	if eq, j, i := SeriesArray(ds[0]).AllEqual(ds[1]); !eq {
+		t.Errorf("column %d differs at row %d", j, i)
+	}
+	if x := ds[1][1].Data().([]string); x[99] != long[0:2970] {
+		t.Errorf("unexpected strl values")
+	}
+	if c := ds[1][0].MissingCodes(); c[7] != MissingSystem+3 {
+		t.Errorf("unexpected missing code %v", c[7])
+	}
+}
+
+func TestStreamingWriterErrors(t *testing.T) {
+
+	for _, schema := range [][]ColumnInfo{
+		{{Name: "", StataType: StataInt8Type}},
+		{{Name: "a", StataType: StataInt8Type}, {Name: "a", StataType: StataInt8Type}},
+		{{Name: "a", StataType: 3000}},
+	} {
+		if _, err := NewStreamingStataWriter(nil, schema); err == nil {
+			t.Errorf("expected an error for schema %v", schema)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package datareader
+
+import (
+	"container/list"
+)
+
+// A StrlResolver looks up strl values by key, reading them from the
+// strls section of the file when they are needed and keeping the most
+// recently used values in memory.  This suits files in which the rows
+// refer to a small working set out of a large pool of strls, where
+// loading all the strls uses too much memory, and reading each value
+// from the file when a row refers to it repeats the same reads.  The
+// StataReader uses a StrlResolver when LazyStrls is set.
+type StrlResolver struct {
+
+	// The maximum number of values kept in memory.  If zero or
+	// negative, each value is read from the file when it is needed.
+	MaxEntries int
+
+	rdr *StataReader
+
+	// The cached values, keyed by strl key, and their order of use,
+	// with the most recently used value at the front
+	entries map[uint64]*list.Element
+	order   *list.List
+
+	hits, misses int
+}
+
+// strlEntry is a cached strl value.  Text values are held in text, and
+// binary values in bin.
+type strlEntry struct {
+	key  uint64
+	text string
+	bin  []byte
+}
+
+// NewStrlResolver returns a StrlResolver that reads strls from the
+// file of rdr, keeping at most maxEntries values in memory.
+func NewStrlResolver(rdr *StataReader, maxEntries int) *StrlResolver {
+
+	return &StrlResolver{
+		MaxEntries: maxEntries,
+		rdr:        rdr,
+		entries:    make(map[uint64]*list.Element),
+		order:      list.New(),
+	}
+}
+
+// Value returns the strl with the given key, as StrlValue does, taking
+// it from the cache if possible.  Text values are returned as a
+// string, and binary values as a byte slice, which must not be
+// modified since it is shared with the cache.
+func (sr *StrlResolver) Value(key uint64) (string, []byte, error) {
+
+	if e, ok := sr.entries[key]; ok {
+		sr.hits++
+		sr.order.MoveToFront(e)
+		v := e.Value.(*strlEntry)
+		return v.text, v.bin, nil
+	}
+
+	sr.misses++
+	s, b, err := sr.rdr.StrlValue(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if sr.MaxEntries > 0 {
+		sr.entries[key] = sr.order.PushFront(&strlEntry{key: key, text: s, bin: b})
+	}
+	for sr.order.Len() > 0 && sr.order.Len() > sr.MaxEntries {
+		e := sr.order.Back()
+		sr.order.Remove(e)
+		delete(sr.entries, e.Value.(*strlEntry).key)
+	}
+
+	return s, b, nil
+}
+
+// Len returns the number of values held in the cache.
+func (sr *StrlResolver) Len() int {
+	return sr.order.Len()
+}
+
+// Stats returns the number of lookups that were satisfied from the
+// cache, and the number that read the value from the file.
+func (sr *StrlResolver) Stats() (hits, misses int) {
+	return sr.hits, sr.misses
+}
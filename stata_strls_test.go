@@ -0,0 +1,220 @@
+package datareader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStrlResolver(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []uint64
+	for k := range stata.strlOffsets {
+		keys = append(keys, k)
+	}
+	if len(keys) < 3 {
+		t.Fatalf("expected at least 3 strls, found %d", len(keys))
+	}
+
+	sr := NewStrlResolver(stata, 2)
+	for _, k := range []uint64{keys[0], keys[1], keys[0], keys[2], keys[1]} {
+		s, b, err := sr.Value(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		es, eb, err := stata.StrlValue(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s != es || string(b) != string(eb) {
+			t.Errorf("strl %d: got %q, expected %q", k, s, es)
+		}
+	}
+
+	// keys[1] was dropped when keys[2] was added, since keys[0] had
+	// been used more recently
+	if hits, misses := sr.Stats(); hits != 1 || misses != 4 {
+		t.Errorf("got %d hits and %d misses", hits, misses)
+	}
+	if sr.Len() != 2 {
+		t.Errorf("cache holds %d values", sr.Len())
+	}
+
+	sr = NewStrlResolver(stata, 0)
+	for i := 0; i < 2; i++ {
+		if _, _, err := sr.Value(keys[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hits, _ := sr.Stats(); hits != 0 || sr.Len() != 0 {
+		t.Errorf("values were cached with no entries allowed")
+	}
+
+	if _, _, err := sr.Value(1 << 60); err == nil {
+		t.Errorf("expected an error for an unknown key")
+	}
+}
+
+func TestLazyStrlErrors(t *testing.T) {
+
+	b, err := ioutil.ReadFile(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Give the first strl a length that extends beyond the end of
+	// the file.  The length follows "GSO", the 12 byte key and the
+	// type byte.
+	i := bytes.Index(b, []byte("<strls>GSO")) + 7
+	binary.LittleEndian.PutUint32(b[i+16:i+20], uint32(len(b)))
+
+	stata, err := NewStataReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stata.LazyStrls = true
+	if _, err := stata.Read(-1); err == nil {
+		t.Errorf("expected an error for a corrupt strl")
+	}
+}
+
+// strlFile writes a file with nrow rows and one strl variable whose
+// rows refer to a working set of nwork values, out of a pool of npool
+// values, and returns its name.
+func strlFile(tb testing.TB, nrow, npool, nwork int) string {
+
+	long := strings.Repeat("x", 3000)
+	x := make([]string, nrow)
+	for i := range x {
+		k := i % nwork
+		if i < npool {
+			k = i
+		}
+		x[i] = fmt.Sprintf("%s %d", long, k)
+	}
+	s, err := NewSeries("x", x, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer f.Close()
+	wtr, err := NewStataWriter(f, []*Series{s})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := wtr.Write(); err != nil {
+		tb.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestStrlCache(t *testing.T) {
+
+	fname := strlFile(t, 5000, 1000, 20)
+	defer os.Remove(fname)
+
+	var cols [][]*Series
+	for _, size := range []int{-1, 0, 10, 1000} {
+		f, err := os.Open(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		stata, err := NewStataReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size >= 0 {
+			stata.LazyStrls = true
+			stata.StrlCacheSize = size
+		}
+		ds, err := stata.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, ds)
+
+		if size > 0 && stata.strlResolver.Len() > size {
+			t.Errorf("cache of size %d holds %d values", size, stata.strlResolver.Len())
+		}
+		if size == 1000 {
+			if hits, _ := stata.strlResolver.Stats(); hits < 3900 {
+				t.Errorf("only %d strls were taken from the cache", hits)
+			}
+		}
+	}
+
+	for k := 1; k < len(cols); k++ {
+		if eq, j, i := SeriesArray(cols[0]).AllEqual(cols[k]); !eq {
+			t.Errorf("case %d differs at column %d, row %d", k, j, i)
+		}
+	}
+}
+
+// BenchmarkStrlsEager, BenchmarkStrlsLazy and BenchmarkStrlsCached
+// read a file whose rows refer to a working set of 20 strls, out of a
+// pool of 2000.  Loading all the strls allocates space for the whole
+// pool, reading each strl when it is needed repeats the reads for
+// every row, and the cache holds the working set after the first rows.
+func BenchmarkStrlsEager(b *testing.B) {
+	benchmarkStrls(b, false, 0)
+}
+
+func BenchmarkStrlsLazy(b *testing.B) {
+	benchmarkStrls(b, true, 0)
+}
+
+func BenchmarkStrlsCached(b *testing.B) {
+	benchmarkStrls(b, true, 100)
+}
+
+func benchmarkStrls(b *testing.B, lazy bool, cacheSize int) {
+
+	fname := strlFile(b, 20000, 2000, 20)
+	defer os.Remove(fname)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for k := 0; k < b.N; k++ {
+		f, err := os.Open(fname)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stata, err := NewStataReader(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		stata.LazyStrls = lazy
+		stata.StrlCacheSize = cacheSize
+		for {
+			ds, err := stata.Read(1000)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if ds == nil {
+				break
+			}
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,186 @@
+package datareader
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// structTags are the struct tags that name the column of a field, in
+// order of precedence.
+var structTags = []string{"dta", "stata"}
+
+// structFields returns the position of the column that corresponds
+// to each exported field of the struct type t, or -1 if a field has
+// no column.  Fields are matched to columns by the first of the given
+// struct tags that is present, otherwise by the field name.  A tag
+// value of "-" causes the field to be skipped.  An error is returned
+// if a tagged field has no corresponding column.
+func structFields(t reflect.Type, names []string, tags ...string) ([]int, error) {
+
+	pos := make(map[string]int, len(names))
+	for j, na := range names {
+		pos[na] = j
+	}
+
+	cols := make([]int, t.NumField())
+	for k := range cols {
+		cols[k] = -1
+		f := t.Field(k)
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+		var na string
+		var tagged bool
+		for _, tag := range tags {
+			if na, tagged = f.Tag.Lookup(tag); tagged {
+				break
+			}
+		}
+		if na == "-" {
+			continue
+		}
+		if !tagged {
+			na = f.Name
+		}
+		j, ok := pos[na]
+		if !ok {
+			if tagged {
+				return nil, fmt.Errorf("field %s: no column named %s", f.Name, na)
+			}
+			continue
+		}
+		cols[k] = j
+	}
+
+	return cols, nil
+}
+
+// setField assigns the value v to the field fv.  Missing values set
+// pointer fields to nil and other fields to their zero value.
+// Numeric values may be assigned to fields of any numeric type, as
+// long as the value is not changed by the conversion.
+func setField(fv reflect.Value, v interface{}, missing bool) error {
+
+	if fv.Kind() == reflect.Ptr {
+		if missing {
+			fv.Set(reflect.Zero(fv.Type()))
+			return nil
+		}
+		// Allocate for every value, so that copies of the struct
+		// made by the caller do not share storage.
+		pv := reflect.New(fv.Type().Elem())
+		if err := setField(pv.Elem(), v, false); err != nil {
+			return err
+		}
+		fv.Set(pv)
+		return nil
+	}
+
+	if missing {
+		fv.Set(reflect.Zero(fv.Type()))
+		return nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(fv.Type()) {
+		fv.Set(rv)
+		return nil
+	}
+
+	if isNumericKind(rv.Kind()) && isNumericKind(fv.Kind()) {
+		cv := rv.Convert(fv.Type())
+		if isFloatKind(rv.Kind()) {
+			x := rv.Float()
+			if !isFloatKind(fv.Kind()) && x != math.Trunc(x) {
+				return fmt.Errorf("value %v cannot be stored in a field of type %s", v, fv.Type())
+			}
+		}
+		// A conversion between signed and unsigned types of the
+		// same size converts back to the original value, so the
+		// signs are compared as well.
+		if !reflect.DeepEqual(cv.Convert(rv.Type()).Interface(), v) || isNegative(rv) != isNegative(cv) {
+			return fmt.Errorf("value %v overflows a field of type %s", v, fv.Type())
+		}
+		fv.Set(cv)
+		return nil
+	}
+
+	return fmt.Errorf("value of type %T cannot be stored in a field of type %s", v, fv.Type())
+}
+
+func isNumericKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func isFloatKind(k reflect.Kind) bool {
+	return k == reflect.Float32 || k == reflect.Float64
+}
+
+// isNegative returns true if the numeric value v is less than zero.
+func isNegative(v reflect.Value) bool {
+	switch {
+	case isFloatKind(v.Kind()):
+		return v.Float() < 0
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		return v.Int() < 0
+	}
+	return false
+}
+
+// Each reads the remaining rows of the file, and for each row fills
+// in the struct pointed to by dest and then calls fn.  The struct is
+// reused for every row, so fn must copy any values that it retains;
+// non-missing pointer fields point to newly allocated values.  Struct
+// fields are matched to variables by a `dta:"name"` or `stata:"name"`
+// tag, or by the field name if there is no tag.  Missing values set
+// pointer fields to nil and other fields to their zero value.
+// Iteration stops if fn returns an error, and the error is returned by
+// Each.  The data are read in chunks, so memory use does not grow with
+// the size of the file.
+func (rdr *StataReader) Each(dest interface{}, fn func() error) error {
+
+	dv := reflect.ValueOf(dest)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("Each requires a pointer to a struct, not %T", dest)
+	}
+	dv = dv.Elem()
+
+	cols, err := structFields(dv.Type(), rdr.ColumnNames(), structTags...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		chunk, err := rdr.Read(1000)
+		if err != nil {
+			return err
+		}
+		if chunk == nil {
+			return nil
+		}
+
+		for i := 0; i < chunk[0].Length(); i++ {
+			for k, j := range cols {
+				if j < 0 {
+					continue
+				}
+				s := chunk[j]
+				miss := s.missing != nil && s.missing[i]
+				if err := setField(dv.Field(k), s.value(i), miss); err != nil {
+					return fmt.Errorf("field %s, column %s: %v", dv.Type().Field(k).Name, s.Name, err)
+				}
+			}
+			if err := fn(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package datareader
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestEach(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type obs struct {
+		Things string
+		City   string   `stata:"Cities"`
+		Ints   *int     `stata:"Ints"`
+		Floats *float64 `stata:"Floats"`
+		Label  string   `stata:"Bytes"`
+		Longs  float64  `stata:"-"`
+	}
+
+	var rows []obs
+	var o obs
+	err = stata.Each(&o, func() error {
+		rows = append(rows, o)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 5 {
+		t.Fatalf("read %d rows", len(rows))
+	}
+	if rows[0].Things != "Cat" || rows[3].City != "Tokyo" || rows[0].Label != "option b Ünicode" {
+		t.Errorf("unexpected values %+v", rows[0])
+	}
+	if rows[1].Ints != nil || rows[1].Floats != nil {
+		t.Errorf("missing values are not nil")
+	}
+	if *rows[3].Ints != -4 || *rows[3].Floats != 4 {
+		t.Errorf("unexpected values %d %v", *rows[3].Ints, *rows[3].Floats)
+	}
+}
+
+func TestEachErrors(t *testing.T) {
+
+	r, err := os.Open(filepath.Join("test_files", "data", "stata14_118.dta"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	stata, err := NewStataReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var bad struct {
+		Floats int8 `stata:"Floats"`
+	}
+	if err := stata.Each(&bad, func() error { return nil }); err == nil {
+		t.Errorf("expected an error for a non-integer value")
+	}
+
+	var missing struct {
+		X string `stata:"nosuchvar"`
+	}
+	if err := stata.Each(&missing, func() error { return nil }); err == nil {
+		t.Errorf("expected an error for an unknown column")
+	}
+}
+
+func TestSetFieldConversions(t *testing.T) {
+
+	var x struct {
+		U8  uint8
+		U32 uint32
+		I8  int8
+		I64 int64
+	}
+	dv := reflect.ValueOf(&x).Elem()
+
+	for _, tc := range []struct {
+		field string
+		v     interface{}
+		ok    bool
+	}{
+		{"U8", int8(5), true},
+		{"U8", int8(-1), false},
+		{"U8", int16(256), false},
+		{"U32", int32(-5), false},
+		{"U32", float64(-1), false},
+		{"U32", int32(7), true},
+		{"I8", uint8(255), false},
+		{"I8", int16(-100), true},
+		{"I64", uint64(1 << 63), false},
+		{"I64", float64(-3), true},
+	} {
+		err := setField(dv.FieldByName(tc.field), tc.v, false)
+		if (err == nil) != tc.ok {
+			t.Errorf("%T(%v) in %s: got error %v", tc.v, tc.v, tc.field, err)
+		}
+	}
+	if x.U8 != 5 || x.U32 != 7 || x.I8 != -100 || x.I64 != -3 {
+		t.Errorf("unexpected values %+v", x)
+	}
+}
@@ -0,0 +1,847 @@
+package datareader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// StataWriter writes Stata dta files in format version 118.  The
+// exported fields may be set after calling NewStataWriter and before
+// calling Write.
+type StataWriter struct {
+
+	// A short text label for the data set.
+	DatasetLabel string
+
+	// The time stamp for the data set.  If zero, the time at which
+	// Write is called is used.
+	TimeStamp time.Time
+
+	// Display formats for each variable.  If nil, a default format
+	// is chosen from the type of each variable.  Time values are
+	// written as days (%td) or milliseconds (%tc) since 1960
+	// according to their format, and are written as %tc if no
+	// format is given.
+	Formats []string
+
+	// Variable types for each variable.  If nil, the smallest type
+	// that holds the values of the corresponding Series is used.
+	ColumnTypes []ColumnTypeT
+
+	// String labels for categorical variables, keyed by the name of
+	// the label set and then by value
+	ValueLabels map[string]map[int32]string
+
+	// The name of the label set in ValueLabels for each variable,
+	// empty for variables without value labels.  If nil, no
+	// variables have value labels.
+	ValueLabelNames []string
+
+	// A descriptive label for each variable, empty for variables
+	// without a label.  If nil, no variables have labels.  Labels
+	// longer than 80 characters are truncated.
+	VariableLabels []string
+
+	// The byte order of the file, either binary.LittleEndian (the
+	// default) or binary.BigEndian.
+	ByteOrder binary.ByteOrder
+
+	// The data to be written
+	columns []*Series
+
+	// Number of observations
+	rowCount int
+
+	// The numeric values of each variable, nil for string variables
+	values [][]float64
+
+	// Indicators that the values are missing
+	missing [][]bool
+
+	// The destination of the data
+	writer io.WriteSeeker
+	buf    *bufio.Writer
+
+	// The number of bytes written so far
+	pos int64
+
+	// The position of the number of observations in the header
+	nPos int64
+}
+
+// NewStataWriter returns a StataWriter that writes the given columns
+// to w.  All the columns must have the same length.  Nothing is
+// written until Write is called.
+func NewStataWriter(w io.WriteSeeker, columns []*Series) (*StataWriter, error) {
+
+	wtr := &StataWriter{
+		columns:   columns,
+		writer:    w,
+		ByteOrder: binary.LittleEndian,
+	}
+
+	names := make(map[string]bool)
+	for j, s := range columns {
+		if j == 0 {
+			wtr.rowCount = s.Length()
+		} else if s.Length() != wtr.rowCount {
+			return nil, fmt.Errorf("column %s has length %d, expected %d", s.Name, s.Length(), wtr.rowCount)
+		}
+		if len(s.Name) == 0 || len(s.Name) > 128 {
+			return nil, fmt.Errorf("column %d has invalid name %q", j, s.Name)
+		}
+		if names[s.Name] {
+			return nil, fmt.Errorf("duplicate column name %s", s.Name)
+		}
+		names[s.Name] = true
+		switch s.Data().(type) {
+		case []float64, []float32, []int64, []int32, []int16, []int8, []bool, []string, []time.Time:
+		default:
+			return nil, fmt.Errorf("column %s has unsupported type %T", s.Name, s.Data())
+		}
+	}
+	if len(columns) > 32767 {
+		return nil, fmt.Errorf("too many columns (%d)", len(columns))
+	}
+
+	return wtr, nil
+}
+
+// Write writes the data set to the underlying io.WriteSeeker.
+func (wtr *StataWriter) Write() error {
+
+	if wtr.Formats != nil && len(wtr.Formats) != len(wtr.columns) {
+		return fmt.Errorf("%d formats for %d columns", len(wtr.Formats), len(wtr.columns))
+	}
+	if wtr.ColumnTypes != nil && len(wtr.ColumnTypes) != len(wtr.columns) {
+		return fmt.Errorf("%d column types for %d columns", len(wtr.ColumnTypes), len(wtr.columns))
+	}
+	if wtr.ByteOrder != binary.LittleEndian && wtr.ByteOrder != binary.BigEndian {
+		return fmt.Errorf("unsupported byte order %v", wtr.ByteOrder)
+	}
+	if len(wtr.DatasetLabel) > 320 {
+		return fmt.Errorf("dataset label is too long")
+	}
+	if wtr.VariableLabels != nil && len(wtr.VariableLabels) != len(wtr.columns) {
+		return fmt.Errorf("%d variable labels for %d columns", len(wtr.VariableLabels), len(wtr.columns))
+	}
+	if wtr.ValueLabelNames != nil && len(wtr.ValueLabelNames) != len(wtr.columns) {
+		return fmt.Errorf("%d value label names for %d columns", len(wtr.ValueLabelNames), len(wtr.columns))
+	}
+	for j, na := range wtr.ValueLabelNames {
+		if len(na) > 128 {
+			return fmt.Errorf("value label name %s of column %s is too long", na, wtr.columns[j].Name)
+		}
+	}
+	for na, labels := range wtr.ValueLabels {
+		if na == "" || len(na) > 128 {
+			return fmt.Errorf("invalid value label name %q", na)
+		}
+		for _, v := range labels {
+			if len(v) > 32000 {
+				return fmt.Errorf("a label in value labels %s is too long", na)
+			}
+		}
+	}
+
+	formats := make([]string, len(wtr.columns))
+	if wtr.Formats != nil {
+		copy(formats, wtr.Formats)
+	}
+
+	if err := wtr.getValues(formats); err != nil {
+		return err
+	}
+
+	vartypes, err := wtr.getTypes()
+	if err != nil {
+		return err
+	}
+
+	for j, f := range formats {
+		if f == "" {
+			formats[j] = defaultFormat(vartypes[j], wtr.columns[j].Data())
+		}
+		if len(formats[j]) > 56 {
+			return fmt.Errorf("format %s of column %s is too long", formats[j], wtr.columns[j].Name)
+		}
+	}
+
+	start, err := wtr.writer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	wtr.buf = bufio.NewWriter(wtr.writer)
+	wtr.pos = 0
+
+	names := make([]string, len(wtr.columns))
+	for j, c := range wtr.columns {
+		names[j] = c.Name
+	}
+
+	var seek [14]int64
+	if err := wtr.writeMetadata(names, vartypes, formats, wtr.VariableLabels, &seek); err != nil {
+		return err
+	}
+
+	seek[9] = wtr.pos
+	gsos, err := wtr.writeData(vartypes)
+	if err != nil {
+		return err
+	}
+
+	return wtr.writeTrailer(gsos, &seek, start)
+}
+
+// writeTrailer writes the sections of the file that follow the data,
+// then fills in the number of observations and the map, which were
+// not known when they were first written.  start is the position of
+// the beginning of the file in the underlying writer, and on return
+// the underlying writer is positioned at the end of the file.
+func (wtr *StataWriter) writeTrailer(gsos []gso, seek *[14]int64, start int64) error {
+
+	seek[10] = wtr.pos
+	if err := wtr.writeStrls(gsos); err != nil {
+		return err
+	}
+
+	seek[11] = wtr.pos
+	if err := wtr.writeValueLabels(); err != nil {
+		return err
+	}
+
+	seek[12] = wtr.pos
+	if err := wtr.emit([]byte("</stata_dta>")); err != nil {
+		return err
+	}
+	seek[13] = wtr.pos
+
+	if err := wtr.buf.Flush(); err != nil {
+		return err
+	}
+
+	// Go back and fill in the number of observations
+	if _, err := wtr.writer.Seek(start+wtr.nPos, io.SeekStart); err != nil {
+		return err
+	}
+	n := make([]byte, 8)
+	wtr.ByteOrder.PutUint64(n, uint64(wtr.rowCount))
+	if _, err := wtr.writer.Write(n); err != nil {
+		return err
+	}
+
+	// Go back and fill in the map
+	if _, err := wtr.writer.Seek(start+seek[1], io.SeekStart); err != nil {
+		return err
+	}
+	wtr.buf.Reset(wtr.writer)
+	wtr.pos = seek[1]
+	if err := wtr.writeMap(*seek); err != nil {
+		return err
+	}
+	if err := wtr.buf.Flush(); err != nil {
+		return err
+	}
+	if _, err := wtr.writer.Seek(start+seek[13], io.SeekStart); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// writeMetadata writes the sections of the file that precede the
+// data, and records their positions in seek.  The map is written with
+// the positions that are known, and must be written again when the
+// file is complete.  labels holds the variable labels, and may be
+// nil.
+func (wtr *StataWriter) writeMetadata(names []string, vartypes []ColumnTypeT, formats, labels []string, seek *[14]int64) error {
+
+	if err := wtr.writeHeader(len(names)); err != nil {
+		return err
+	}
+
+	seek[1] = wtr.pos
+	if err := wtr.writeMap(*seek); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	nvar := len(names)
+	sections := []struct {
+		tag   string
+		width int
+		field func(j int) []byte
+	}{
+		{"variable_types", 2, func(j int) []byte {
+			v := make([]byte, 2)
+			wtr.ByteOrder.PutUint16(v, uint16(vartypes[j]))
+			return v
+		}},
+		{"varnames", 129, func(j int) []byte { return []byte(names[j]) }},
+		{"sortlist", 2, func(j int) []byte { return nil }},
+		{"formats", 57, func(j int) []byte { return []byte(formats[j]) }},
+		{"value_label_names", 129, func(j int) []byte {
+			if wtr.ValueLabelNames == nil {
+				return nil
+			}
+			return []byte(wtr.ValueLabelNames[j])
+		}},
+		{"variable_labels", 321, func(j int) []byte {
+			if labels == nil {
+				return nil
+			}
+			return []byte(truncateLabel(labels[j]))
+		}},
+	}
+
+	for k, sec := range sections {
+		seek[k+2] = wtr.pos
+		b.Reset()
+		b.WriteString("<" + sec.tag + ">")
+		n := nvar
+		if sec.tag == "sortlist" {
+			n++
+		}
+		for j := 0; j < n; j++ {
+			field := make([]byte, sec.width)
+			copy(field, sec.field(j))
+			b.Write(field)
+		}
+		b.WriteString("</" + sec.tag + ">")
+		if err := wtr.emit(b.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	seek[8] = wtr.pos
+	return wtr.emit([]byte("<characteristics></characteristics>"))
+}
+
+// truncateLabel returns the first 80 characters of the variable label
+// s.  Since a UTF-8 character occupies at most 4 bytes, the result
+// fits in the 321 byte field of a version 118 file.
+func truncateLabel(s string) string {
+
+	var n int
+	for i := range s {
+		if n == 80 {
+			return s[0:i]
+		}
+		n++
+	}
+
+	return s
+}
+
+// emit writes b to the output, keeping track of the position.
+func (wtr *StataWriter) emit(b []byte) error {
+	n, err := wtr.buf.Write(b)
+	wtr.pos += int64(n)
+	return err
+}
+
+// writeHeader writes the header for a file with nvar variables, and
+// records the position of the number of observations in nPos.
+func (wtr *StataWriter) writeHeader(nvar int) error {
+
+	var b bytes.Buffer
+	b.WriteString("<stata_dta><header><release>118</release><byteorder>")
+	if wtr.ByteOrder == binary.BigEndian {
+		b.WriteString("MSF")
+	} else {
+		b.WriteString("LSF")
+	}
+	b.WriteString("</byteorder><K>")
+	binary.Write(&b, wtr.ByteOrder, uint16(nvar))
+	b.WriteString("</K><N>")
+	wtr.nPos = wtr.pos + int64(b.Len())
+	binary.Write(&b, wtr.ByteOrder, uint64(wtr.rowCount))
+	b.WriteString("</N><label>")
+	binary.Write(&b, wtr.ByteOrder, uint16(len(wtr.DatasetLabel)))
+	b.WriteString(wtr.DatasetLabel)
+	b.WriteString("</label><timestamp>")
+	ts := wtr.TimeStamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	tss := ts.Format("02 Jan 2006 15:04")
+	b.WriteByte(uint8(len(tss)))
+	b.WriteString(tss)
+	b.WriteString("</timestamp></header>")
+
+	return wtr.emit(b.Bytes())
+}
+
+func (wtr *StataWriter) writeMap(seek [14]int64) error {
+
+	var b bytes.Buffer
+	b.WriteString("<map>")
+	for _, x := range seek {
+		binary.Write(&b, wtr.ByteOrder, uint64(x))
+	}
+	b.WriteString("</map>")
+
+	return wtr.emit(b.Bytes())
+}
+
+// getValues converts the numeric and time columns to float64 and
+// records which values are missing.  NaN values are treated as
+// missing.
+func (wtr *StataWriter) getValues(formats []string) error {
+
+	wtr.values = make([][]float64, len(wtr.columns))
+	wtr.missing = make([][]bool, len(wtr.columns))
+
+	for j, s := range wtr.columns {
+
+		miss := make([]bool, wtr.rowCount)
+		if m := s.Missing(); m != nil {
+			copy(miss, m)
+		}
+		wtr.missing[j] = miss
+
+		var x []float64
+		switch v := s.Data().(type) {
+		case []string:
+			continue
+		case []bool:
+			x = make([]float64, len(v))
+			for i, b := range v {
+				if b {
+					x[i] = 1
+				}
+			}
+		case []time.Time:
+			f := formats[j]
+			if f == "" {
+				f = "%tc"
+				formats[j] = f
+			}
+			var err error
+			x, err = timeToStata(v, f)
+			if err != nil {
+				return fmt.Errorf("column %s: %v", s.Name, err)
+			}
+		default:
+			var err error
+			x, err = upcastNumeric(v)
+			if err != nil {
+				return err
+			}
+			// Don't modify the caller's data
+			if _, ok := v.([]float64); ok {
+				x = append([]float64(nil), x...)
+			}
+		}
+
+		for i, y := range x {
+			if math.IsNaN(y) {
+				miss[i] = true
+			}
+		}
+		wtr.values[j] = x
+	}
+
+	return nil
+}
+
+// timeToStata converts time values to the number of days (%td) or
+// milliseconds (%tc, %tC) since 1960-01-01.
+func timeToStata(v []time.Time, format string) ([]float64, error) {
+
+	bt := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+	x := make([]float64, len(v))
+
+	switch {
+	case strings.HasPrefix(format, "%td"):
+		for i, t := range v {
+			x[i] = math.Floor(float64(t.Unix()-bt.Unix()) / 86400)
+		}
+	case strings.HasPrefix(format, "%tc"):
+		for i, t := range v {
+			x[i] = float64(t.Unix()-bt.Unix())*1000 + float64(t.Nanosecond()/1e6)
+		}
+	case strings.HasPrefix(format, "%tC"):
+		// Includes the leap seconds
+		for i, t := range v {
+			x[i] = float64(t.Unix()-bt.Unix()+int64(leapSecondsBefore(t)))*1000 + float64(t.Nanosecond()/1e6)
+		}
+	default:
+		return nil, fmt.Errorf("cannot write time values with format %s", format)
+	}
+
+	return x, nil
+}
+
+// getTypes returns the Stata type of each variable, either inferred
+// from the data or taken from ColumnTypes after checking that the
+// values can be represented.
+func (wtr *StataWriter) getTypes() ([]ColumnTypeT, error) {
+
+	vartypes := make([]ColumnTypeT, len(wtr.columns))
+
+	for j, s := range wtr.columns {
+
+		if x, ok := s.Data().([]string); ok {
+			w := 1
+			for i, y := range x {
+				if !wtr.missing[j][i] && len(y) > w {
+					w = len(y)
+				}
+			}
+			t := ColumnTypeT(w)
+			if w > 2045 {
+				t = StataStrlType
+			}
+			if wtr.ColumnTypes != nil {
+				ct := wtr.ColumnTypes[j]
+				if ct != StataStrlType && (ct > 2045 || ct < t) {
+					return nil, fmt.Errorf("column %s cannot be stored as type %d", s.Name, ct)
+				}
+				t = ct
+			}
+			vartypes[j] = t
+			continue
+		}
+
+		if wtr.ColumnTypes != nil {
+			t := wtr.ColumnTypes[j]
+			if !numericFits(t, wtr.values[j], wtr.missing[j]) {
+				return nil, fmt.Errorf("column %s cannot be stored as type %d", s.Name, t)
+			}
+			vartypes[j] = t
+			continue
+		}
+
+		var t ColumnTypeT
+		switch s.Data().(type) {
+		case []float64, []time.Time:
+			t = StataFloat64Type
+		case []float32:
+			t = StataFloat32Type
+		case []int64, []int32:
+			t = StataInt32Type
+		case []int16:
+			t = StataInt16Type
+		case []int8, []bool:
+			t = StataInt8Type
+		}
+
+		// Use a larger type if some values would be read back as
+		// missing.
+		for !numericFits(t, wtr.values[j], wtr.missing[j]) {
+			switch t {
+			case StataInt8Type:
+				t = StataInt16Type
+			case StataInt16Type:
+				t = StataInt32Type
+			case StataInt32Type, StataFloat32Type:
+				t = StataFloat64Type
+			default:
+				return nil, fmt.Errorf("column %s has values that cannot be stored", s.Name)
+			}
+		}
+		vartypes[j] = t
+	}
+
+	return vartypes, nil
+}
+
+// numericFits returns true if the non-missing values of x can be
+// stored in a variable of type t without being read back as missing
+// values.
+func numericFits(t ColumnTypeT, x []float64, missing []bool) bool {
+
+	var lo, hi float64
+	integer := true
+	switch t {
+	case StataInt8Type:
+		lo, hi = -127, 100
+	case StataInt16Type:
+		lo, hi = -32767, 32740
+	case StataInt32Type:
+		lo, hi = -2147483647, 2147483620
+	case StataFloat32Type:
+		lo, hi, integer = -1.701e38, 1.701e38, false
+	case StataFloat64Type:
+		lo, hi, integer = -8.988e307, 8.988e307, false
+	default:
+		return false
+	}
+
+	for i, y := range x {
+		if missing[i] {
+			continue
+		}
+		if y < lo || y > hi || (integer && y != math.Trunc(y)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// defaultFormat returns the display format that Stata uses by
+// default for variables of the given type.
+func defaultFormat(t ColumnTypeT, data interface{}) string {
+
+	if _, ok := data.([]time.Time); ok {
+		return "%tc"
+	}
+
+	switch {
+	case t <= 2045:
+		return fmt.Sprintf("%%%ds", t)
+	case t == StataStrlType:
+		return "%9s"
+	case t == StataFloat64Type:
+		return "%10.0g"
+	case t == StataFloat32Type:
+		return "%9.0g"
+	case t == StataInt32Type:
+		return "%12.0g"
+	default:
+		return "%8.0g"
+	}
+}
+
+// gso is a strl value to be written to the strls section.
+type gso struct {
+	v uint32
+	o uint64
+	s string
+}
+
+// strlTable holds the strl values to be written to the strls section.
+// Repeated values share a key.
+type strlTable struct {
+	keys map[string]uint64
+	gsos []gso
+}
+
+// key returns the key that refers to the strl value s, from row i of
+// variable j.  The key is v in the low 2 bytes and o in the high 6
+// bytes, with (0, 0) for empty strings.
+func (st *strlTable) key(s string, j, i int) uint64 {
+
+	if s == "" {
+		return 0
+	}
+	if st.keys == nil {
+		st.keys = make(map[string]uint64)
+	}
+
+	key, ok := st.keys[s]
+	if !ok {
+		g := gso{v: uint32(j + 1), o: uint64(i + 1), s: s}
+		key = uint64(g.v) | g.o<<16
+		st.keys[s] = key
+		st.gsos = append(st.gsos, g)
+	}
+
+	return key
+}
+
+// putStrlKey stores the strl key returned by strlTable.key in b, as
+// v in 2 bytes followed by o in 6 bytes, each in the byte order of the
+// file.
+func (wtr *StataWriter) putStrlKey(b []byte, key uint64) {
+
+	var o [8]byte
+	wtr.ByteOrder.PutUint16(b[0:2], uint16(key))
+	wtr.ByteOrder.PutUint64(o[:], key>>16)
+	if wtr.ByteOrder == binary.BigEndian {
+		copy(b[2:8], o[2:8])
+	} else {
+		copy(b[2:8], o[0:6])
+	}
+}
+
+// putNumeric appends the value x of a numeric variable of type t to
+// b.  If miss is true, the missing value with extended code ext (0
+// for the system missing value) is written instead.
+func (wtr *StataWriter) putNumeric(b *bytes.Buffer, t ColumnTypeT, x float64, miss bool, ext int) {
+
+	bo := wtr.ByteOrder
+	var b8 [8]byte
+
+	switch t {
+	case StataFloat64Type:
+		u := stataMissingFloat64 + uint64(ext)<<40
+		if !miss {
+			u = math.Float64bits(x)
+		}
+		bo.PutUint64(b8[:], u)
+		b.Write(b8[:])
+	case StataFloat32Type:
+		u := stataMissingFloat32 + uint32(ext)<<11
+		if !miss {
+			u = math.Float32bits(float32(x))
+		}
+		bo.PutUint32(b8[:], u)
+		b.Write(b8[0:4])
+	case StataInt32Type:
+		u := stataMissingInt32 + int32(ext)
+		if !miss {
+			u = int32(x)
+		}
+		bo.PutUint32(b8[:], uint32(u))
+		b.Write(b8[0:4])
+	case StataInt16Type:
+		u := stataMissingInt16 + int16(ext)
+		if !miss {
+			u = int16(x)
+		}
+		bo.PutUint16(b8[:], uint16(u))
+		b.Write(b8[0:2])
+	case StataInt8Type:
+		u := stataMissingInt8 + int8(ext)
+		if !miss {
+			u = int8(x)
+		}
+		b.WriteByte(uint8(u))
+	}
+}
+
+// writeData writes the data section, and returns the strl values
+// that are referred to in the data section.
+func (wtr *StataWriter) writeData(vartypes []ColumnTypeT) ([]gso, error) {
+
+	var b bytes.Buffer
+	b.WriteString("<data>")
+
+	var strls strlTable
+	b8 := make([]byte, 8)
+
+	for i := 0; i < wtr.rowCount; i++ {
+		for j, t := range vartypes {
+			miss := wtr.missing[j][i]
+
+			// Extended missing values follow the system missing
+			// value.
+			var ext int
+			if codes := wtr.columns[j].MissingCodes(); miss && codes != nil {
+				if c := codes[i]; c > MissingSystem && c <= MissingSystem+26 {
+					ext = int(c - MissingSystem)
+				}
+			}
+
+			switch {
+			case t <= 2045:
+				field := make([]byte, t)
+				if !miss {
+					copy(field, wtr.columns[j].Data().([]string)[i])
+				}
+				b.Write(field)
+			case t == StataStrlType:
+				var key uint64
+				if !miss {
+					key = strls.key(wtr.columns[j].Data().([]string)[i], j, i)
+				}
+				wtr.putStrlKey(b8, key)
+				b.Write(b8)
+			default:
+				wtr.putNumeric(&b, t, wtr.values[j][i], miss, ext)
+			}
+		}
+
+		if b.Len() > 1<<16 {
+			if err := wtr.emit(b.Bytes()); err != nil {
+				return nil, err
+			}
+			b.Reset()
+		}
+	}
+
+	b.WriteString("</data>")
+	if err := wtr.emit(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return strls.gsos, nil
+}
+
+// writeStrls writes the strls section, with each value stored as a
+// null-terminated text GSO.
+func (wtr *StataWriter) writeStrls(gsos []gso) error {
+
+	var b bytes.Buffer
+	b.WriteString("<strls>")
+
+	for _, g := range gsos {
+		b.WriteString("GSO")
+		binary.Write(&b, wtr.ByteOrder, g.v)
+		binary.Write(&b, wtr.ByteOrder, g.o)
+		b.WriteByte(130)
+		binary.Write(&b, wtr.ByteOrder, uint32(len(g.s)+1))
+		b.WriteString(g.s)
+		b.WriteByte(0)
+
+		if b.Len() > 1<<16 {
+			if err := wtr.emit(b.Bytes()); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+	}
+
+	b.WriteString("</strls>")
+	return wtr.emit(b.Bytes())
+}
+
+// writeValueLabels writes the value labels section, with one <lbl>
+// element for each label set, in order of name.
+func (wtr *StataWriter) writeValueLabels() error {
+
+	var names []string
+	for na := range wtr.ValueLabels {
+		names = append(names, na)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString("<value_labels>")
+
+	for _, na := range names {
+		labels := wtr.ValueLabels[na]
+
+		var vals []int32
+		for v := range labels {
+			vals = append(vals, v)
+		}
+		sort.Slice(vals, func(i, j int) bool { return vals[i] < vals[j] })
+
+		// The text of the labels is packed, with each label null
+		// terminated.
+		var txt bytes.Buffer
+		off := make([]uint32, len(vals))
+		for i, v := range vals {
+			off[i] = uint32(txt.Len())
+			txt.WriteString(labels[v])
+			txt.WriteByte(0)
+		}
+
+		b.WriteString("<lbl>")
+		binary.Write(&b, wtr.ByteOrder, uint32(8+8*len(vals)+txt.Len()))
+		name := make([]byte, 129)
+		copy(name, na)
+		b.Write(name)
+		b.Write(make([]byte, 3))
+		binary.Write(&b, wtr.ByteOrder, uint32(len(vals)))
+		binary.Write(&b, wtr.ByteOrder, uint32(txt.Len()))
+		binary.Write(&b, wtr.ByteOrder, off)
+		binary.Write(&b, wtr.ByteOrder, vals)
+		b.Write(txt.Bytes())
+		b.WriteString("</lbl>")
+	}
+
+	b.WriteString("</value_labels>")
+	return wtr.emit(b.Bytes())
+}
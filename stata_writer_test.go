@@ -0,0 +1,340 @@
+package datareader
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAndRead writes the columns to a temporary file with the given
+// writer settings, and returns a reader for the file.
+func writeAndRead(t *testing.T, cols []*Series, setup func(*StataWriter)) (*StataReader, func()) {
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+
+	wtr, err := NewStataWriter(f, cols)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if setup != nil {
+		setup(wtr)
+	}
+	if err := wtr.Write(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	rdr, err := NewStataReader(f)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+
+	return rdr, cleanup
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+
+	for _, fname := range []string{"test1_117.dta", "test1_118.dta", "test2_118.dta",
+		"stata2_117.dta", "stata3_117.dta", "stata4_117.dta", "stata12_117.dta", "stata14_118.dta"} {
+
+		r, err := os.Open(filepath.Join("test_files", "data", fname))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr, err := NewStataReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rdr.ConvertDates = false
+		rdr.InsertCategoryLabels = false
+		ds, err := rdr.Read(-1)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rdr2, cleanup := writeAndRead(t, ds, func(w *StataWriter) {
+			w.DatasetLabel = rdr.DatasetLabel
+			w.Formats = rdr.Formats
+			w.ColumnTypes = rdr.ColumnTypes()
+			w.ValueLabels = rdr.ValueLabels
+			w.ValueLabelNames = rdr.ValueLabelNames
+		})
+		rdr2.ConvertDates = false
+		rdr2.InsertCategoryLabels = false
+		ds2, err := rdr2.Read(-1)
+		cleanup()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rdr2.FormatVersion != 118 || rdr2.DatasetLabel != rdr.DatasetLabel || rdr2.RowCount() != rdr.RowCount() {
+			t.Errorf("%s: header differs after writing", fname)
+		}
+		for j := range ds {
+			if ds2[j].Name != ds[j].Name || rdr2.Formats[j] != rdr.Formats[j] ||
+				rdr2.ColumnTypes()[j] != rdr.ColumnTypes()[j] || rdr2.ValueLabelNames[j] != rdr.ValueLabelNames[j] {
+				t.Errorf("%s: metadata of column %s differs after writing", fname, ds[j].Name)
+			}
+			if eq, i := ds[j].AllEqual(ds2[j]); !eq {
+				t.Errorf("%s: column %s differs at row %d after writing", fname, ds[j].Name, i)
+			}
+		}
+	}
+}
+
+func TestWriterTypes(t *testing.T) {
+
+	t1 := time.Date(2019, 3, 4, 5, 6, 7, 8e6, time.UTC)
+	t2 := time.Date(1911, 1, 2, 0, 0, 0, 0, time.UTC)
+	long := strings.Repeat("x", 3000)
+
+	var cols []*Series
+	for _, c := range []struct {
+		name string
+		data interface{}
+		miss []bool
+	}{
+		{"f64", []float64{1.5, 0, -2}, []bool{false, true, false}},
+		{"f32", []float32{1.5, 2, 3}, nil},
+		{"i64", []int64{1, -5, 3000000000}, nil},
+		{"i16", []int16{1, 2, 3}, []bool{true, false, false}},
+		{"i8", []int8{1, 101, 3}, nil},
+		{"b", []bool{true, false, true}, nil},
+		{"s", []string{"a", "bcd", ""}, nil},
+		{"strl", []string{long, "", long}, nil},
+		{"t", []time.Time{t1, t2, t1}, nil},
+	} {
+		s, err := NewSeries(c.name, c.data, c.miss)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cols = append(cols, s)
+	}
+
+	rdr, cleanup := writeAndRead(t, cols, nil)
+	defer cleanup()
+
+	expected := []ColumnTypeT{StataFloat64Type, StataFloat32Type, StataFloat64Type, StataInt16Type,
+		StataInt16Type, StataInt8Type, 3, StataStrlType, StataFloat64Type}
+	for j, ct := range rdr.ColumnTypes() {
+		if ct != expected[j] {
+			t.Errorf("column %d has type %d, expected %d", j, ct, expected[j])
+		}
+	}
+	if rdr.Formats[6] != "%3s" || rdr.Formats[8] != "%tc" {
+		t.Errorf("unexpected formats %v", rdr.Formats)
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m := ds[0].Missing(); !m[1] || m[0] {
+		t.Errorf("missing values not preserved")
+	}
+	if x := ds[2].Data().([]float64); x[2] != 3000000000 {
+		t.Errorf("unexpected value %v", x[2])
+	}
+	if x := ds[4].Data().([]int16); x[1] != 101 {
+		t.Errorf("unexpected value %v", x[1])
+	}
+	if x := ds[7].Data().([]string); x[0] != long || x[1] != "" || x[2] != long {
+		t.Errorf("strls not preserved")
+	}
+	if x := ds[8].Data().([]time.Time); !x[0].Equal(t1) || !x[1].Equal(t2) {
+		t.Errorf("unexpected times %v", x)
+	}
+}
+
+func TestWriterErrors(t *testing.T) {
+
+	a, _ := NewSeries("a", []float64{1, 2}, nil)
+	b, _ := NewSeries("b", []float64{1}, nil)
+	if _, err := NewStataWriter(nil, []*Series{a, b}); err == nil {
+		t.Errorf("expected an error for columns of different lengths")
+	}
+	if _, err := NewStataWriter(nil, []*Series{a, a}); err == nil {
+		t.Errorf("expected an error for duplicate names")
+	}
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	wtr, err := NewStataWriter(f, []*Series{a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wtr.ColumnTypes = []ColumnTypeT{StataInt8Type}
+	a.Data().([]float64)[0] = 1.5
+	if err := wtr.Write(); err == nil {
+		t.Errorf("expected an error for a non-integer value in a byte variable")
+	}
+}
+
+func TestWriterValueLabels(t *testing.T) {
+
+	a, _ := NewSeries("a", []int8{1, 2, 1, 3}, nil)
+	b, _ := NewSeries("b", []float64{1, 2, 1, 3}, nil)
+	c, _ := NewSeries("c", []int16{-1, 0, 1, 1000}, nil)
+
+	labels := map[string]map[int32]string{
+		"yesno": {1: "yes", 2: "no"},
+		"scale": {-1: "low", 0: "", 1000: "very high"},
+	}
+
+	rdr, cleanup := writeAndRead(t, []*Series{a, b, c}, func(w *StataWriter) {
+		w.ValueLabels = labels
+		w.ValueLabelNames = []string{"yesno", "", "scale"}
+	})
+	defer cleanup()
+
+	if rdr.ValueLabelNames[0] != "yesno" || rdr.ValueLabelNames[1] != "" || rdr.ValueLabelNames[2] != "scale" {
+		t.Errorf("got value label names %v", rdr.ValueLabelNames)
+	}
+	if len(rdr.ValueLabels) != len(labels) {
+		t.Errorf("got %d label sets, expected %d", len(rdr.ValueLabels), len(labels))
+	}
+	for na, mp := range labels {
+		if len(rdr.ValueLabels[na]) != len(mp) {
+			t.Errorf("%s: got %v, expected %v", na, rdr.ValueLabels[na], mp)
+		}
+		for k, v := range mp {
+			if rdr.ValueLabels[na][k] != v {
+				t.Errorf("%s: got %q for %d, expected %q", na, rdr.ValueLabels[na][k], k, v)
+			}
+		}
+	}
+
+	ds, err := rdr.Read(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := ds[0].Data().([]string); x[0] != "yes" || x[1] != "no" || x[3] != "3" {
+		t.Errorf("got labels %v", x)
+	}
+}
+
+func TestWriterVariableLabels(t *testing.T) {
+
+	a, _ := NewSeries("a", []float64{1, 2}, nil)
+	b, _ := NewSeries("b", []string{"x", "y"}, nil)
+	c, _ := NewSeries("c", []int8{1, 2}, nil)
+	d, _ := NewSeries("d", []int8{1, 2}, nil)
+
+	long := strings.Repeat("abcd", 30)
+	wide := strings.Repeat("\u00e9\u4e2d\U0001f600", 40)
+	rdr, cleanup := writeAndRead(t, []*Series{a, b, c, d}, func(w *StataWriter) {
+		w.VariableLabels = []string{"first variable", "", long, wide}
+	})
+	defer cleanup()
+
+	expected := []string{"first variable", "", long[0:80], string([]rune(wide)[0:80])}
+	for j, x := range expected {
+		if rdr.ColumnNamesLong[j] != x {
+			t.Errorf("column %d: got label %q, expected %q", j, rdr.ColumnNamesLong[j], x)
+		}
+	}
+
+	// A label of 80 four byte characters fills the field
+	if x := truncateLabel(strings.Repeat("\U0001f600", 81)); len(x) != 320 {
+		t.Errorf("got a label of %d bytes", len(x))
+	}
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	wtr, err := NewStataWriter(f, []*Series{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wtr.VariableLabels = []string{"one"}
+	if err := wtr.Write(); err == nil {
+		t.Errorf("expected an error for the wrong number of variable labels")
+	}
+}
+
+func TestWriterByteOrder(t *testing.T) {
+
+	long := strings.Repeat("z", 3000)
+	day := time.Date(2001, 2, 3, 0, 0, 0, 0, time.UTC)
+	a, _ := NewSeries("a", []int8{1, 2, 1}, nil)
+	b, _ := NewSeries("b", []string{"x", "yy", ""}, nil)
+	c, _ := NewSeries("c", []float64{1.5, -2, 1e10}, []bool{false, true, false})
+	d, _ := NewSeries("d", []string{long, "", "short"}, nil)
+	e, _ := NewSeries("e", []time.Time{day, day.AddDate(0, 0, 1), {}}, []bool{false, false, true})
+	f16, _ := NewSeries("f", []int16{-300, 300, 0}, nil)
+	cols := []*Series{a, b, c, d, e, f16}
+
+	var ds [][]*Series
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		rdr, cleanup := writeAndRead(t, cols, func(w *StataWriter) {
+			w.ByteOrder = bo
+			w.ValueLabels = map[string]map[int32]string{"ab": {1: "a", 2: "b"}}
+			w.ValueLabelNames = []string{"ab", "", "", "", "", ""}
+			w.ColumnTypes = []ColumnTypeT{StataInt8Type, 2, StataFloat64Type, StataStrlType, StataFloat64Type, StataInt16Type}
+		})
+		defer cleanup()
+		if rdr.ByteOrder != bo {
+			t.Errorf("file has byte order %v, expected %v", rdr.ByteOrder, bo)
+		}
+		x, err := rdr.Read(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, x)
+	}
+
+	if eq, j, i := SeriesArray(ds[0]).AllEqual(ds[1]); !eq {
+		t.Errorf("column %d differs at row %d", j, i)
+	}
+	if x := ds[1][0].Data().([]string); x[0] != "a" || x[1] != "b" {
+		t.Errorf("unexpected labels %v", x)
+	}
+	if x := ds[1][3].Data().([]string); x[0] != long || x[2] != "short" {
+		t.Errorf("unexpected strls")
+	}
+	if x := ds[1][4].Data().([]time.Time); !x[0].Equal(day) || !ds[1][4].IsMissing(2) {
+		t.Errorf("unexpected dates %v", x)
+	}
+
+	f, err := ioutil.TempFile("", "datareader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	wtr, err := NewStataWriter(f, cols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wtr.ByteOrder = nil
+	if err := wtr.Write(); err == nil {
+		t.Errorf("expected an error for an invalid byte order")
+	}
+}